@@ -18,7 +18,11 @@ limitations under the License.
 package v1alpha1
 
 import (
-	"cloud.google.com/go/storage"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -44,20 +48,317 @@ type BucketSpec struct {
 	// Defines if we gcs bucket should be delete with the CR.
 	// +kubebuilder:validation:Required
 	RemoveOnDelete bool `json:"removeOnDelete,omitempty"` //
+
+	// Defines if uniform bucket-level access is enabled, access to the
+	// objects is then granted only with IAM.
+	// https://cloud.google.com/storage/docs/uniform-bucket-level-access
+	// +optional
+	UniformBucketLevelAccess *bool `json:"uniformBucketLevelAccess,omitempty"`
+
+	// Defines the labels of the GCS bucket, keys prefixed with
+	// bucket-storage-k8s-riveiro-io- are reserved to the operator.
+	// https://cloud.google.com/storage/docs/key-terms#bucket-labels
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Defines the minimum time objects in the bucket must be retained.
+	// https://cloud.google.com/storage/docs/bucket-lock
+	// +optional
+	RetentionPeriod *metav1.Duration `json:"retentionPeriod,omitempty"`
+
+	// Defines if object versioning is enabled, noncurrent versions of the
+	// objects are kept when they are overwritten or deleted.
+	// https://cloud.google.com/storage/docs/object-versioning
+	// +optional
+	Versioning *bool `json:"versioning,omitempty"`
+
+	// Defines the rules applied to the objects of the bucket by age or
+	// number of newer versions.
+	// https://cloud.google.com/storage/docs/lifecycle
+	// +optional
+	Lifecycle []LifecycleRule `json:"lifecycle,omitempty"`
+
+	// Defines if a pre-existing GCS bucket not created by this resource can
+	// be adopted. Defaults to Never.
+	// +kubebuilder:validation:Enum=Never;IfUnowned;Force
+	// +optional
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+
+	// Defines what to do with an adopted bucket. Reconcile applies the spec
+	// to the bucket, Import only records the live attributes in the status
+	// for review. Defaults to Import.
+	// +kubebuilder:validation:Enum=Reconcile;Import
+	// +optional
+	AdoptionMode AdoptionMode `json:"adoptionMode,omitempty"`
+
+	// Provider config, in the namespace of the bucket, with the credentials
+	// used to manage the bucket. The operator credentials are used if not
+	// set.
+	// +optional
+	ProviderConfigRef *corev1.LocalObjectReference `json:"providerConfigRef,omitempty"`
+
+	// Defines how often the GCS bucket is checked for changes made outside
+	// of the operator, e.g. in the console. Defaults to the resync interval
+	// of the operator, zero disables the periodic check.
+	// +optional
+	ResyncInterval *metav1.Duration `json:"resyncInterval,omitempty"`
+
+	// Defines what to do when the GCS bucket disappears after being
+	// created or adopted. Recreate creates it again, MarkLost flags the
+	// resource with the Lost condition. Defaults to Recreate.
+	// +kubebuilder:validation:Enum=Recreate;MarkLost
+	// +optional
+	RecreatePolicy RecreatePolicy `json:"recreatePolicy,omitempty"`
+
+	// Members granted to write the objects of the GCS bucket, e.g.
+	// serviceAccount:app@project.iam.gserviceaccount.com. The operator
+	// grants them roles/storage.objectCreator and records the grants in the
+	// status, grants made outside of the operator are never changed. Not
+	// supported by backends without IAM policies, like s3.
+	// +optional
+	Writers []string `json:"writers,omitempty"`
+
+	// Defines soft limits of the usage of the GCS bucket, checked against
+	// the usage measured by the operator. Exceeding them flags the resource
+	// with the LimitExceeded condition, writes are only blocked as defined
+	// by the enforcement.
+	// +optional
+	Limits *BucketLimits `json:"limits,omitempty"`
+}
+
+// BucketLimits defines the soft limits of the usage of a bucket.
+type BucketLimits struct {
+	// Maximum bytes stored in the bucket, unlimited if not set.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxBytes *int64 `json:"maxBytes,omitempty"`
+
+	// Maximum number of objects of the bucket, unlimited if not set.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxObjects *int64 `json:"maxObjects,omitempty"`
+
+	// Defines what to do when a limit is exceeded besides flagging the
+	// resource with the LimitExceeded condition. RemoveWriteGrants revokes
+	// the grants of the writers made by the operator until the usage drops
+	// below the limits. Defaults to None.
+	// +kubebuilder:validation:Enum=None;RemoveWriteGrants
+	// +optional
+	Enforcement LimitEnforcement `json:"enforcement,omitempty"`
 }
 
+// LifecycleAction is the action of a lifecycle rule.
+type LifecycleAction string
+
+const (
+	// LifecycleActionDelete deletes the objects matching the rule.
+	LifecycleActionDelete LifecycleAction = "Delete"
+	// LifecycleActionSetStorageClass changes the storage class of the
+	// objects matching the rule.
+	LifecycleActionSetStorageClass LifecycleAction = "SetStorageClass"
+)
+
+// LifecycleRule defines an action applied to the objects matching all the
+// conditions of the rule.
+type LifecycleRule struct {
+	// +kubebuilder:validation:Enum=Delete;SetStorageClass
+	Action LifecycleAction `json:"action"`
+
+	// Storage class the objects are moved to, required by the
+	// SetStorageClass action.
+	// +optional
+	StorageClass string `json:"storageClass,omitempty"`
+
+	// Matches the objects older than the number of days.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	AgeDays int64 `json:"ageDays,omitempty"`
+
+	// Matches the noncurrent versions with at least this number of newer
+	// versions, only for buckets with versioning.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	NumNewerVersions int64 `json:"numNewerVersions,omitempty"`
+}
+
+// AdoptionPolicy defines if a pre-existing GCS bucket can be adopted.
+type AdoptionPolicy string
+
+const (
+	// AdoptionPolicyNever never adopts pre-existing buckets.
+	AdoptionPolicyNever AdoptionPolicy = "Never"
+	// AdoptionPolicyIfUnowned adopts pre-existing buckets without an owner label.
+	AdoptionPolicyIfUnowned AdoptionPolicy = "IfUnowned"
+	// AdoptionPolicyForce adopts pre-existing buckets even if they are owned
+	// by another resource.
+	AdoptionPolicyForce AdoptionPolicy = "Force"
+)
+
+// AdoptionMode defines what happens with an adopted GCS bucket.
+type AdoptionMode string
+
+const (
+	// AdoptionModeReconcile applies the spec to the adopted bucket.
+	AdoptionModeReconcile AdoptionMode = "Reconcile"
+	// AdoptionModeImport records the adopted bucket attributes in the status.
+	AdoptionModeImport AdoptionMode = "Import"
+)
+
+// RecreatePolicy defines what happens when the GCS bucket disappears.
+type RecreatePolicy string
+
+const (
+	// RecreatePolicyRecreate creates the bucket again.
+	RecreatePolicyRecreate RecreatePolicy = "Recreate"
+	// RecreatePolicyMarkLost flags the resource as lost.
+	RecreatePolicyMarkLost RecreatePolicy = "MarkLost"
+)
+
+// LimitEnforcement defines what happens when a bucket exceeds its limits.
+type LimitEnforcement string
+
+const (
+	// LimitEnforcementNone only flags the resource.
+	LimitEnforcementNone LimitEnforcement = "None"
+	// LimitEnforcementRemoveWriteGrants revokes the write grants made by
+	// the operator.
+	LimitEnforcementRemoveWriteGrants LimitEnforcement = "RemoveWriteGrants"
+)
+
 // BucketStatus defines the observed state of Bucket
 type BucketStatus struct {
 	GCSBucketRef string `json:"gcsBucketRef,omitempty"`
+
+	// Attributes of the GCS bucket at the moment of the adoption.
+	// +optional
+	ImportedAttributes *BucketAttributes `json:"importedAttributes,omitempty"`
+
+	// Latest observations of the bucket state.
+	// +optional
+	Conditions []BucketCondition `json:"conditions,omitempty"`
+
+	// Objects and bytes stored in the GCS bucket, measured when usage
+	// collection is enabled.
+	// +optional
+	Usage *BucketUsage `json:"usage,omitempty"`
+
+	// Writers granted roles/storage.objectCreator on the GCS bucket by the
+	// operator. Only these grants are revoked, when the writers change or
+	// the limits are enforced.
+	// +optional
+	ManagedWriters []string `json:"managedWriters,omitempty"`
+}
+
+// BucketUsage is the number of objects and the bytes stored in a bucket.
+type BucketUsage struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+
+	// True when the usage was extrapolated from a sample of the objects.
+	// +optional
+	Estimated bool `json:"estimated,omitempty"`
+
+	// Time of the measurement that changed the usage, measurements with the
+	// same usage are not recorded.
+	// +optional
+	LastMeasured metav1.Time `json:"lastMeasured,omitempty"`
+}
+
+// BucketConditionType is the type of a bucket condition.
+type BucketConditionType string
+
+const (
+	// BucketConditionForeignOwner is true when the GCS bucket is owned by a
+	// resource managed from another cluster.
+	BucketConditionForeignOwner BucketConditionType = "ForeignOwner"
+
+	// BucketConditionPolicyViolation is true when the bucket doesn't satisfy
+	// the bucket policies enforced in its namespace.
+	BucketConditionPolicyViolation BucketConditionType = "PolicyViolation"
+
+	// BucketConditionProjectMismatch is true when the project of the bucket
+	// is not the one its namespace is bound to.
+	BucketConditionProjectMismatch BucketConditionType = "ProjectMismatch"
+
+	// BucketConditionQuotaExceeded is true when creating the GCS bucket
+	// would exceed the bucket quotas of the namespace.
+	BucketConditionQuotaExceeded BucketConditionType = "QuotaExceeded"
+
+	// BucketConditionUnsupported is true when the spec defines attributes
+	// the storage backend can't apply, they are ignored.
+	BucketConditionUnsupported BucketConditionType = "Unsupported"
+
+	// BucketConditionBackendError is true when the last operation on the
+	// storage backend failed, the reason classifies the failure.
+	BucketConditionBackendError BucketConditionType = "BackendError"
+
+	// BucketConditionBackendUnavailable is true while the operator stopped
+	// calling the storage backend after repeated failures.
+	BucketConditionBackendUnavailable BucketConditionType = "BackendUnavailable"
+
+	// BucketConditionLost is true when the GCS bucket disappeared and the
+	// recreate policy is MarkLost.
+	BucketConditionLost BucketConditionType = "Lost"
+
+	// BucketConditionLimitExceeded is true when the usage of the GCS bucket
+	// exceeds the limits of the spec.
+	BucketConditionLimitExceeded BucketConditionType = "LimitExceeded"
+
+	// BucketConditionAdoptionRefused is true when the GCS bucket exists and
+	// the adoption policy doesn't allow to take it over.
+	BucketConditionAdoptionRefused BucketConditionType = "AdoptionRefused"
+)
+
+// BucketCondition defines an observation of the bucket state.
+type BucketCondition struct {
+	Type   BucketConditionType    `json:"type"`
+	Status corev1.ConditionStatus `json:"status"`
+
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// BucketAttributes defines the attributes observed in a GCS bucket.
+type BucketAttributes struct {
+	Location     string            `json:"location,omitempty"`
+	StorageClass string            `json:"storageClass,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
 }
 
 // BucketFinalizerName is the name of the bucket finalizer
 const BucketFinalizerName = "bucket.storage.k8s.riveiro.io/finalizer"
 
 // BucketOwnerLabel is a label to ensure we're labeling buckets in GCS
-// with the proper owner and allow tracing.
+// with the proper owner. The value is the owner ID of the resource, older
+// versions of the operator used the name of the resource.
 const BucketOwnerLabel = "bucket-storage-k8s-riveiro-io-owner"
 
+// BucketNamespaceLabel is a label to allow tracing the namespace of the
+// resource owning a GCS bucket.
+const BucketNamespaceLabel = "bucket-storage-k8s-riveiro-io-namespace"
+
+// BucketNameLabel is a label to allow tracing the name of the resource
+// owning a GCS bucket.
+const BucketNameLabel = "bucket-storage-k8s-riveiro-io-name"
+
+// ReservedLabelPrefix is the prefix of the GCS labels managed by the
+// operator.
+const ReservedLabelPrefix = "bucket-storage-k8s-riveiro-io-"
+
+// BucketClusterLabel is a label to ensure a GCS bucket is only managed from
+// the cluster that created it.
+const BucketClusterLabel = "bucket-storage-k8s-riveiro-io-cluster"
+
+// ownerIDLength is the number of hex characters of the owner ID, it must fit
+// in the 63 characters allowed for GCS label values.
+const ownerIDLength = 40
+
 // BucketAnnotation is a annotation to keep tracking of the original
 // bucket created by the resource.
 const BucketAnnotation = "storage.k8s.riveiro.io/bucket"
@@ -93,13 +394,176 @@ func (b *Bucket) RemoveFinalizer(finalizerName string) {
 	b.ObjectMeta.Finalizers = removeString(b.ObjectMeta.Finalizers, finalizerName)
 }
 
-// Owned checks if the resource is owner of the bucket
-func (b *Bucket) Owned(a *storage.BucketAttrs) bool {
-	if _, ok := a.Labels[BucketOwnerLabel]; !ok {
+// OwnerID returns the identifier of the resource as owner of a GCS bucket.
+// It's derived from the cluster, namespace and UID of the resource, so
+// resources with the same name in other namespaces or clusters never share
+// ownership.
+func (b *Bucket) OwnerID(clusterID string) string {
+	sum := sha256.Sum256([]byte(clusterID + "/" + b.GetNamespace() + "/" + string(b.GetUID())))
+
+	return hex.EncodeToString(sum[:])[:ownerIDLength]
+}
+
+// BucketLabels returns the labels of the spec along with the owner labels.
+func (b *Bucket) BucketLabels(clusterID string) map[string]string {
+	labels := map[string]string{}
+	for k, v := range b.Spec.Labels {
+		labels[k] = v
+	}
+
+	for k, v := range b.OwnerLabels(clusterID) {
+		labels[k] = v
+	}
+
+	return labels
+}
+
+// OwnerLabels returns the labels stamped in the GCS bucket owned by the
+// resource.
+func (b *Bucket) OwnerLabels(clusterID string) map[string]string {
+	labels := map[string]string{
+		BucketOwnerLabel:     b.OwnerID(clusterID),
+		BucketNamespaceLabel: labelValue(b.GetNamespace()),
+		BucketNameLabel:      labelValue(b.GetName()),
+	}
+
+	if clusterID != "" {
+		labels[BucketClusterLabel] = ClusterLabelValue(clusterID)
+	}
+
+	return labels
+}
+
+// ForeignCluster checks if the bucket labels mark it as managed from another
+// cluster.
+func ForeignCluster(labels map[string]string, clusterID string) bool {
+	v, ok := labels[BucketClusterLabel]
+	if !ok {
 		return false
 	}
 
-	return a.Labels[BucketOwnerLabel] == b.GetObjectMeta().GetName()
+	return v != ClusterLabelValue(clusterID)
+}
+
+// ClusterLabelValue returns the value of the BucketClusterLabel for the
+// cluster. Identifiers that aren't valid label values are hashed.
+func ClusterLabelValue(clusterID string) string {
+	if v := labelValue(clusterID); v == clusterID {
+		return v
+	}
+
+	sum := sha256.Sum256([]byte(clusterID))
+
+	return hex.EncodeToString(sum[:])[:ownerIDLength]
+}
+
+// Owned checks if the resource is owner of the bucket with the labels
+func (b *Bucket) Owned(labels map[string]string, clusterID string) bool {
+	if _, ok := labels[BucketOwnerLabel]; !ok {
+		return false
+	}
+
+	return labels[BucketOwnerLabel] == b.OwnerID(clusterID)
+}
+
+// LegacyOwned checks if the bucket carries the name-only owner label used by
+// older versions of the operator and the resource is already bound to it.
+func (b *Bucket) LegacyOwned(labels map[string]string) bool {
+	if b.Status.GCSBucketRef != b.Spec.Name {
+		return false
+	}
+
+	return labels[BucketOwnerLabel] == b.GetName()
+}
+
+// CanAdopt checks if the adoption policy allows to take over the bucket
+func (b *Bucket) CanAdopt(labels map[string]string) bool {
+	switch b.Spec.AdoptionPolicy {
+	case AdoptionPolicyForce:
+		return true
+	case AdoptionPolicyIfUnowned:
+		_, ok := labels[BucketOwnerLabel]
+		return !ok
+	}
+
+	return false
+}
+
+// GetCondition returns the condition of the given type, nil if not present.
+func (b *Bucket) GetCondition(t BucketConditionType) *BucketCondition {
+	for i := range b.Status.Conditions {
+		if b.Status.Conditions[i].Type == t {
+			return &b.Status.Conditions[i]
+		}
+	}
+
+	return nil
+}
+
+// SetCondition adds or updates the condition of the given type. Returns true
+// if the status of the resource changed.
+func (b *Bucket) SetCondition(t BucketConditionType, status corev1.ConditionStatus, reason, message string) bool {
+	c := b.GetCondition(t)
+	if c == nil {
+		b.Status.Conditions = append(b.Status.Conditions, BucketCondition{
+			Type:               t,
+			Status:             status,
+			Reason:             reason,
+			Message:            message,
+			LastTransitionTime: metav1.Now(),
+		})
+
+		return true
+	}
+
+	if c.Status == status && c.Reason == reason && c.Message == message {
+		return false
+	}
+
+	if c.Status != status {
+		c.LastTransitionTime = metav1.Now()
+	}
+
+	c.Status = status
+	c.Reason = reason
+	c.Message = message
+
+	return true
+}
+
+// RemoveCondition removes the condition of the given type. Returns true if
+// the condition was present.
+func (b *Bucket) RemoveCondition(t BucketConditionType) bool {
+	for i := range b.Status.Conditions {
+		if b.Status.Conditions[i].Type == t {
+			b.Status.Conditions = append(b.Status.Conditions[:i], b.Status.Conditions[i+1:]...)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// LimitViolations returns the limits of the spec exceeded by the usage
+// measured, none if the usage was not measured.
+func (b *Bucket) LimitViolations() []string {
+	l, u := b.Spec.Limits, b.Status.Usage
+	if l == nil || u == nil {
+		return nil
+	}
+
+	var result []string
+
+	if l.MaxBytes != nil && u.Bytes > *l.MaxBytes {
+		result = append(result, fmt.Sprintf("%d bytes stored, limit is %d", u.Bytes, *l.MaxBytes))
+	}
+
+	if l.MaxObjects != nil && u.Objects > *l.MaxObjects {
+		result = append(result, fmt.Sprintf("%d objects stored, limit is %d", u.Objects, *l.MaxObjects))
+	}
+
+	return result
 }
 
 // IsGCSBucketRefValid check if the resource already has a ref with a
@@ -0,0 +1,206 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newOwnerTestBucket(namespace, uid string) *Bucket {
+	return &Bucket{
+		ObjectMeta: metav1.ObjectMeta{Name: "assets", Namespace: namespace, UID: types.UID(uid)},
+		Spec:       BucketSpec{Name: "assets"},
+	}
+}
+
+func TestOwnerID(t *testing.T) {
+	b := newOwnerTestBucket("default", "uid-1")
+	id := b.OwnerID("cluster-a")
+
+	if len(id) != ownerIDLength || labelValue(id) != id {
+		t.Errorf("OwnerID() = %q, want a label value of %d characters", id, ownerIDLength)
+	}
+
+	tests := []struct {
+		name      string
+		b         *Bucket
+		clusterID string
+		same      bool
+	}{
+		{"same resource", newOwnerTestBucket("default", "uid-1"), "cluster-a", true},
+		{"other cluster", newOwnerTestBucket("default", "uid-1"), "cluster-b", false},
+		{"other namespace", newOwnerTestBucket("other", "uid-1"), "cluster-a", false},
+		{"recreated resource", newOwnerTestBucket("default", "uid-2"), "cluster-a", false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.b.OwnerID(tt.clusterID); (got == id) != tt.same {
+			t.Errorf("%s: OwnerID() = %q, same as %q want %v", tt.name, got, id, tt.same)
+		}
+	}
+}
+
+func TestLegacyOwned(t *testing.T) {
+	tests := []struct {
+		name   string
+		ref    string
+		labels map[string]string
+		want   bool
+	}{
+		{"bound with the name label", "assets", map[string]string{BucketOwnerLabel: "assets"}, true},
+		{"not bound", "", map[string]string{BucketOwnerLabel: "assets"}, false},
+		{"bound to another bucket", "other", map[string]string{BucketOwnerLabel: "assets"}, false},
+		{"owned by another resource", "assets", map[string]string{BucketOwnerLabel: "other"}, false},
+		{"without owner label", "assets", map[string]string{}, false},
+	}
+
+	for _, tt := range tests {
+		b := newOwnerTestBucket("default", "uid-1")
+		b.Status.GCSBucketRef = tt.ref
+
+		if got := b.LegacyOwned(tt.labels); got != tt.want {
+			t.Errorf("%s: LegacyOwned(%v) = %v, want %v", tt.name, tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestCanAdopt(t *testing.T) {
+	owned := map[string]string{BucketOwnerLabel: "other"}
+	unowned := map[string]string{"team": "a"}
+
+	tests := []struct {
+		policy AdoptionPolicy
+		labels map[string]string
+		want   bool
+	}{
+		{"", unowned, false},
+		{AdoptionPolicyNever, unowned, false},
+		{AdoptionPolicyIfUnowned, unowned, true},
+		{AdoptionPolicyIfUnowned, owned, false},
+		{AdoptionPolicyForce, owned, true},
+		{AdoptionPolicyForce, unowned, true},
+	}
+
+	for _, tt := range tests {
+		b := newOwnerTestBucket("default", "uid-1")
+		b.Spec.AdoptionPolicy = tt.policy
+
+		if got := b.CanAdopt(tt.labels); got != tt.want {
+			t.Errorf("CanAdopt(%v) with policy %q = %v, want %v", tt.labels, tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestClusterLabelValue(t *testing.T) {
+	tests := []struct {
+		clusterID string
+		hashed    bool
+	}{
+		{"prod-eu", false},
+		{"cluster_1", false},
+		{"Prod-EU", true},
+		{"gke.europe-west1.prod", true},
+		{strings.Repeat("a", 64), true},
+	}
+
+	for _, tt := range tests {
+		got := ClusterLabelValue(tt.clusterID)
+
+		if labelValue(got) != got {
+			t.Errorf("ClusterLabelValue(%q) = %q, not a valid label value", tt.clusterID, got)
+		}
+
+		if hashed := got != tt.clusterID; hashed != tt.hashed {
+			t.Errorf("ClusterLabelValue(%q) = %q, hashed %v want %v", tt.clusterID, got, hashed, tt.hashed)
+		}
+	}
+
+	// identifiers sanitized to the same value don't share the label value
+	if ClusterLabelValue("Prod-EU") == ClusterLabelValue("prod.eu") {
+		t.Error("expected different label values for different cluster identifiers")
+	}
+}
+
+func TestForeignCluster(t *testing.T) {
+	tests := []struct {
+		name      string
+		labels    map[string]string
+		clusterID string
+		want      bool
+	}{
+		{"without cluster label", map[string]string{BucketOwnerLabel: "owner"}, "prod-eu", false},
+		{"same cluster", map[string]string{BucketClusterLabel: "prod-eu"}, "prod-eu", false},
+		{"same hashed cluster", map[string]string{BucketClusterLabel: ClusterLabelValue("Prod.EU")}, "Prod.EU", false},
+		{"other cluster", map[string]string{BucketClusterLabel: "prod-us"}, "prod-eu", true},
+		{"operator without cluster ID", map[string]string{BucketClusterLabel: "prod-eu"}, "", true},
+	}
+
+	for _, tt := range tests {
+		if got := ForeignCluster(tt.labels, tt.clusterID); got != tt.want {
+			t.Errorf("%s: ForeignCluster(%v, %q) = %v, want %v", tt.name, tt.labels, tt.clusterID, got, tt.want)
+		}
+	}
+}
+
+func TestOwnerLabelsClusterLabel(t *testing.T) {
+	b := newOwnerTestBucket("default", "uid-1")
+
+	if v, ok := b.OwnerLabels("Prod.EU")[BucketClusterLabel]; !ok || v != ClusterLabelValue("Prod.EU") {
+		t.Errorf("cluster label = %q, want %q", v, ClusterLabelValue("Prod.EU"))
+	}
+
+	if _, ok := b.OwnerLabels("")[BucketClusterLabel]; ok {
+		t.Error("expected no cluster label without cluster ID")
+	}
+}
+
+func TestImpersonationAllowed(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: "tenant-a",
+		Annotations: map[string]string{
+			ImpersonateServiceAccountAnnotation: "tenant-a@project.iam.gserviceaccount.com",
+			AllowedServiceAccountsAnnotation:    "tenant-a-logs@project.iam.gserviceaccount.com, tenant-a-ci@project.iam.gserviceaccount.com",
+		},
+	}}
+
+	tests := []struct {
+		serviceAccount string
+		want           bool
+	}{
+		{"tenant-a@project.iam.gserviceaccount.com", true},
+		{"Tenant-A@project.iam.gserviceaccount.com", true},
+		{"tenant-a-ci@project.iam.gserviceaccount.com", true},
+		{"tenant-b@project.iam.gserviceaccount.com", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := ImpersonationAllowed(ns, tt.serviceAccount); got != tt.want {
+			t.Errorf("ImpersonationAllowed(%q) = %v, want %v", tt.serviceAccount, got, tt.want)
+		}
+	}
+
+	if ImpersonationAllowed(&corev1.Namespace{}, "tenant-a@project.iam.gserviceaccount.com") {
+		t.Error("expected no service account allowed without annotations")
+	}
+}
@@ -0,0 +1,389 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// bucketNameRegexp matches names made of lowercase letters, numbers, dashes,
+// underscores and dots that start and end with a letter or a number.
+// https://cloud.google.com/storage/docs/naming-buckets
+var bucketNameRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9_.-]*[a-z0-9])?$`)
+
+// labelKeyRegexp and labelValueRegexp match the GCS label requirements.
+// https://cloud.google.com/storage/docs/key-terms#bucket-labels
+var (
+	labelKeyRegexp   = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+	labelValueRegexp = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+)
+
+// LocationType is the kind of a GCS location.
+type LocationType string
+
+const (
+	// LocationTypeRegion is a single region location.
+	LocationTypeRegion LocationType = "region"
+	// LocationTypeDualRegion is a predefined pair of regions.
+	LocationTypeDualRegion LocationType = "dual-region"
+	// LocationTypeMultiRegion is a large geographic area.
+	LocationTypeMultiRegion LocationType = "multi-region"
+)
+
+// Locations are the GCS locations known by the operator.
+// https://cloud.google.com/storage/docs/locations
+var Locations = map[string]LocationType{
+	"ASIA":                    LocationTypeMultiRegion,
+	"EU":                      LocationTypeMultiRegion,
+	"US":                      LocationTypeMultiRegion,
+	"ASIA1":                   LocationTypeDualRegion,
+	"EUR4":                    LocationTypeDualRegion,
+	"NAM4":                    LocationTypeDualRegion,
+	"ASIA-EAST1":              LocationTypeRegion,
+	"ASIA-EAST2":              LocationTypeRegion,
+	"ASIA-NORTHEAST1":         LocationTypeRegion,
+	"ASIA-NORTHEAST2":         LocationTypeRegion,
+	"ASIA-NORTHEAST3":         LocationTypeRegion,
+	"ASIA-SOUTH1":             LocationTypeRegion,
+	"ASIA-SOUTH2":             LocationTypeRegion,
+	"ASIA-SOUTHEAST1":         LocationTypeRegion,
+	"ASIA-SOUTHEAST2":         LocationTypeRegion,
+	"AUSTRALIA-SOUTHEAST1":    LocationTypeRegion,
+	"AUSTRALIA-SOUTHEAST2":    LocationTypeRegion,
+	"EUROPE-CENTRAL2":         LocationTypeRegion,
+	"EUROPE-NORTH1":           LocationTypeRegion,
+	"EUROPE-WEST1":            LocationTypeRegion,
+	"EUROPE-WEST2":            LocationTypeRegion,
+	"EUROPE-WEST3":            LocationTypeRegion,
+	"EUROPE-WEST4":            LocationTypeRegion,
+	"EUROPE-WEST6":            LocationTypeRegion,
+	"NORTHAMERICA-NORTHEAST1": LocationTypeRegion,
+	"NORTHAMERICA-NORTHEAST2": LocationTypeRegion,
+	"SOUTHAMERICA-EAST1":      LocationTypeRegion,
+	"US-CENTRAL1":             LocationTypeRegion,
+	"US-EAST1":                LocationTypeRegion,
+	"US-EAST4":                LocationTypeRegion,
+	"US-WEST1":                LocationTypeRegion,
+	"US-WEST2":                LocationTypeRegion,
+	"US-WEST3":                LocationTypeRegion,
+	"US-WEST4":                LocationTypeRegion,
+}
+
+// StorageClasses are the GCS storage classes known by the operator mapped to
+// the location types where they can be used.
+// https://cloud.google.com/storage/docs/storage-classes
+var StorageClasses = map[string][]LocationType{
+	"STANDARD":                     {LocationTypeRegion, LocationTypeDualRegion, LocationTypeMultiRegion},
+	"NEARLINE":                     {LocationTypeRegion, LocationTypeDualRegion, LocationTypeMultiRegion},
+	"COLDLINE":                     {LocationTypeRegion, LocationTypeDualRegion, LocationTypeMultiRegion},
+	"ARCHIVE":                      {LocationTypeRegion, LocationTypeDualRegion, LocationTypeMultiRegion},
+	"MULTI_REGIONAL":               {LocationTypeDualRegion, LocationTypeMultiRegion},
+	"REGIONAL":                     {LocationTypeRegion},
+	"DURABLE_REDUCED_AVAILABILITY": {LocationTypeRegion, LocationTypeDualRegion, LocationTypeMultiRegion},
+}
+
+// ValidateBucketName checks the name against the GCS bucket naming rules.
+func ValidateBucketName(name string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	if name == "" {
+		return append(errs, field.Required(path, "bucket name is required"))
+	}
+
+	if !bucketNameRegexp.MatchString(name) {
+		errs = append(errs, field.Invalid(path, name,
+			"must contain only lowercase letters, numbers, dashes, underscores and dots, and start and end with a letter or number"))
+	}
+
+	if len(name) < 3 {
+		errs = append(errs, field.Invalid(path, name, "must be at least 3 characters"))
+	}
+
+	if strings.Contains(name, ".") {
+		if len(name) > 222 {
+			errs = append(errs, field.Invalid(path, name, "names containing dots must be at most 222 characters"))
+		}
+
+		for _, c := range strings.Split(name, ".") {
+			if c == "" || len(c) > 63 {
+				errs = append(errs, field.Invalid(path, name, "each dot-separated component must be between 1 and 63 characters"))
+
+				break
+			}
+		}
+
+		if net.ParseIP(name) != nil {
+			errs = append(errs, field.Invalid(path, name, "must not be an IP address"))
+		}
+	} else if len(name) > 63 {
+		errs = append(errs, field.Invalid(path, name, "must be at most 63 characters"))
+	}
+
+	if strings.HasPrefix(name, "goog") {
+		errs = append(errs, field.Invalid(path, name, `must not start with the "goog" prefix`))
+	}
+
+	return errs
+}
+
+// LocationValidator checks the location and storage class of a bucket are
+// supported by the storage backend.
+// +kubebuilder:object:generate=false
+type LocationValidator func(location, storageClass string, path *field.Path) field.ErrorList
+
+// RegionLocation returns a LocationValidator that only accepts the region,
+// it's used with the backends that create the buckets in the region of the
+// service. The storage class must be a known one.
+func RegionLocation(region string) LocationValidator {
+	return func(location, storageClass string, path *field.Path) field.ErrorList {
+		var errs field.ErrorList
+
+		if !strings.EqualFold(location, region) {
+			errs = append(errs, field.NotSupported(path.Child("location"), location, []string{strings.ToUpper(region)}))
+		}
+
+		if _, ok := StorageClasses[strings.ToUpper(storageClass)]; storageClass != "" && !ok {
+			errs = append(errs, field.NotSupported(path.Child("storageClass"), storageClass, storageClassNames()))
+		}
+
+		return errs
+	}
+}
+
+// ValidateLocation checks the location and storage class are known GCS ones
+// and can be used together, it's the LocationValidator of the GCS backend.
+func ValidateLocation(location, storageClass string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	lt, ok := Locations[strings.ToUpper(location)]
+	if !ok {
+		errs = append(errs, field.NotSupported(path.Child("location"), location, locationNames()))
+	}
+
+	if storageClass == "" {
+		return errs
+	}
+
+	types, ok := StorageClasses[strings.ToUpper(storageClass)]
+	if !ok {
+		return append(errs, field.NotSupported(path.Child("storageClass"), storageClass, storageClassNames()))
+	}
+
+	if lt != "" && !containsLocationType(types, lt) {
+		errs = append(errs, field.Invalid(path.Child("storageClass"), storageClass,
+			fmt.Sprintf("storage class can't be used in %s location %s", lt, location)))
+	}
+
+	return errs
+}
+
+// ValidateLifecycle checks every rule has a condition and the storage class
+// required by its action.
+func ValidateLifecycle(rules []LifecycleRule, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	for i, r := range rules {
+		p := path.Index(i)
+
+		if r.AgeDays == 0 && r.NumNewerVersions == 0 {
+			errs = append(errs, field.Required(p, "at least one of ageDays or numNewerVersions is required"))
+		}
+
+		switch r.Action {
+		case LifecycleActionSetStorageClass:
+			if r.StorageClass == "" {
+				errs = append(errs, field.Required(p.Child("storageClass"), "storage class is required by the SetStorageClass action"))
+			} else if _, ok := StorageClasses[strings.ToUpper(r.StorageClass)]; !ok {
+				errs = append(errs, field.NotSupported(p.Child("storageClass"), r.StorageClass, storageClassNames()))
+			}
+		case LifecycleActionDelete:
+			if r.StorageClass != "" {
+				errs = append(errs, field.Forbidden(p.Child("storageClass"), "storage class is only used by the SetStorageClass action"))
+			}
+		default:
+			errs = append(errs, field.NotSupported(p.Child("action"), string(r.Action),
+				[]string{string(LifecycleActionDelete), string(LifecycleActionSetStorageClass)}))
+		}
+	}
+
+	return errs
+}
+
+// ValidateLabels checks the labels can be set in a GCS bucket and don't use
+// the keys reserved to the operator.
+func ValidateLabels(labels map[string]string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	for k, v := range labels {
+		if !labelKeyRegexp.MatchString(k) {
+			errs = append(errs, field.Invalid(path.Key(k), k,
+				"keys must start with a lowercase letter and contain only lowercase letters, numbers, dashes and underscores up to 63 characters"))
+		}
+
+		if strings.HasPrefix(k, ReservedLabelPrefix) {
+			errs = append(errs, field.Forbidden(path.Key(k), "keys with prefix "+ReservedLabelPrefix+" are reserved"))
+		}
+
+		if !labelValueRegexp.MatchString(v) {
+			errs = append(errs, field.Invalid(path.Key(k), v,
+				"values must contain only lowercase letters, numbers, dashes and underscores up to 63 characters"))
+		}
+	}
+
+	return errs
+}
+
+// memberPrefixes are the kinds of IAM members a bucket can grant to write.
+var memberPrefixes = []string{"user:", "serviceAccount:", "group:", "domain:"}
+
+// ValidateWriters checks the writers are IAM members of a kind that can be
+// granted a role on a GCS bucket.
+func ValidateWriters(writers []string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	seen := map[string]bool{}
+
+	for i, w := range writers {
+		if seen[w] {
+			errs = append(errs, field.Duplicate(path.Index(i), w))
+		}
+
+		seen[w] = true
+
+		valid := false
+
+		for _, p := range memberPrefixes {
+			if strings.HasPrefix(w, p) && len(w) > len(p) {
+				valid = true
+			}
+		}
+
+		if !valid {
+			errs = append(errs, field.Invalid(path.Index(i), w,
+				"must be an IAM member prefixed with user:, serviceAccount:, group: or domain:"))
+		}
+	}
+
+	return errs
+}
+
+func (b *Bucket) validate() field.ErrorList {
+	var errs field.ErrorList
+
+	spec := field.NewPath("spec")
+
+	errs = append(errs, ValidateBucketName(b.Spec.Name, spec.Child("name"))...)
+
+	if b.Spec.Project == "" {
+		errs = append(errs, field.Required(spec.Child("project"), "project is required"))
+	}
+
+	validateLocation := webhookLocations
+	if validateLocation == nil {
+		validateLocation = ValidateLocation
+	}
+
+	errs = append(errs, validateLocation(b.Spec.Location, b.Spec.StorageClass, spec)...)
+	errs = append(errs, ValidateLabels(b.Spec.Labels, spec.Child("labels"))...)
+
+	if b.Spec.RetentionPeriod != nil && b.Spec.RetentionPeriod.Duration < 0 {
+		errs = append(errs, field.Invalid(spec.Child("retentionPeriod"), b.Spec.RetentionPeriod.Duration.String(), "must not be negative"))
+	}
+
+	errs = append(errs, ValidateLifecycle(b.Spec.Lifecycle, spec.Child("lifecycle"))...)
+
+	if b.Spec.ResyncInterval != nil && b.Spec.ResyncInterval.Duration < 0 {
+		errs = append(errs, field.Invalid(spec.Child("resyncInterval"), b.Spec.ResyncInterval.Duration.String(), "must not be negative"))
+	}
+
+	errs = append(errs, ValidateWriters(b.Spec.Writers, spec.Child("writers"))...)
+
+	if l := b.Spec.Limits; l != nil {
+		if l.MaxBytes != nil && *l.MaxBytes < 0 {
+			errs = append(errs, field.Invalid(spec.Child("limits", "maxBytes"), *l.MaxBytes, "must not be negative"))
+		}
+
+		if l.MaxObjects != nil && *l.MaxObjects < 0 {
+			errs = append(errs, field.Invalid(spec.Child("limits", "maxObjects"), *l.MaxObjects, "must not be negative"))
+		}
+	}
+
+	return errs
+}
+
+func (b *Bucket) validateImmutable(old *Bucket) field.ErrorList {
+	var errs field.ErrorList
+
+	if old.Status.GCSBucketRef == "" {
+		return errs
+	}
+
+	spec := field.NewPath("spec")
+
+	if b.Spec.Name != old.Spec.Name {
+		errs = append(errs, field.Forbidden(spec.Child("name"), "field is immutable once the bucket is created"))
+	}
+
+	if b.Spec.Project != old.Spec.Project {
+		errs = append(errs, field.Forbidden(spec.Child("project"), "field is immutable once the bucket is created"))
+	}
+
+	if !strings.EqualFold(b.Spec.Location, old.Spec.Location) {
+		errs = append(errs, field.Forbidden(spec.Child("location"), "field is immutable once the bucket is created"))
+	}
+
+	return errs
+}
+
+func containsLocationType(types []LocationType, lt LocationType) bool {
+	for _, t := range types {
+		if t == lt {
+			return true
+		}
+	}
+
+	return false
+}
+
+func locationNames() []string {
+	result := make([]string, 0, len(Locations))
+	for k := range Locations {
+		result = append(result, k)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+func storageClassNames() []string {
+	result := make([]string, 0, len(StorageClasses))
+	for k := range StorageClasses {
+		result = append(result, k)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
@@ -0,0 +1,186 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestValidateBucketName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"my-bucket", true},
+		{"my_bucket-01", true},
+		{"example.com", true},
+		{"a.b", true},
+		{"ab", false},
+		{strings.Repeat("a", 63), true},
+		{strings.Repeat("a", 64), false},
+		{strings.Repeat("a", 63) + "." + strings.Repeat("b", 63), true},
+		{strings.Repeat("a", 64) + ".b", false},
+		{strings.Repeat(strings.Repeat("a", 62)+".", 4) + "a", false},
+		{"My-Bucket", false},
+		{"-bucket", false},
+		{"bucket-", false},
+		{"a..b", false},
+		{"google-bucket", false},
+		{"googbucket", false},
+		{"192.168.5.4", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		errs := ValidateBucketName(tt.name, field.NewPath("spec", "name"))
+		if valid := len(errs) == 0; valid != tt.valid {
+			t.Errorf("ValidateBucketName(%q) valid = %v, want %v: %v", tt.name, valid, tt.valid, errs)
+		}
+	}
+}
+
+func TestValidateLocation(t *testing.T) {
+	tests := []struct {
+		location     string
+		storageClass string
+		valid        bool
+	}{
+		{"EU", "STANDARD", true},
+		{"europe-west1", "coldline", true},
+		{"europe-west1", "", true},
+		{"NAM4", "MULTI_REGIONAL", true},
+		{"US", "REGIONAL", false},
+		{"us-east1", "MULTI_REGIONAL", false},
+		{"moon-central1", "STANDARD", false},
+		{"EU", "FAST", false},
+		{"", "STANDARD", false},
+	}
+
+	for _, tt := range tests {
+		errs := ValidateLocation(tt.location, tt.storageClass, field.NewPath("spec"))
+		if valid := len(errs) == 0; valid != tt.valid {
+			t.Errorf("ValidateLocation(%q, %q) valid = %v, want %v: %v", tt.location, tt.storageClass, valid, tt.valid, errs)
+		}
+	}
+}
+
+func TestRegionLocation(t *testing.T) {
+	validate := RegionLocation("eu-west-1")
+
+	tests := []struct {
+		location     string
+		storageClass string
+		valid        bool
+	}{
+		{"eu-west-1", "STANDARD", true},
+		{"EU-WEST-1", "", true},
+		{"EU", "STANDARD", false},
+		{"us-east-1", "STANDARD", false},
+		{"eu-west-1", "FAST", false},
+		{"", "STANDARD", false},
+	}
+
+	for _, tt := range tests {
+		errs := validate(tt.location, tt.storageClass, field.NewPath("spec"))
+		if valid := len(errs) == 0; valid != tt.valid {
+			t.Errorf("RegionLocation(%q) valid = %v, want %v: %v", tt.location, valid, tt.valid, errs)
+		}
+	}
+}
+
+func TestValidateUsesBackendLocations(t *testing.T) {
+	old := webhookLocations
+	webhookLocations = RegionLocation("eu-west-1")
+
+	t.Cleanup(func() { webhookLocations = old })
+
+	b := &Bucket{Spec: BucketSpec{Name: "assets", Project: "my-project", Location: "US", StorageClass: "STANDARD"}}
+	if errs := b.validate(); len(errs) == 0 {
+		t.Error("expected the GCS location to be rejected")
+	}
+
+	b.Spec.Location = "eu-west-1"
+	if errs := b.validate(); len(errs) != 0 {
+		t.Errorf("expected the region of the service to be valid: %v", errs)
+	}
+}
+
+func TestValidateLifecycle(t *testing.T) {
+	tests := []struct {
+		rule  LifecycleRule
+		valid bool
+	}{
+		{LifecycleRule{Action: LifecycleActionDelete, AgeDays: 30}, true},
+		{LifecycleRule{Action: LifecycleActionDelete, NumNewerVersions: 3}, true},
+		{LifecycleRule{Action: LifecycleActionSetStorageClass, StorageClass: "nearline", AgeDays: 30}, true},
+		{LifecycleRule{Action: LifecycleActionDelete}, false},
+		{LifecycleRule{Action: LifecycleActionDelete, StorageClass: "NEARLINE", AgeDays: 30}, false},
+		{LifecycleRule{Action: LifecycleActionSetStorageClass, AgeDays: 30}, false},
+		{LifecycleRule{Action: LifecycleActionSetStorageClass, StorageClass: "FAST", AgeDays: 30}, false},
+		{LifecycleRule{Action: "Archive", AgeDays: 30}, false},
+	}
+
+	for _, tt := range tests {
+		errs := ValidateLifecycle([]LifecycleRule{tt.rule}, field.NewPath("spec", "lifecycle"))
+		if valid := len(errs) == 0; valid != tt.valid {
+			t.Errorf("ValidateLifecycle(%+v) valid = %v, want %v: %v", tt.rule, valid, tt.valid, errs)
+		}
+	}
+}
+
+func TestValidateWriters(t *testing.T) {
+	tests := []struct {
+		writers []string
+		valid   bool
+	}{
+		{nil, true},
+		{[]string{"user:dev@example.com", "serviceAccount:app@project.iam.gserviceaccount.com"}, true},
+		{[]string{"group:devs@example.com", "domain:example.com"}, true},
+		{[]string{"dev@example.com"}, false},
+		{[]string{"user:"}, false},
+		{[]string{"allUsers"}, false},
+		{[]string{"user:dev@example.com", "user:dev@example.com"}, false},
+	}
+
+	for _, tt := range tests {
+		errs := ValidateWriters(tt.writers, field.NewPath("spec", "writers"))
+		if valid := len(errs) == 0; valid != tt.valid {
+			t.Errorf("ValidateWriters(%v) valid = %v, want %v: %v", tt.writers, valid, tt.valid, errs)
+		}
+	}
+}
+
+func TestValidateImmutable(t *testing.T) {
+	old := &Bucket{Spec: BucketSpec{Name: "my-bucket", Project: "p", Location: "EU"}}
+	b := old.DeepCopy()
+	b.Spec.Project = "other"
+
+	if errs := b.validateImmutable(old); len(errs) != 0 {
+		t.Errorf("unbound bucket must be mutable: %v", errs)
+	}
+
+	old.Status.GCSBucketRef = "my-bucket"
+	b.Spec.Location = "eu"
+
+	if errs := b.validateImmutable(old); len(errs) != 1 {
+		t.Errorf("expected one error for the project, got: %v", errs)
+	}
+}
@@ -0,0 +1,284 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var bucketlog = logf.Log.WithName("bucket-resource")
+
+// webhookClient reads the resources needed by the webhooks, it's set when
+// the webhooks are registered in the manager.
+var webhookClient client.Reader
+
+// webhookRules compiles the rules of the bucket policies evaluated by the
+// webhooks, it's set when the webhooks are registered in the manager.
+var webhookRules RuleCompiler
+
+// webhookLocations validates the locations of the buckets for the storage
+// backend, ValidateLocation is used when nil.
+var webhookLocations LocationValidator
+
+// webhookRequireProjectBinding refuses the buckets of namespaces not bound
+// to a project, it's set when the webhooks are registered in the manager.
+var webhookRequireProjectBinding bool
+
+// webhookTimeout bounds the requests made to the API server by the webhooks.
+const webhookTimeout = 5 * time.Second
+
+// SetupWebhookWithManager registers the webhooks of the resource in the
+// manager, the rules of the bucket policies are compiled with the compiler
+// and the locations are validated with the validator of the storage backend.
+// requireProjectBinding refuses the buckets of namespaces not bound to a
+// project, as the controller does.
+func (b *Bucket) SetupWebhookWithManager(mgr ctrl.Manager, rules RuleCompiler, locations LocationValidator, requireProjectBinding bool) error {
+	webhookClient = mgr.GetClient()
+	webhookRules = rules
+	webhookLocations = locations
+	webhookRequireProjectBinding = requireProjectBinding
+
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(b).
+		Complete()
+}
+
+// +kubebuilder:rbac:groups=storage.k8s.riveiro.io,resources=bucketdefaults,verbs=get;list;watch
+// +kubebuilder:rbac:groups=storage.k8s.riveiro.io,resources=bucketpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=storage.k8s.riveiro.io,resources=bucketquotas,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+// +kubebuilder:webhook:path=/mutate-storage-k8s-riveiro-io-v1alpha1-bucket,mutating=true,failurePolicy=fail,groups=storage.k8s.riveiro.io,resources=buckets,verbs=create;update,versions=v1alpha1,name=mbucket.kb.io
+
+var _ webhook.Defaulter = &Bucket{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (b *Bucket) Default() {
+	bucketlog.Info("default", "name", b.Name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	defaults, err := b.lookupDefaults(ctx)
+	if err != nil {
+		// partial defaults could be wrong, e.g. the cluster project in a
+		// namespace bound to another one, the fields left empty are rejected
+		// by the validating webhook instead
+		bucketlog.Error(err, "unable to lookup defaults, none applied", "name", b.Name)
+
+		return
+	}
+
+	b.ApplyDefaults(defaults)
+}
+
+// ApplyDefaults sets the fields of the spec not defined by the user.
+func (b *Bucket) ApplyDefaults(d BucketDefaultsSpec) {
+	if b.Spec.Project == "" {
+		b.Spec.Project = d.Project
+	}
+
+	if b.Spec.Location == "" {
+		b.Spec.Location = d.Location
+	}
+
+	if b.Spec.StorageClass == "" {
+		b.Spec.StorageClass = d.StorageClass
+	}
+
+	if b.Spec.UniformBucketLevelAccess == nil && d.UniformBucketLevelAccess != nil {
+		enabled := *d.UniformBucketLevelAccess
+		b.Spec.UniformBucketLevelAccess = &enabled
+	}
+}
+
+// lookupDefaults returns the cluster defaults overridden by the annotations
+// of the namespace of the resource.
+func (b *Bucket) lookupDefaults(ctx context.Context) (BucketDefaultsSpec, error) {
+	var result BucketDefaultsSpec
+
+	if webhookClient == nil {
+		return result, nil
+	}
+
+	d := &BucketDefaults{}
+	if err := webhookClient.Get(ctx, client.ObjectKey{Name: BucketDefaultsName}, d); err == nil {
+		result = d.Spec
+	} else if !apierrors.IsNotFound(err) {
+		return result, err
+	}
+
+	if b.Namespace == "" {
+		return result, nil
+	}
+
+	ns := &corev1.Namespace{}
+	if err := webhookClient.Get(ctx, client.ObjectKey{Name: b.Namespace}, ns); err != nil {
+		return result, err
+	}
+
+	return result.Merge(ns.GetAnnotations()), nil
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-storage-k8s-riveiro-io-v1alpha1-bucket,mutating=false,failurePolicy=fail,groups=storage.k8s.riveiro.io,resources=buckets,versions=v1alpha1,name=vbucket.kb.io
+
+var _ webhook.Validator = &Bucket{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (b *Bucket) ValidateCreate() error {
+	bucketlog.Info("validate create", "name", b.Name)
+
+	errs := b.validate()
+	errs = append(errs, b.validateProject()...)
+	errs = append(errs, b.validatePolicies()...)
+	errs = append(errs, b.validateQuotas(false)...)
+
+	if len(errs) > 0 {
+		return apierrors.NewInvalid(GroupVersion.WithKind("Bucket").GroupKind(), b.Name, errs)
+	}
+
+	return nil
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (b *Bucket) ValidateUpdate(old runtime.Object) error {
+	bucketlog.Info("validate update", "name", b.Name)
+
+	o := old.(*Bucket)
+
+	// The operator updates the finalizers and the status of resources that
+	// may predate the webhook or the policies, only spec changes are checked.
+	if b.IsBeingDeleted() || reflect.DeepEqual(b.Spec, o.Spec) {
+		return nil
+	}
+
+	errs := b.validate()
+	errs = append(errs, b.validateImmutable(o)...)
+	errs = append(errs, b.validateProject()...)
+	errs = append(errs, b.validatePolicies()...)
+
+	if b.Spec.StorageClass != o.Spec.StorageClass {
+		errs = append(errs, b.validateQuotas(true)...)
+	}
+
+	if len(errs) > 0 {
+		return apierrors.NewInvalid(GroupVersion.WithKind("Bucket").GroupKind(), b.Name, errs)
+	}
+
+	return nil
+}
+
+// validateProject checks the project of the bucket is the one its namespace
+// is bound to, and that the namespace is bound when a binding is required.
+func (b *Bucket) validateProject() field.ErrorList {
+	var errs field.ErrorList
+
+	if webhookClient == nil {
+		return errs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	v, err := EvaluateProject(ctx, webhookClient, b, webhookRequireProjectBinding)
+	if err != nil {
+		return append(errs, field.InternalError(field.NewPath("spec", "project"), err))
+	}
+
+	if v != "" {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "project"), v))
+	}
+
+	return errs
+}
+
+// validatePolicies checks the bucket satisfies the bucket policies enforced
+// in its namespace.
+func (b *Bucket) validatePolicies() field.ErrorList {
+	var errs field.ErrorList
+
+	if webhookClient == nil {
+		return errs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	violations, err := EvaluatePolicies(ctx, webhookClient, webhookRules, b)
+	if err != nil {
+		return append(errs, field.InternalError(field.NewPath("spec"), err))
+	}
+
+	for _, v := range violations {
+		errs = append(errs, field.Forbidden(field.NewPath("spec"), v))
+	}
+
+	return errs
+}
+
+// validateQuotas checks the bucket doesn't exceed the bucket quotas of its
+// namespace. On update only the storage class is checked, the number of
+// buckets was checked on create.
+func (b *Bucket) validateQuotas(update bool) field.ErrorList {
+	var errs field.ErrorList
+
+	if webhookClient == nil {
+		return errs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	var (
+		violations []string
+		err        error
+	)
+
+	if update {
+		violations, err = EvaluateStorageClassQuotas(ctx, webhookClient, b)
+	} else {
+		violations, err = EvaluateQuotas(ctx, webhookClient, b, false)
+	}
+
+	if err != nil {
+		return append(errs, field.InternalError(field.NewPath("spec"), err))
+	}
+
+	for _, v := range violations {
+		errs = append(errs, field.Forbidden(field.NewPath("spec"), v))
+	}
+
+	return errs
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (b *Bucket) ValidateDelete() error {
+	return nil
+}
@@ -0,0 +1,184 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// namespaceErrorReader fails to read the namespaces.
+type namespaceErrorReader struct {
+	client.Reader
+}
+
+func (r namespaceErrorReader) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	if _, ok := obj.(*corev1.Namespace); ok {
+		return errors.New("namespaces unavailable")
+	}
+
+	return r.Reader.Get(ctx, key, obj)
+}
+
+func TestMergeDefaults(t *testing.T) {
+	enabled := true
+	cluster := BucketDefaultsSpec{Project: "shared", Location: "EU", StorageClass: "STANDARD", UniformBucketLevelAccess: &enabled}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        BucketDefaultsSpec
+	}{
+		{"no annotations", nil, cluster},
+		{
+			"default project",
+			map[string]string{DefaultProjectAnnotation: "team-a-dev"},
+			BucketDefaultsSpec{Project: "team-a-dev", Location: "EU", StorageClass: "STANDARD", UniformBucketLevelAccess: &enabled},
+		},
+		{
+			"bound project over default project",
+			map[string]string{DefaultProjectAnnotation: "team-a-dev", ProjectAnnotation: "team-a-prod"},
+			BucketDefaultsSpec{Project: "team-a-prod", Location: "EU", StorageClass: "STANDARD", UniformBucketLevelAccess: &enabled},
+		},
+		{
+			"location and storage class",
+			map[string]string{DefaultLocationAnnotation: "US", DefaultStorageClassAnnotation: "NEARLINE"},
+			BucketDefaultsSpec{Project: "shared", Location: "US", StorageClass: "NEARLINE", UniformBucketLevelAccess: &enabled},
+		},
+		{
+			"uniform bucket-level access",
+			map[string]string{DefaultUniformBucketLevelAccessAnnotation: "false"},
+			BucketDefaultsSpec{Project: "shared", Location: "EU", StorageClass: "STANDARD", UniformBucketLevelAccess: new(bool)},
+		},
+		{
+			"invalid uniform bucket-level access",
+			map[string]string{DefaultUniformBucketLevelAccessAnnotation: "maybe"},
+			cluster,
+		},
+	}
+
+	for _, tt := range tests {
+		got := cluster.Merge(tt.annotations)
+
+		if got.Project != tt.want.Project || got.Location != tt.want.Location || got.StorageClass != tt.want.StorageClass ||
+			*got.UniformBucketLevelAccess != *tt.want.UniformBucketLevelAccess {
+			t.Errorf("%s: Merge() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	if !*cluster.UniformBucketLevelAccess {
+		t.Error("Merge() modified the cluster defaults")
+	}
+}
+
+func TestDefault(t *testing.T) {
+	defaults := &BucketDefaults{
+		ObjectMeta: metav1.ObjectMeta{Name: BucketDefaultsName},
+		Spec:       BucketDefaultsSpec{Project: "shared", Location: "EU", StorageClass: "STANDARD"},
+	}
+	bound := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: "team-a",
+		Annotations: map[string]string{
+			DefaultProjectAnnotation:  "team-a-dev",
+			ProjectAnnotation:         "team-a-prod",
+			DefaultLocationAnnotation: "US",
+		},
+	}}
+
+	newTestClient(t, defaults, bound)
+
+	tests := []struct {
+		namespace string
+		spec      BucketSpec
+		want      BucketSpec
+	}{
+		{"default", BucketSpec{}, BucketSpec{Project: "shared", Location: "EU", StorageClass: "STANDARD"}},
+		{"team-a", BucketSpec{}, BucketSpec{Project: "team-a-prod", Location: "US", StorageClass: "STANDARD"}},
+		{
+			"team-a",
+			BucketSpec{Project: "mine", Location: "ASIA", StorageClass: "COLDLINE"},
+			BucketSpec{Project: "mine", Location: "ASIA", StorageClass: "COLDLINE"},
+		},
+	}
+
+	for _, tt := range tests {
+		b := &Bucket{ObjectMeta: metav1.ObjectMeta{Name: "assets", Namespace: tt.namespace}, Spec: tt.spec}
+		b.Default()
+
+		if b.Spec.Project != tt.want.Project || b.Spec.Location != tt.want.Location || b.Spec.StorageClass != tt.want.StorageClass {
+			t.Errorf("Default() in %s = %+v, want %+v", tt.namespace, b.Spec, tt.want)
+		}
+	}
+}
+
+func TestDefaultLookupError(t *testing.T) {
+	c := newTestClient(t, &BucketDefaults{
+		ObjectMeta: metav1.ObjectMeta{Name: BucketDefaultsName},
+		Spec:       BucketDefaultsSpec{Project: "shared", Location: "EU"},
+	})
+
+	webhookClient = namespaceErrorReader{Reader: c}
+
+	b := &Bucket{ObjectMeta: metav1.ObjectMeta{Name: "assets", Namespace: "default"}}
+	b.Default()
+
+	// the namespace could be bound to another project
+	if b.Spec.Project != "" || b.Spec.Location != "" {
+		t.Errorf("Default() = %+v, want no defaults when the namespace can't be read", b.Spec)
+	}
+
+	if err := b.ValidateCreate(); err == nil {
+		t.Error("expected the bucket without project to be rejected")
+	}
+}
+
+func TestValidateCreateRequiresProjectBinding(t *testing.T) {
+	newTestClient(t, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "team-a",
+		Annotations: map[string]string{ProjectAnnotation: "my-project"},
+	}})
+
+	defer func(old bool) { webhookRequireProjectBinding = old }(webhookRequireProjectBinding)
+
+	unbound := newQuotaTestBucket("assets", "STANDARD")
+
+	bound := newQuotaTestBucket("assets", "STANDARD")
+	bound.Namespace = "team-a"
+
+	webhookRequireProjectBinding = false
+
+	if err := unbound.ValidateCreate(); err != nil {
+		t.Errorf("unexpected error in an unbound namespace without the binding required: %v", err)
+	}
+
+	webhookRequireProjectBinding = true
+
+	if err := unbound.ValidateCreate(); err == nil {
+		t.Error("expected the bucket of an unbound namespace to be rejected")
+	}
+
+	if err := bound.ValidateCreate(); err != nil {
+		t.Errorf("unexpected error in a bound namespace: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BucketDefaultsName is the name of the BucketDefaults resource used by the
+// defaulting webhook, any other BucketDefaults is ignored.
+const BucketDefaultsName = "default"
+
+const (
+	// DefaultProjectAnnotation is a namespace annotation with the project
+	// used by the buckets of the namespace without one.
+	DefaultProjectAnnotation = "storage.k8s.riveiro.io/default-project"
+
+	// DefaultLocationAnnotation is a namespace annotation with the location
+	// used by the buckets of the namespace without one.
+	DefaultLocationAnnotation = "storage.k8s.riveiro.io/default-location"
+
+	// DefaultStorageClassAnnotation is a namespace annotation with the
+	// storage class used by the buckets of the namespace without one.
+	DefaultStorageClassAnnotation = "storage.k8s.riveiro.io/default-storage-class"
+
+	// DefaultUniformBucketLevelAccessAnnotation is a namespace annotation
+	// with the uniform bucket-level access setting used by the buckets of
+	// the namespace without one.
+	DefaultUniformBucketLevelAccessAnnotation = "storage.k8s.riveiro.io/default-uniform-bucket-level-access"
+)
+
+// BucketDefaultsSpec defines the values used for the fields not set in a Bucket
+type BucketDefaultsSpec struct {
+	// Defines the project where the buckets will be created.
+	// +optional
+	Project string `json:"project,omitempty"`
+
+	// Defines the location where the buckets will be created.
+	// +optional
+	Location string `json:"location,omitempty"`
+
+	// Defines the kind of the storage to use.
+	// +optional
+	StorageClass string `json:"storageClass,omitempty"`
+
+	// Defines if uniform bucket-level access is enabled.
+	// +optional
+	UniformBucketLevelAccess *bool `json:"uniformBucketLevelAccess,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// BucketDefaults is the Schema for the bucketdefaults API
+type BucketDefaults struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BucketDefaultsSpec `json:"spec,omitempty"`
+}
+
+// Merge returns the defaults with the values of the namespace annotations
+// overriding the values of d.
+func (d BucketDefaultsSpec) Merge(annotations map[string]string) BucketDefaultsSpec {
+	if v, ok := annotations[DefaultProjectAnnotation]; ok {
+		d.Project = v
+	}
+
+	// a namespace bound to a project can't default to another one
+	if v, ok := annotations[ProjectAnnotation]; ok {
+		d.Project = v
+	}
+
+	if v, ok := annotations[DefaultLocationAnnotation]; ok {
+		d.Location = v
+	}
+
+	if v, ok := annotations[DefaultStorageClassAnnotation]; ok {
+		d.StorageClass = v
+	}
+
+	if v, ok := annotations[DefaultUniformBucketLevelAccessAnnotation]; ok {
+		if enabled, err := strconv.ParseBool(v); err == nil {
+			d.UniformBucketLevelAccess = &enabled
+		}
+	}
+
+	return d
+}
+
+// +kubebuilder:object:root=true
+
+// BucketDefaultsList contains a list of BucketDefaults
+type BucketDefaultsList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BucketDefaults `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BucketDefaults{}, &BucketDefaultsList{})
+}
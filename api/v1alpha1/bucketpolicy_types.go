@@ -0,0 +1,260 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/yriveiro/gcs-bucket-operator/internal/cel"
+)
+
+// RuleVariables are the variables the rules of the bucket policies can
+// reference: the bucket as object and its namespace as namespace.
+var RuleVariables = []string{"object", "namespace"}
+
+// RuleCompiler compiles the rules of the bucket policies, usually caching the
+// programs. A *cel.Cache created with the RuleVariables satisfies it.
+// +kubebuilder:object:generate=false
+type RuleCompiler interface {
+	Compile(expression string) (*cel.Program, error)
+}
+
+// compileRule compiles the expression with the compiler, or without caching
+// when there is none.
+func compileRule(rules RuleCompiler, expression string) (*cel.Program, error) {
+	if rules == nil {
+		return cel.Compile(expression, RuleVariables...)
+	}
+
+	return rules.Compile(expression)
+}
+
+// BucketPolicySpec defines the constraints enforced on the buckets of the
+// selected namespaces.
+//
+// Public access prevention can't be required: the storage client of the
+// operator can neither set nor read it, so a policy couldn't verify it.
+// Requiring uniform bucket-level access, with public grants ruled out in
+// the IAM policies of the organization, is the closest guardrail.
+type BucketPolicySpec struct {
+	// Selects the namespaces where the policy is enforced, an empty selector
+	// selects all the namespaces.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Projects where buckets can be created, any project if empty.
+	// +optional
+	AllowedProjects []string `json:"allowedProjects,omitempty"`
+
+	// Locations where buckets can be created, any location if empty.
+	// +optional
+	AllowedLocations []string `json:"allowedLocations,omitempty"`
+
+	// Storage classes that buckets can use, any storage class if empty.
+	// +optional
+	AllowedStorageClasses []string `json:"allowedStorageClasses,omitempty"`
+
+	// Keys of the labels every bucket must define.
+	// +optional
+	RequiredLabels []string `json:"requiredLabels,omitempty"`
+
+	// Defines if buckets must enable uniform bucket-level access.
+	// +optional
+	RequireUniformBucketLevelAccess bool `json:"requireUniformBucketLevelAccess,omitempty"`
+
+	// Maximum retention period buckets can define.
+	// +optional
+	MaxRetentionPeriod *metav1.Duration `json:"maxRetentionPeriod,omitempty"`
+
+	// Expressions the buckets must satisfy.
+	// +optional
+	Rules []BucketPolicyRule `json:"rules,omitempty"`
+}
+
+// BucketPolicyRule defines a CEL expression evaluated against the bucket,
+// available as `object`, and its namespace, available as `namespace`. The
+// bucket violates the rule when the expression doesn't compile, evaluates
+// to false or fails.
+//
+// Only a subset of CEL is supported: literals, field selection and
+// indexing, the operators, has(), exists and all, size, string and int,
+// and the startsWith, endsWith, contains and matches methods. Map literals,
+// type(), unsigned integers, and raw, bytes and triple-quoted strings are
+// rejected, and an integer overflow fails the evaluation.
+type BucketPolicyRule struct {
+	// +kubebuilder:validation:MinLength=1
+	Expression string `json:"expression"`
+
+	// Message reported when the rule is violated, defaults to the expression.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// BucketPolicyStatus defines the observed state of BucketPolicy
+type BucketPolicyStatus struct {
+	// Generation of the policy the status belongs to.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Errors found compiling the rules, rules that don't compile are not
+	// enforced.
+	// +optional
+	RuleErrors []string `json:"ruleErrors,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// BucketPolicy is the Schema for the bucketpolicies API
+type BucketPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BucketPolicySpec   `json:"spec,omitempty"`
+	Status BucketPolicyStatus `json:"status,omitempty"`
+}
+
+// Selects checks if the policy is enforced in the namespace
+func (p *BucketPolicy) Selects(ns *corev1.Namespace) (bool, error) {
+	if p.Spec.NamespaceSelector == nil {
+		return true, nil
+	}
+
+	s, err := metav1.LabelSelectorAsSelector(p.Spec.NamespaceSelector)
+	if err != nil {
+		return false, err
+	}
+
+	return s.Matches(labels.Set(ns.GetLabels())), nil
+}
+
+// RuleErrors returns the errors found compiling the rules of the policy
+func (p *BucketPolicy) RuleErrors(rules RuleCompiler) []string {
+	var result []string
+
+	for i, r := range p.Spec.Rules {
+		if _, err := compileRule(rules, r.Expression); err != nil {
+			result = append(result, fmt.Sprintf("rules[%d]: %v", i, err))
+		}
+	}
+
+	return result
+}
+
+// Violations returns a description of every constraint of the policy not
+// satisfied by the bucket in the namespace, the rules are compiled with the
+// compiler
+func (p *BucketPolicy) Violations(b *Bucket, ns *corev1.Namespace, rules RuleCompiler) []string {
+	var result []string
+
+	if len(p.Spec.AllowedProjects) > 0 && !containsString(p.Spec.AllowedProjects, b.Spec.Project) {
+		result = append(result, fmt.Sprintf("project %s is not allowed", b.Spec.Project))
+	}
+
+	if len(p.Spec.AllowedLocations) > 0 && !containsFold(p.Spec.AllowedLocations, b.Spec.Location) {
+		result = append(result, fmt.Sprintf("location %s is not allowed", b.Spec.Location))
+	}
+
+	if len(p.Spec.AllowedStorageClasses) > 0 && !containsFold(p.Spec.AllowedStorageClasses, b.Spec.StorageClass) {
+		result = append(result, fmt.Sprintf("storage class %s is not allowed", b.Spec.StorageClass))
+	}
+
+	for _, l := range p.Spec.RequiredLabels {
+		if _, ok := b.Spec.Labels[l]; !ok {
+			result = append(result, fmt.Sprintf("label %s is required", l))
+		}
+	}
+
+	if p.Spec.RequireUniformBucketLevelAccess && (b.Spec.UniformBucketLevelAccess == nil || !*b.Spec.UniformBucketLevelAccess) {
+		result = append(result, "uniform bucket-level access is required")
+	}
+
+	if max := p.Spec.MaxRetentionPeriod; max != nil && b.Spec.RetentionPeriod != nil && b.Spec.RetentionPeriod.Duration > max.Duration {
+		result = append(result, fmt.Sprintf("retention period %s exceeds the maximum of %s", b.Spec.RetentionPeriod.Duration, max.Duration))
+	}
+
+	result = append(result, p.ruleViolations(b, ns, rules)...)
+
+	for i := range result {
+		result[i] = fmt.Sprintf("policy %s: %s", p.GetName(), result[i])
+	}
+
+	return result
+}
+
+func (p *BucketPolicy) ruleViolations(b *Bucket, ns *corev1.Namespace, rules RuleCompiler) []string {
+	if len(p.Spec.Rules) == 0 {
+		return nil
+	}
+
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(b)
+	if err != nil {
+		return []string{fmt.Sprintf("unable to evaluate rules: %v", err)}
+	}
+
+	namespace, err := runtime.DefaultUnstructuredConverter.ToUnstructured(ns)
+	if err != nil {
+		return []string{fmt.Sprintf("unable to evaluate rules: %v", err)}
+	}
+
+	vars := map[string]interface{}{"object": object, "namespace": namespace}
+
+	var result []string
+
+	for i, r := range p.Spec.Rules {
+		prg, err := compileRule(rules, r.Expression)
+		if err != nil {
+			// a rule that can't be checked is not satisfied
+			result = append(result, fmt.Sprintf("rules[%d] can't be evaluated: %v", i, err))
+
+			continue
+		}
+
+		msg := r.Message
+		if msg == "" {
+			msg = fmt.Sprintf("rule %s is not satisfied", r.Expression)
+		}
+
+		ok, err := prg.EvalBool(vars)
+		if err != nil {
+			result = append(result, fmt.Sprintf("%s: %v", msg, err))
+		} else if !ok {
+			result = append(result, msg)
+		}
+	}
+
+	return result
+}
+
+// +kubebuilder:object:root=true
+
+// BucketPolicyList contains a list of BucketPolicy
+type BucketPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BucketPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BucketPolicy{}, &BucketPolicyList{})
+}
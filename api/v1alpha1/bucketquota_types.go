@@ -0,0 +1,87 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BucketQuotaSpec defines the limits of the buckets of a namespace
+type BucketQuotaSpec struct {
+	// Maximum number of buckets of the namespace, unlimited if not set.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxBuckets *int32 `json:"maxBuckets,omitempty"`
+
+	// Storage classes the buckets of the namespace can use, any storage
+	// class if empty.
+	// +optional
+	AllowedStorageClasses []string `json:"allowedStorageClasses,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=bucketquotas
+
+// BucketQuota is the Schema for the bucketquotas API
+type BucketQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BucketQuotaSpec `json:"spec,omitempty"`
+}
+
+// Violations returns a description of every limit of the quota exceeded by
+// the bucket, used is the number of buckets of the namespace without it.
+func (q *BucketQuota) Violations(b *Bucket, used int) []string {
+	var result []string
+
+	if q.Spec.MaxBuckets != nil && used >= int(*q.Spec.MaxBuckets) {
+		result = append(result, fmt.Sprintf("quota %s: maximum of %d buckets reached", q.GetName(), *q.Spec.MaxBuckets))
+	}
+
+	if v := q.StorageClassViolation(b); v != "" {
+		result = append(result, v)
+	}
+
+	return result
+}
+
+// StorageClassViolation returns a description of the violation when the
+// quota doesn't allow the storage class of the bucket, empty otherwise.
+func (q *BucketQuota) StorageClassViolation(b *Bucket) string {
+	if len(q.Spec.AllowedStorageClasses) > 0 && !containsFold(q.Spec.AllowedStorageClasses, b.Spec.StorageClass) {
+		return fmt.Sprintf("quota %s: storage class %s is not allowed", q.GetName(), b.Spec.StorageClass)
+	}
+
+	return ""
+}
+
+// +kubebuilder:object:root=true
+
+// BucketQuotaList contains a list of BucketQuota
+type BucketQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BucketQuota `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BucketQuota{}, &BucketQuotaList{})
+}
@@ -17,6 +17,8 @@ limitations under the License.
 
 package v1alpha1
 
+import "strings"
+
 func containsString(slice []string, s string) bool {
 	for _, item := range slice {
 		if item == s {
@@ -26,6 +28,16 @@ func containsString(slice []string, s string) bool {
 	return false
 }
 
+func containsFold(slice []string, s string) bool {
+	for _, item := range slice {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func removeString(slice []string, s string) (result []string) {
 	for _, item := range slice {
 		if item == s {
@@ -35,3 +47,25 @@ func removeString(slice []string, s string) (result []string) {
 	}
 	return
 }
+
+// labelValue converts s into a valid GCS label value, label values only
+// allow lowercase letters, numbers, underscores and dashes up to 63
+// characters.
+func labelValue(s string) string {
+	v := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		}
+
+		return '_'
+	}, s)
+
+	if len(v) > 63 {
+		v = v[:63]
+	}
+
+	return v
+}
@@ -0,0 +1,61 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// EvaluatePolicies returns the violations of the bucket policies enforced in
+// the namespace of the bucket. It's shared by the validating webhook and the
+// reconciler so both enforce the same rules.
+func EvaluatePolicies(ctx context.Context, c client.Reader, rules RuleCompiler, b *Bucket) ([]string, error) {
+	policies := &BucketPolicyList{}
+	if err := c.List(ctx, policies); err != nil {
+		return nil, err
+	}
+
+	if len(policies.Items) == 0 {
+		return nil, nil
+	}
+
+	ns := &corev1.Namespace{}
+	if err := c.Get(ctx, client.ObjectKey{Name: b.GetNamespace()}, ns); err != nil {
+		return nil, err
+	}
+
+	var result []string
+
+	for i := range policies.Items {
+		p := &policies.Items[i]
+
+		ok, err := p.Selects(ns)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			result = append(result, p.Violations(b, ns, rules)...)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,195 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/yriveiro/gcs-bucket-operator/internal/cel"
+)
+
+func newTestPolicy(spec BucketPolicySpec) *BucketPolicy {
+	return &BucketPolicy{ObjectMeta: metav1.ObjectMeta{Name: "strict"}, Spec: spec}
+}
+
+func TestPolicySelects(t *testing.T) {
+	prod := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"env": "prod"}}}
+	dev := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Labels: map[string]string{"env": "dev"}}}
+
+	tests := []struct {
+		name     string
+		selector *metav1.LabelSelector
+		ns       *corev1.Namespace
+		want     bool
+	}{
+		{"no selector", nil, dev, true},
+		{"empty selector", &metav1.LabelSelector{}, dev, true},
+		{"matching labels", &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}, prod, true},
+		{"other labels", &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}, dev, false},
+		{
+			"expression",
+			&metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "env", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"prod"}},
+			}},
+			dev, true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := newTestPolicy(BucketPolicySpec{NamespaceSelector: tt.selector}).Selects(tt.ns)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: Selects(%s) = %v, want %v", tt.name, tt.ns.Name, got, tt.want)
+		}
+	}
+
+	invalid := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "env", Operator: "Unknown"}}}
+	if _, err := newTestPolicy(BucketPolicySpec{NamespaceSelector: invalid}).Selects(dev); err == nil {
+		t.Error("expected an error for the invalid selector")
+	}
+}
+
+func TestPolicyViolations(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"tier": "gold"}}}
+	enabled := true
+
+	tests := []struct {
+		name string
+		spec BucketPolicySpec
+		want []string
+	}{
+		{"empty policy", BucketPolicySpec{}, nil},
+		{
+			"allowed values",
+			BucketPolicySpec{
+				AllowedProjects:       []string{"my-project"},
+				AllowedLocations:      []string{"eu"},
+				AllowedStorageClasses: []string{"standard"},
+			},
+			nil,
+		},
+		{
+			"not allowed values",
+			BucketPolicySpec{
+				AllowedProjects:       []string{"other"},
+				AllowedLocations:      []string{"US"},
+				AllowedStorageClasses: []string{"NEARLINE"},
+			},
+			[]string{
+				"policy strict: project my-project is not allowed",
+				"policy strict: location EU is not allowed",
+				"policy strict: storage class STANDARD is not allowed",
+			},
+		},
+		{
+			"required labels and access",
+			BucketPolicySpec{RequiredLabels: []string{"team", "cost-center"}, RequireUniformBucketLevelAccess: true},
+			[]string{
+				"policy strict: label cost-center is required",
+				"policy strict: uniform bucket-level access is required",
+			},
+		},
+		{
+			"retention period",
+			BucketPolicySpec{MaxRetentionPeriod: &metav1.Duration{Duration: time.Hour}},
+			[]string{"policy strict: retention period 2h0m0s exceeds the maximum of 1h0m0s"},
+		},
+		{
+			"rules",
+			BucketPolicySpec{Rules: []BucketPolicyRule{
+				{Expression: `object.spec.name.startsWith("assets")`},
+				{Expression: `namespace.metadata.labels.tier == "silver"`, Message: "only silver namespaces"},
+				{Expression: `object.spec.name.startsWith(`},
+				{Expression: `object.spec.missing == 1`, Message: "missing field"},
+			}},
+			[]string{
+				"policy strict: only silver namespaces",
+				`policy strict: rules[2] can't be evaluated: unexpected "" at 28`,
+				`policy strict: missing field: no such key "missing"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		b := newQuotaTestBucket("assets", "STANDARD")
+		b.Spec.Labels = map[string]string{"team": "a"}
+		b.Spec.RetentionPeriod = &metav1.Duration{Duration: 2 * time.Hour}
+
+		if tt.spec.RequireUniformBucketLevelAccess {
+			b.Spec.UniformBucketLevelAccess = nil
+		} else {
+			b.Spec.UniformBucketLevelAccess = &enabled
+		}
+
+		got := newTestPolicy(tt.spec).Violations(b, ns, nil)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Violations() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyRuleErrors(t *testing.T) {
+	rules := cel.NewCache(10, RuleVariables...)
+
+	p := newTestPolicy(BucketPolicySpec{Rules: []BucketPolicyRule{
+		{Expression: `object.spec.name != ""`},
+		{Expression: `bucket.spec.name != ""`},
+	}})
+
+	errs := p.RuleErrors(rules)
+	if len(errs) != 1 || !strings.HasPrefix(errs[0], "rules[1]:") {
+		t.Errorf("RuleErrors() = %q, want an error for rules[1]", errs)
+	}
+
+	if !reflect.DeepEqual(p.RuleErrors(nil), errs) {
+		t.Errorf("RuleErrors(nil) = %q, want %q", p.RuleErrors(nil), errs)
+	}
+}
+
+func TestEvaluatePolicies(t *testing.T) {
+	selected := newTestPolicy(BucketPolicySpec{AllowedLocations: []string{"US"}})
+	ignored := &BucketPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "prod-only"},
+		Spec: BucketPolicySpec{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+			AllowedLocations:  []string{"ASIA"},
+		},
+	}
+
+	c := newTestClient(t, selected, ignored)
+
+	got, err := EvaluatePolicies(context.Background(), c, nil, newQuotaTestBucket("assets", "STANDARD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"policy strict: location EU is not allowed"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EvaluatePolicies() = %q, want %q", got, want)
+	}
+}
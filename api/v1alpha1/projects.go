@@ -0,0 +1,69 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ProjectAnnotation is a namespace annotation binding the namespace to a GCP
+// project, the buckets of the namespace default to it and can't use another
+// project.
+const ProjectAnnotation = "storage.k8s.riveiro.io/project"
+
+// BoundProject returns the project the namespace is bound to, empty if the
+// namespace is not bound.
+func BoundProject(ns *corev1.Namespace) string {
+	return ns.GetAnnotations()[ProjectAnnotation]
+}
+
+// ProjectViolation returns why the project of the bucket is not allowed in
+// the namespace, empty if it's allowed. When required is true the namespace
+// must be bound to a project.
+func (b *Bucket) ProjectViolation(ns *corev1.Namespace, required bool) string {
+	project := BoundProject(ns)
+
+	if project == "" {
+		if required {
+			return fmt.Sprintf("namespace %s is not bound to a project", ns.GetName())
+		}
+
+		return ""
+	}
+
+	if b.Spec.Project != project {
+		return fmt.Sprintf("project %s is not allowed, namespace %s is bound to project %s", b.Spec.Project, ns.GetName(), project)
+	}
+
+	return ""
+}
+
+// EvaluateProject returns why the project of the bucket is not allowed in
+// its namespace, empty if it's allowed.
+func EvaluateProject(ctx context.Context, c client.Reader, b *Bucket, required bool) (string, error) {
+	ns := &corev1.Namespace{}
+	if err := c.Get(ctx, client.ObjectKey{Name: b.GetNamespace()}, ns); err != nil {
+		return "", err
+	}
+
+	return b.ProjectViolation(ns, required), nil
+}
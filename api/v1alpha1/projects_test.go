@@ -0,0 +1,59 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestProjectViolation(t *testing.T) {
+	bound := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "team-a",
+		Annotations: map[string]string{ProjectAnnotation: "team-a-prod"},
+	}}
+	unbound := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}}
+
+	tests := []struct {
+		project  string
+		ns       *corev1.Namespace
+		required bool
+		allowed  bool
+	}{
+		{"team-a-prod", bound, false, true},
+		{"team-a-prod", bound, true, true},
+		{"other", bound, false, false},
+		{"other", unbound, false, true},
+		{"other", unbound, true, false},
+	}
+
+	for _, tt := range tests {
+		b := &Bucket{Spec: BucketSpec{Project: tt.project}}
+
+		if v := b.ProjectViolation(tt.ns, tt.required); (v == "") != tt.allowed {
+			t.Errorf("ProjectViolation(%s, %s, %v) = %q, want allowed %v", tt.project, tt.ns.Name, tt.required, v, tt.allowed)
+		}
+	}
+
+	d := BucketDefaultsSpec{Project: "shared"}.Merge(bound.Annotations)
+	if d.Project != "team-a-prod" {
+		t.Errorf("Merge() project = %s, want team-a-prod", d.Project)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ImpersonateServiceAccountAnnotation is a namespace annotation with the
+// service account impersonated to manage the buckets of the namespace that
+// don't reference a provider config.
+const ImpersonateServiceAccountAnnotation = "storage.k8s.riveiro.io/impersonate-service-account"
+
+// AllowedServiceAccountsAnnotation is a namespace annotation with the comma
+// separated service accounts the provider configs of the namespace can
+// impersonate. Namespaces are managed by the cluster administrators, so they
+// decide which service accounts each tenant can act as.
+const AllowedServiceAccountsAnnotation = "storage.k8s.riveiro.io/allowed-service-accounts"
+
+// ImpersonationAllowed checks the provider configs of the namespace can
+// impersonate the service account: it must be the one of the
+// ImpersonateServiceAccountAnnotation or be listed in the
+// AllowedServiceAccountsAnnotation.
+func ImpersonationAllowed(ns *corev1.Namespace, serviceAccount string) bool {
+	annotations := ns.GetAnnotations()
+
+	if sa := annotations[ImpersonateServiceAccountAnnotation]; sa != "" && strings.EqualFold(sa, serviceAccount) {
+		return true
+	}
+
+	for _, sa := range strings.Split(annotations[AllowedServiceAccountsAnnotation], ",") {
+		if sa = strings.TrimSpace(sa); sa != "" && strings.EqualFold(sa, serviceAccount) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ProviderConfigSpec defines the credentials used to manage the GCS buckets,
+// at least one of secretRef or impersonateServiceAccount must be set.
+type ProviderConfigSpec struct {
+	// Key of a Secret, in the namespace of the provider config, with the
+	// JSON key of the service account used to manage the buckets. The
+	// operator credentials are used if not set.
+	// +optional
+	SecretRef *corev1.SecretKeySelector `json:"secretRef,omitempty"`
+
+	// Email of a service account impersonated to manage the buckets, the
+	// credentials need the token creator role on it. Tokens are short-lived
+	// and renewed when they expire. The namespace must allow it, see
+	// AllowedServiceAccountsAnnotation.
+	// +optional
+	ImpersonateServiceAccount string `json:"impersonateServiceAccount,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ProviderConfig is the Schema for the providerconfigs API
+type ProviderConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ProviderConfigSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ProviderConfigList contains a list of ProviderConfig
+type ProviderConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ProviderConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ProviderConfig{}, &ProviderConfigList{})
+}
@@ -0,0 +1,81 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// EvaluateQuotas returns the limits of the bucket quotas of the namespace
+// exceeded by the bucket. When bound is true only the buckets already bound
+// to a GCS bucket are counted, otherwise every bucket of the namespace.
+func EvaluateQuotas(ctx context.Context, c client.Reader, b *Bucket, bound bool) ([]string, error) {
+	quotas := &BucketQuotaList{}
+	if err := c.List(ctx, quotas, client.InNamespace(b.GetNamespace())); err != nil {
+		return nil, err
+	}
+
+	if len(quotas.Items) == 0 {
+		return nil, nil
+	}
+
+	buckets := &BucketList{}
+	if err := c.List(ctx, buckets, client.InNamespace(b.GetNamespace())); err != nil {
+		return nil, err
+	}
+
+	used := 0
+
+	for _, item := range buckets.Items {
+		if item.GetName() == b.GetName() || (bound && item.Status.GCSBucketRef == "") {
+			continue
+		}
+
+		used++
+	}
+
+	var result []string
+
+	for i := range quotas.Items {
+		result = append(result, quotas.Items[i].Violations(b, used)...)
+	}
+
+	return result, nil
+}
+
+// EvaluateStorageClassQuotas returns the storage class limits of the bucket
+// quotas of the namespace exceeded by the bucket, the number of buckets is
+// not checked. It applies to the buckets already bound to a GCS bucket.
+func EvaluateStorageClassQuotas(ctx context.Context, c client.Reader, b *Bucket) ([]string, error) {
+	quotas := &BucketQuotaList{}
+	if err := c.List(ctx, quotas, client.InNamespace(b.GetNamespace())); err != nil {
+		return nil, err
+	}
+
+	var result []string
+
+	for i := range quotas.Items {
+		if v := quotas.Items[i].StorageClassViolation(b); v != "" {
+			result = append(result, v)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,157 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestClient returns a client of the objects, it's used by the webhooks
+// until the test ends.
+func newTestClient(t *testing.T, objs ...runtime.Object) client.Reader {
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+
+	objs = append(objs, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	c := fake.NewFakeClientWithScheme(s, objs...)
+
+	old := webhookClient
+	webhookClient = c
+
+	t.Cleanup(func() { webhookClient = old })
+
+	return c
+}
+
+func newTestQuota(maxBuckets *int32, classes ...string) *BucketQuota {
+	return &BucketQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: "default"},
+		Spec:       BucketQuotaSpec{MaxBuckets: maxBuckets, AllowedStorageClasses: classes},
+	}
+}
+
+func newQuotaTestBucket(name, storageClass string) *Bucket {
+	return &Bucket{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: BucketSpec{
+			Name:         name,
+			Project:      "my-project",
+			Location:     "EU",
+			StorageClass: storageClass,
+		},
+	}
+}
+
+func TestValidateUpdateStorageClassQuota(t *testing.T) {
+	one := int32(1)
+
+	// the namespace is at its bucket limit, it doesn't prevent updates
+	newTestClient(t, newTestQuota(&one, "STANDARD", "NEARLINE"), newQuotaTestBucket("other", "STANDARD"))
+
+	old := newQuotaTestBucket("assets", "STANDARD")
+
+	b := old.DeepCopy()
+	b.Spec.Labels = map[string]string{"team": "a"}
+
+	if err := b.ValidateUpdate(old); err != nil {
+		t.Errorf("unexpected error updating the labels: %v", err)
+	}
+
+	b.Spec.StorageClass = "NEARLINE"
+	if err := b.ValidateUpdate(old); err != nil {
+		t.Errorf("unexpected error updating to an allowed storage class: %v", err)
+	}
+
+	b.Spec.StorageClass = "COLDLINE"
+	if err := b.ValidateUpdate(old); err == nil {
+		t.Error("expected an error updating to a storage class not allowed by the quota")
+	}
+}
+
+func TestQuotaViolations(t *testing.T) {
+	two := int32(2)
+
+	tests := []struct {
+		quota        *BucketQuota
+		storageClass string
+		used         int
+		want         []string
+	}{
+		{newTestQuota(nil), "STANDARD", 10, nil},
+		{newTestQuota(&two), "STANDARD", 1, nil},
+		{newTestQuota(&two), "STANDARD", 2, []string{"quota quota: maximum of 2 buckets reached"}},
+		{newTestQuota(nil, "standard"), "STANDARD", 0, nil},
+		{newTestQuota(nil, "STANDARD"), "COLDLINE", 0, []string{"quota quota: storage class COLDLINE is not allowed"}},
+		{newTestQuota(&two, "STANDARD"), "COLDLINE", 3, []string{
+			"quota quota: maximum of 2 buckets reached",
+			"quota quota: storage class COLDLINE is not allowed",
+		}},
+	}
+
+	for i, tt := range tests {
+		got := tt.quota.Violations(newQuotaTestBucket("assets", tt.storageClass), tt.used)
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d: Violations() = %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateQuotas(t *testing.T) {
+	ctx := context.Background()
+	two := int32(2)
+
+	bound := newQuotaTestBucket("bound", "STANDARD")
+	bound.Status.GCSBucketRef = "bound"
+
+	other := newQuotaTestBucket("other", "STANDARD")
+	other.Namespace = "team-b"
+	other.Status.GCSBucketRef = "other"
+
+	c := newTestClient(t, newTestQuota(&two), bound, newQuotaTestBucket("pending", "STANDARD"), other)
+
+	b := newQuotaTestBucket("assets", "STANDARD")
+
+	// the buckets of other namespaces and the bucket itself are not counted
+	if v, err := EvaluateQuotas(ctx, c, b, true); err != nil || len(v) != 0 {
+		t.Errorf("EvaluateQuotas(bound) = %q, %v, want no violations", v, err)
+	}
+
+	if v, err := EvaluateQuotas(ctx, c, b, false); err != nil || len(v) != 1 {
+		t.Errorf("EvaluateQuotas(all) = %q, %v, want a violation", v, err)
+	}
+
+	if v, err := EvaluateQuotas(ctx, c, bound, false); err != nil || len(v) != 0 {
+		t.Errorf("EvaluateQuotas(all) of a counted bucket = %q, %v, want no violations", v, err)
+	}
+}
@@ -19,19 +19,35 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"time"
 
-	"cloud.google.com/go/storage"
 	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+	"github.com/yriveiro/gcs-bucket-operator/internal/audit"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+	"github.com/yriveiro/gcs-bucket-operator/internal/gcp"
+	"github.com/yriveiro/gcs-bucket-operator/internal/poller"
+	"github.com/yriveiro/gcs-bucket-operator/internal/throttle"
+	"github.com/yriveiro/gcs-bucket-operator/internal/tracing"
+	"github.com/yriveiro/gcs-bucket-operator/internal/usage"
 	k8serr "k8s.io/apimachinery/pkg/api/errors"
 )
 
+// eventsBufferSize is the number of resources the sources other than the API
+// server can send to the reconciler before they are dropped.
+const eventsBufferSize = 1024
+
 // StopReconciler indicates if the inner operation signaled to stop the reconcile
 // or not.
 type StopReconciler bool
@@ -39,18 +55,85 @@ type StopReconciler bool
 // BucketReconciler reconciles a Bucket object
 type BucketReconciler struct {
 	client.Client
-	StorageClient *storage.Client
-	Log           logr.Logger
-	Scheme        *runtime.Scheme
-	Recorder      record.EventRecorder
+	Backend backend.BucketBackend
+
+	// Clients creates the GCS clients of the provider configs and the
+	// impersonated service accounts, they're not supported when nil.
+	Clients *gcp.ClientCache
+
+	// Limiter limits the bucket creations and deletions per project, they
+	// are not limited when nil.
+	Limiter *throttle.Limiter
+
+	// Timeouts bounds the duration of the operations on the storage backend.
+	Timeouts backend.Timeouts
+
+	// Breaker stops the operations on the storage backend during an outage,
+	// it's disabled when nil.
+	Breaker *backend.Breaker
+
+	// ResyncInterval is how often the GCS buckets are checked for changes
+	// made outside of the operator when the resource doesn't define it,
+	// they are only checked on the events of the resources when zero.
+	ResyncInterval time.Duration
+
+	// Poller lists the buckets of the projects into a cache serving the
+	// reads of the buckets managed with the operator credentials, and
+	// reports the buckets changed outside of the operator. It lists the
+	// operator backend. Every bucket is read on each reconcile when nil.
+	Poller *poller.Poller
+
+	// AuditSources report the buckets changed outside of the operator from
+	// the audit logs, the changes are detected by the resync and the poller
+	// when they are unavailable.
+	AuditSources []audit.Source
+
+	// Usage measures the objects and bytes stored in the buckets every
+	// UsageInterval, the usage is not collected when nil.
+	Usage         *usage.Meter
+	UsageInterval time.Duration
+
+	// Tracer records a span for each reconcile, with child spans for the
+	// finalizer and the operations on the storage backend, spans are not
+	// recorded when nil.
+	Tracer *tracing.Tracer
+
+	// Rules compiles the rules of the bucket policies, the rules are
+	// compiled on every evaluation when nil.
+	Rules storagev1.RuleCompiler
+
+	// events receives the resources to reconcile from the sources other
+	// than the API server.
+	events chan event.GenericEvent
+
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// ClusterID identifies the cluster running the operator, it's part of
+	// the owner ID stamped in the GCS buckets.
+	ClusterID string
+
+	// RequireProjectBinding refuses the buckets of namespaces not bound to
+	// a project.
+	RequireProjectBinding bool
 }
 
 // +kubebuilder:rbac:groups=storage.k8s.riveiro.io,resources=buckets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=storage.k8s.riveiro.io,resources=buckets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=storage.k8s.riveiro.io,resources=bucketpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=storage.k8s.riveiro.io,resources=bucketquotas,verbs=get;list;watch
+// +kubebuilder:rbac:groups=storage.k8s.riveiro.io,resources=providerconfigs,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile reconciliates the resource state to the desire state
-func (r *BucketReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	ctx := context.Background()
+func (r *BucketReconciler) Reconcile(req ctrl.Request) (result ctrl.Result, err error) {
+	ctx, span := r.Tracer.StartSpan(context.Background(), "Reconcile",
+		tracing.String("namespace", req.Namespace), tracing.String("name", req.Name))
+	defer func() { span.End(err) }()
+
 	l := r.Log.WithValues("bucket-operator", req.NamespacedName)
 
 	l.Info(fmt.Sprintf("starting reconcile loop for namspace: %v", req.NamespacedName))
@@ -66,12 +149,12 @@ func (r *BucketReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, err
 	}
 
+	span.SetAttributes(tracing.String("bucket", b.Spec.Name), tracing.String("project", b.Spec.Project))
+
 	if b.IsBeingDeleted() {
 		l.Info(fmt.Sprintf("HandleFinalizer for namespace: %v", req.NamespacedName))
 		if err := r.handleFinalizer(ctx, b); err != nil {
-			r.Recorder.Event(b, corev1.EventTypeWarning, "Deleting finalizer", fmt.Sprintf("Failed to delete finalizer: %s", err))
-
-			return ctrl.Result{}, fmt.Errorf("error when handling finalizer: %v", err)
+			return r.backendError(ctx, b, "handling finalizer", "Deleting finalizer", err)
 		}
 
 		r.Recorder.Event(b, corev1.EventTypeNormal, "Deleted", "Object finalizer is deleted")
@@ -98,17 +181,105 @@ func (r *BucketReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, nil
 	}
 
+	if stop, err := r.enforceProject(ctx, b); stop || err != nil {
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("error when enforcing the namespace project: %v", err)
+		}
+
+		return ctrl.Result{}, nil
+	}
+
+	if stop, err := r.enforcePolicies(ctx, b); stop || err != nil {
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("error when enforcing bucket policies: %v", err)
+		}
+
+		return ctrl.Result{}, nil
+	}
+
+	if stop, err := r.enforceQuotas(ctx, b); stop || err != nil {
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("error when enforcing bucket quotas: %v", err)
+		}
+
+		return ctrl.Result{}, nil
+	}
+
 	if err := r.create(ctx, b); err != nil {
-		r.Recorder.Event(b, corev1.EventTypeWarning, "Creating bucket", fmt.Sprintf("failed to create bucket: %s", err))
-		return ctrl.Result{}, fmt.Errorf("error when creating GCS Bucket: %v", err)
+		return r.backendError(ctx, b, "creating GCS Bucket", "Creating bucket", err)
+	}
+
+	if err := r.enforceLimits(ctx, b); err != nil {
+		return ctrl.Result{}, fmt.Errorf("error when enforcing bucket limits: %v", err)
 	}
 
-	return ctrl.Result{}, nil
+	if err := r.reconcileGrants(ctx, b); err != nil {
+		return r.backendError(ctx, b, "updating write grants", "Updating write grants", err)
+	}
+
+	return ctrl.Result{RequeueAfter: r.resyncAfter(b)}, r.clearBackendError(ctx, b)
 }
 
 // SetupWithManager setup the controller with a manager
 func (r *BucketReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.events = make(chan event.GenericEvent, eventsBufferSize)
+
+	err := metrics.Registry.Register(newBucketCollector(mgr.GetClient()))
+	if _, ok := err.(prometheus.AlreadyRegisteredError); err != nil && !ok {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(&storagev1.ProviderConfig{}, providerConfigSecretField, providerConfigSecret); err != nil {
+		return err
+	}
+
+	if r.Poller != nil {
+		r.Poller.Backend = r.wrapBackend(r.Backend)
+		r.Poller.Projects = r.polledProjects
+		r.Poller.Changed = r.enqueueGCSBucket
+
+		if err := mgr.Add(r.Poller); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range r.AuditSources {
+		s.Notify(r.auditEvent)
+
+		if err := mgr.Add(s); err != nil {
+			return err
+		}
+	}
+
+	if r.Tracer != nil {
+		if err := mgr.Add(r.Tracer); err != nil {
+			return err
+		}
+	}
+
+	if r.Usage != nil {
+		if err := mgr.Add(&usageCollector{r: r}); err != nil {
+			return err
+		}
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&storagev1.Bucket{}).
+		Watches(&source.Kind{Type: &storagev1.BucketPolicy{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.allBuckets),
+		}).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.namespaceBuckets),
+		}).
+		Watches(&source.Kind{Type: &storagev1.BucketQuota{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.namespaceBuckets),
+		}).
+		Watches(&source.Kind{Type: &storagev1.ProviderConfig{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.namespaceBuckets),
+		}).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.secretBuckets),
+		}).
+		Watches(&source.Channel{Source: r.events}, &handler.EnqueueRequestForObject{}).
 		Complete(r)
 }
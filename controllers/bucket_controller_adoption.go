@@ -0,0 +1,120 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+)
+
+func (r *BucketReconciler) adopt(ctx context.Context, b *storagev1.Bucket, be backend.BucketBackend, a *backend.BucketAttrs) error {
+	if !b.CanAdopt(a.Labels) {
+		return r.reportAdoptionRefused(ctx, b, a)
+	}
+
+	previousOwner := a.Labels[storagev1.BucketOwnerLabel]
+
+	labels := b.OwnerLabels(r.ClusterID)
+
+	var uattrs backend.BucketAttrsToUpdate
+
+	if b.Spec.AdoptionMode == storagev1.AdoptionModeReconcile {
+		uattrs, _ = r.attributesDrift(b, a)
+	}
+
+	for k, v := range labels {
+		uattrs.SetLabel(k, v)
+	}
+
+	// the changes are computed from a, they must not be applied if the
+	// bucket changed since it was read
+	uattrs.MetagenerationMatch = a.Metageneration
+
+	if _, err := be.Update(ctx, b.Spec.Name, uattrs); err != nil {
+		r.Log.Error(err, fmt.Sprintf("unable to label gcs bucket %s as owned", b.Spec.Name))
+
+		return err
+	}
+
+	b.Status.GCSBucketRef = b.Spec.Name
+	b.RemoveCondition(storagev1.BucketConditionAdoptionRefused)
+
+	if b.Spec.AdoptionMode == storagev1.AdoptionModeReconcile {
+		r.reportImmutableDrift(b, a)
+	} else {
+		b.Status.ImportedAttributes = &storagev1.BucketAttributes{
+			Location:     a.Location,
+			StorageClass: a.StorageClass,
+			Labels:       a.Labels,
+		}
+	}
+
+	msg := fmt.Sprintf("gcs bucket %s adopted with policy %s", b.Spec.Name, b.Spec.AdoptionPolicy)
+	if previousOwner != "" {
+		msg = fmt.Sprintf("%s, previous owner: %s", msg, previousOwner)
+	}
+
+	r.Log.Info(msg)
+	r.Recorder.Event(b, corev1.EventTypeNormal, "Adopted", msg)
+
+	return r.Update(ctx, b)
+}
+
+// reportAdoptionRefused flags the resource when the adoption policy doesn't
+// allow to take over the existing GCS bucket, the bucket is not modified.
+func (r *BucketReconciler) reportAdoptionRefused(ctx context.Context, b *storagev1.Bucket, a *backend.BucketAttrs) error {
+	policy := b.Spec.AdoptionPolicy
+	if policy == "" {
+		policy = storagev1.AdoptionPolicyNever
+	}
+
+	msg := fmt.Sprintf("gcs bucket %s exists and adoption policy %s doesn't allow to adopt it", b.Spec.Name, policy)
+	if owner := a.Labels[storagev1.BucketOwnerLabel]; owner != "" {
+		msg = fmt.Sprintf("%s, owner: %s", msg, owner)
+	}
+
+	r.Log.Info(msg)
+
+	if !b.SetCondition(storagev1.BucketConditionAdoptionRefused, corev1.ConditionTrue, string(policy), msg) {
+		return nil
+	}
+
+	ownershipConflictsTotal.WithLabelValues("NotOwner").Inc()
+
+	r.Recorder.Event(b, corev1.EventTypeWarning, "AdoptionRefused", msg)
+
+	return r.Update(ctx, b)
+}
+
+// reportImmutableDrift emits a warning for each attribute of the spec that
+// can't be applied to an existing bucket.
+func (r *BucketReconciler) reportImmutableDrift(b *storagev1.Bucket, a *backend.BucketAttrs) {
+	if b.Spec.Location != "" && !strings.EqualFold(b.Spec.Location, a.Location) {
+		r.Recorder.Event(b, corev1.EventTypeWarning, "Drift", fmt.Sprintf("location %s can't be changed to %s", a.Location, b.Spec.Location))
+	}
+
+	if b.Spec.StorageClass != "" && !strings.EqualFold(b.Spec.StorageClass, a.StorageClass) {
+		r.Recorder.Event(b, corev1.EventTypeWarning, "Drift", fmt.Sprintf("storage class %s can't be changed to %s", a.StorageClass, b.Spec.StorageClass))
+	}
+}
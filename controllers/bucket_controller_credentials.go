@@ -0,0 +1,217 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+
+	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend/gcs"
+)
+
+// bucketBackend returns the backend for the credentials of the provider
+// config referenced by the resource. Without one, the backend impersonating
+// the service account of the namespace, if any, or the operator backend,
+// whose reads are served by the poller.
+func (r *BucketReconciler) bucketBackend(ctx context.Context, b *storagev1.Bucket) (backend.BucketBackend, error) {
+	client, err := r.storageClient(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+
+	if client != nil {
+		return r.wrapBackend(gcs.New(client)), nil
+	}
+
+	return r.Poller.Wrap(r.wrapBackend(r.Backend)), nil
+}
+
+// usageBackend returns the backend used to measure the usage of the bucket,
+// for the same credentials as bucketBackend. The listings are long, they
+// don't go through the circuit breaker shared by the reconciles so a slow
+// one is never taken for an outage.
+func (r *BucketReconciler) usageBackend(ctx context.Context, b *storagev1.Bucket) (backend.BucketBackend, error) {
+	client, err := r.storageClient(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+
+	be := r.Backend
+	if client != nil {
+		be = gcs.New(client)
+	}
+
+	return r.Tracer.Wrap(backend.WithObserver(backend.WithTimeouts(be, r.Timeouts), observeBackend)), nil
+}
+
+// wrapBackend bounds the operations of the backend by the timeouts, sends
+// them through the circuit breaker and records them in the metrics and the
+// traces. The metrics are recorded outside of the breaker so they count the
+// operations it rejects.
+func (r *BucketReconciler) wrapBackend(be backend.BucketBackend) backend.BucketBackend {
+	return r.Tracer.Wrap(backend.WithObserver(r.Breaker.Wrap(backend.WithTimeouts(be, r.Timeouts)), observeBackend))
+}
+
+// storageClient returns the client for the credentials of the provider
+// config referenced by the resource. Without one, the client impersonating
+// the service account of the namespace, nil if there is none.
+func (r *BucketReconciler) storageClient(ctx context.Context, b *storagev1.Bucket) (*storage.Client, error) {
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: b.GetNamespace()}, ns); err != nil {
+		return nil, fmt.Errorf("unable to get namespace %s: %v", b.GetNamespace(), err)
+	}
+
+	if b.Spec.ProviderConfigRef != nil {
+		if r.Clients == nil {
+			return nil, fmt.Errorf("provider config %s can't be used with the storage backend", b.Spec.ProviderConfigRef.Name)
+		}
+
+		return r.providerConfigClient(ctx, ns, b.Spec.ProviderConfigRef.Name)
+	}
+
+	if sa := ns.GetAnnotations()[storagev1.ImpersonateServiceAccountAnnotation]; sa != "" {
+		if r.Clients == nil {
+			return nil, fmt.Errorf("service account %s of namespace %s can't be impersonated with the storage backend", sa, ns.GetName())
+		}
+
+		return r.Clients.Get(ctx, fmt.Sprintf("namespace/%s", ns.GetName()), nil, sa)
+	}
+
+	return nil, nil
+}
+
+// providerConfigClient returns the client for the credentials of a provider
+// config of the namespace. The service account it impersonates must be
+// allowed by the namespace, otherwise a tenant could act as the service
+// account of another one.
+func (r *BucketReconciler) providerConfigClient(ctx context.Context, ns *corev1.Namespace, name string) (*storage.Client, error) {
+	namespace := ns.GetName()
+
+	pc := &storagev1.ProviderConfig{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, pc); err != nil {
+		return nil, fmt.Errorf("unable to get provider config %s: %v", name, err)
+	}
+
+	sa := pc.Spec.ImpersonateServiceAccount
+	if sa != "" && !storagev1.ImpersonationAllowed(ns, sa) {
+		return nil, &backend.Error{
+			Reason: backend.ReasonPermissionDenied,
+			Err:    fmt.Errorf("service account %s of provider config %s is not allowed in namespace %s", sa, name, namespace),
+		}
+	}
+
+	ref := pc.Spec.SecretRef
+	if ref == nil {
+		if sa == "" {
+			return nil, fmt.Errorf("provider config %s defines no credentials", name)
+		}
+
+		return r.Clients.Get(ctx, fmt.Sprintf("providerconfig/%s/%s", namespace, name), nil, sa)
+	}
+
+	s := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, s); err != nil {
+		return nil, fmt.Errorf("unable to get secret %s of provider config %s: %v", ref.Name, name, err)
+	}
+
+	credentials, ok := s.Data[ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s of provider config %s has no key %s", ref.Name, name, ref.Key)
+	}
+
+	return r.Clients.Get(ctx, secretClientKey(namespace, ref.Name)+ref.Key, credentials, sa)
+}
+
+// providerConfigSecretField indexes the provider configs by the name of the
+// secret with their credentials.
+const providerConfigSecretField = "spec.secretRef.name"
+
+// providerConfigSecret returns the secret of the provider config, if any, for
+// the providerConfigSecretField index.
+func providerConfigSecret(o runtime.Object) []string {
+	pc, ok := o.(*storagev1.ProviderConfig)
+	if !ok || pc.Spec.SecretRef == nil {
+		return nil
+	}
+
+	return []string{pc.Spec.SecretRef.Name}
+}
+
+// secretBuckets maps a secret referenced by provider configs to the buckets
+// using them, and drops the clients created with its credentials so they are
+// reconciled with the new credentials. Other secrets are ignored.
+func (r *BucketReconciler) secretBuckets(o handler.MapObject) []ctrl.Request {
+	ctx := context.Background()
+	ns, name := o.Meta.GetNamespace(), o.Meta.GetName()
+
+	configs := &storagev1.ProviderConfigList{}
+	if err := r.List(ctx, configs, client.InNamespace(ns), client.MatchingFields{providerConfigSecretField: name}); err != nil {
+		r.Log.Error(err, "unable to list provider configs")
+
+		return nil
+	}
+
+	names := map[string]bool{}
+	for _, pc := range configs.Items {
+		if ref := pc.Spec.SecretRef; ref != nil && ref.Name == name {
+			names[pc.GetName()] = true
+		}
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	if r.Clients != nil {
+		r.Clients.Invalidate(secretClientKey(ns, name))
+	}
+
+	buckets := &storagev1.BucketList{}
+	if err := r.List(ctx, buckets, client.InNamespace(ns)); err != nil {
+		r.Log.Error(err, "unable to list buckets")
+
+		return nil
+	}
+
+	var result []ctrl.Request
+	for _, b := range buckets.Items {
+		if ref := b.Spec.ProviderConfigRef; ref != nil && names[ref.Name] {
+			result = append(result, ctrl.Request{
+				NamespacedName: types.NamespacedName{Namespace: b.GetNamespace(), Name: b.GetName()},
+			})
+		}
+	}
+
+	return result
+}
+
+// secretClientKey is the prefix of the keys of the clients created with the
+// credentials of a secret.
+func secretClientKey(namespace, name string) string {
+	return fmt.Sprintf("secret/%s/%s/", namespace, name)
+}
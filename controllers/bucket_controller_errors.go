@@ -0,0 +1,157 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+)
+
+// backendError records an error of the storage backend classified by the
+// backend in the BackendError condition, with an event named after its
+// reason, and returns the result of the reconcile:
+//
+//   - NameUnavailable is not retried, the name must change.
+//   - PreconditionFailed is retried right away with the updated state.
+//   - PermissionDenied and the transient errors are retried with backoff.
+//
+// Other errors only emit the event and are retried with backoff. Throttled
+// operations are requeued when the rate limiter allows them, and operations
+// rejected by the open circuit breaker when it lets them through, with the
+// BackendUnavailable condition set.
+func (r *BucketReconciler) backendError(ctx context.Context, b *storagev1.Bucket, op, event string, err error) (ctrl.Result, error) {
+	var te *throttledError
+	if errors.As(err, &te) {
+		r.Log.Info(fmt.Sprintf("%s of gcs bucket %s throttled, retrying in %s", te.op, b.Spec.Name, te.delay))
+
+		return ctrl.Result{RequeueAfter: te.delay}, nil
+	}
+
+	var ce *backend.CircuitOpenError
+	if errors.As(err, &ce) {
+		return r.backendUnavailable(ctx, b, op, ce)
+	}
+
+	var e *backend.Error
+	if !errors.As(err, &e) {
+		r.Recorder.Event(b, corev1.EventTypeWarning, event, fmt.Sprintf("failed %s: %s", op, err))
+
+		return ctrl.Result{}, fmt.Errorf("error when %s: %v", op, err)
+	}
+
+	msg := fmt.Sprintf("failed %s: %s", op, e.Err)
+	if e.Permission != "" {
+		msg = fmt.Sprintf("%s, missing permission %s", msg, e.Permission)
+	}
+
+	r.Log.Info(msg)
+	r.Recorder.Event(b, corev1.EventTypeWarning, string(e.Reason), msg)
+
+	if b.SetCondition(storagev1.BucketConditionBackendError, corev1.ConditionTrue, string(e.Reason), msg) {
+		if err := r.Update(ctx, b); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	switch e.Reason {
+	case backend.ReasonNameUnavailable:
+		return ctrl.Result{}, nil
+	case backend.ReasonPreconditionFailed:
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	return ctrl.Result{}, fmt.Errorf("error when %s: %v", op, err)
+}
+
+// backendUnavailable flags the resource while the circuit breaker rejects
+// the operations on the storage backend, the reconcile is retried once the
+// breaker lets an operation through.
+func (r *BucketReconciler) backendUnavailable(ctx context.Context, b *storagev1.Bucket, op string, err *backend.CircuitOpenError) (ctrl.Result, error) {
+	msg := fmt.Sprintf("%s of gcs bucket %s postponed: %s", op, b.Spec.Name, err)
+	r.Log.Info(msg)
+
+	if b.SetCondition(storagev1.BucketConditionBackendUnavailable, corev1.ConditionTrue, "CircuitOpen", msg) {
+		r.Recorder.Event(b, corev1.EventTypeWarning, "BackendUnavailable", msg)
+
+		if err := r.Update(ctx, b); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: err.RetryAfter}, nil
+}
+
+// clearBackendError removes the BackendError and BackendUnavailable
+// conditions once the operation succeeds.
+func (r *BucketReconciler) clearBackendError(ctx context.Context, b *storagev1.Bucket) error {
+	removed := b.RemoveCondition(storagev1.BucketConditionBackendError)
+	if b.RemoveCondition(storagev1.BucketConditionBackendUnavailable) {
+		removed = true
+	}
+
+	if !removed {
+		return nil
+	}
+
+	return r.Update(ctx, b)
+}
+
+// throttledError is returned when an operation must wait for the rate
+// limiter of the project.
+type throttledError struct {
+	op    string
+	delay time.Duration
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("%s throttled for %s", e.op, e.delay)
+}
+
+// throttle takes a token of the project of the resource for the operation,
+// it returns a *throttledError when the operation must wait for it.
+func (r *BucketReconciler) throttle(b *storagev1.Bucket, op string) error {
+	delay := r.Limiter.Reserve(b.Spec.Project, throttleKey(b, op))
+	if delay == 0 {
+		return nil
+	}
+
+	throttleWaitSeconds.WithLabelValues(op).Observe(delay.Seconds())
+
+	return &throttledError{op: op, delay: delay}
+}
+
+// forgetThrottle drops the tokens reserved for the operations of the
+// resource, it's called once the resource is deleted.
+func (r *BucketReconciler) forgetThrottle(b *storagev1.Bucket) {
+	for _, op := range []string{"create", "delete"} {
+		r.Limiter.Forget(throttleKey(b, op))
+	}
+}
+
+// throttleKey identifies the operation of the resource in the rate limiter.
+func throttleKey(b *storagev1.Bucket, op string) string {
+	return fmt.Sprintf("%s/%s/%s", op, b.GetNamespace(), b.GetName())
+}
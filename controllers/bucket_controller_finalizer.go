@@ -19,8 +19,10 @@ package controllers
 
 import (
 	"context"
+	"time"
 
 	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+	"github.com/yriveiro/gcs-bucket-operator/internal/tracing"
 )
 
 func (r *BucketReconciler) addFinalizer(ctx context.Context, b *storagev1.Bucket) error {
@@ -28,7 +30,10 @@ func (r *BucketReconciler) addFinalizer(ctx context.Context, b *storagev1.Bucket
 	return r.Update(ctx, b)
 }
 
-func (r *BucketReconciler) handleFinalizer(ctx context.Context, b *storagev1.Bucket) error {
+func (r *BucketReconciler) handleFinalizer(ctx context.Context, b *storagev1.Bucket) (err error) {
+	ctx, span := r.Tracer.StartSpan(ctx, "HandleFinalizer", tracing.String("bucket", b.Spec.Name))
+	defer func() { span.End(err) }()
+
 	if !b.HasFinalizer(storagev1.BucketFinalizerName) {
 		return nil
 	}
@@ -38,5 +43,13 @@ func (r *BucketReconciler) handleFinalizer(ctx context.Context, b *storagev1.Buc
 	}
 
 	b.RemoveFinalizer(storagev1.BucketFinalizerName)
-	return r.Update(ctx, b)
+	if err := r.Update(ctx, b); err != nil {
+		return err
+	}
+
+	r.forgetThrottle(b)
+
+	deletionSeconds.Observe(time.Since(b.GetDeletionTimestamp().Time).Seconds())
+
+	return nil
 }
@@ -3,32 +3,58 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
 
-	"cloud.google.com/go/storage"
 	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
 )
 
 func (r *BucketReconciler) delete(ctx context.Context, b *storagev1.Bucket) error {
 	r.Log.Info(fmt.Sprintf("deleting gcs bucket: %s from namespace: %s", b.GetName(), b.GetNamespace()))
 
-	bkt := r.StorageClient.Bucket(b.Spec.Name)
-	a, err := bkt.Attrs(ctx)
+	be, err := r.bucketBackend(ctx, b)
+	if err != nil {
+		return err
+	}
+
+	a, err := be.Get(ctx, b.Spec.Name)
 
-	if err == storage.ErrBucketNotExist {
+	if err == backend.ErrBucketNotExist {
 		r.Log.Info(fmt.Sprintf("bucket %s not exist, skipping deletion", b.Spec.Name))
 
 		return nil
 	}
 
+	if err != nil {
+		r.Log.Error(err, fmt.Sprintf("unable to fetch gcs bucket %s status", b.Spec.Name))
+
+		return err
+	}
+
 	if b.Spec.RemoveOnDelete {
-		if !b.Owned(a) {
+		if storagev1.ForeignCluster(a.Labels, r.ClusterID) {
+			err := fmt.Errorf("gcs bucket: %s is managed from cluster %s", b.Spec.Name, a.Labels[storagev1.BucketClusterLabel])
+			r.Log.Error(err, "deletion aborted")
+			ownershipConflictsTotal.WithLabelValues("ForeignCluster").Inc()
+
+			return err
+		}
+
+		if !b.Owned(a.Labels, r.ClusterID) && !b.LegacyOwned(a.Labels) {
 			err := fmt.Errorf(fmt.Sprintf("resource: %s not owner of the gcs bucket", b.Spec.Name))
 			r.Log.Error(err, "deletion aborted")
+			ownershipConflictsTotal.WithLabelValues("NotOwner").Inc()
+
+			return err
+		}
 
+		if err := r.throttle(b, "delete"); err != nil {
 			return err
 		}
 
-		if err := bkt.Delete(ctx); err != nil {
+		if err := be.Delete(ctx, b.Spec.Name); err != nil {
 			r.Log.Error(err, fmt.Sprintf("error deleting bucket: %s from gcp", b.Spec.Name))
 			return err
 		}
@@ -41,49 +67,171 @@ func (r *BucketReconciler) delete(ctx context.Context, b *storagev1.Bucket) erro
 }
 
 func (r *BucketReconciler) create(ctx context.Context, b *storagev1.Bucket) error {
-	bkt := r.StorageClient.Bucket(b.Spec.Name)
-	a, err := bkt.Attrs(ctx)
+	be, err := r.bucketBackend(ctx, b)
+	if err != nil {
+		return err
+	}
+
+	if err := r.reportUnsupported(ctx, b, be); err != nil {
+		return err
+	}
+
+	a, err := be.Get(ctx, b.Spec.Name)
 
 	if err == nil {
-		if !b.Owned(a) {
-			r.Log.Info(fmt.Sprintf("gcs bucket %s exists but %s is not owner", b.Spec.Name, b.GetName()))
+		if storagev1.ForeignCluster(a.Labels, r.ClusterID) {
+			return r.reportForeignOwner(ctx, b, a)
+		}
 
-			return nil
+		if b.RemoveCondition(storagev1.BucketConditionForeignOwner) {
+			if err := r.Update(ctx, b); err != nil {
+				return err
+			}
 		}
 
-		r.Log.Info(fmt.Sprintf("gcs bucket %s exists and %s is the owner", b.Spec.Name, b.GetName()))
-		if b.Status.GCSBucketRef == "" {
-			b.Status.GCSBucketRef = b.Spec.Name
+		if b.LegacyOwned(a.Labels) {
+			return r.migrateOwnership(ctx, b, be, a)
+		}
 
-			return r.Update(ctx, b)
+		if !b.Owned(a.Labels, r.ClusterID) {
+			return r.adopt(ctx, b, be, a)
 		}
 
-		return nil
+		r.Log.Info(fmt.Sprintf("gcs bucket %s exists and %s is the owner", b.Spec.Name, b.GetName()))
+
+		return r.syncAttributes(ctx, b, be, a)
 	}
 
-	if err != storage.ErrBucketNotExist {
+	if err != backend.ErrBucketNotExist {
 		r.Log.Error(err, fmt.Sprintf("unable to fetch gcs bucket %s status", b.Spec.Name))
 
 		return err
 	}
 
+	// the bucket existed, it was deleted outside of the operator
+	if b.Status.GCSBucketRef != "" {
+		if recreate, err := r.bucketLost(ctx, b); !recreate || err != nil {
+			return err
+		}
+	}
+
 	r.Log.Info(fmt.Sprintf("gcs bucket %s not found, creating", b.Spec.Name))
 
-	labels := map[string]string{storagev1.BucketOwnerLabel: b.ObjectMeta.GetName()}
+	if err := r.throttle(b, "create"); err != nil {
+		return err
+	}
+
+	if err := be.Create(ctx, b.Spec.Project, r.bucketAttrs(b)); err != nil {
+		r.Log.Error(err, fmt.Sprintf("unable to create gcs bucket %s", b.Spec.Name))
+
+		return err
+	}
+
+	b.Status.GCSBucketRef = b.Spec.Name
+	b.RemoveCondition(storagev1.BucketConditionLost)
+	b.RemoveCondition(storagev1.BucketConditionAdoptionRefused)
+
+	return r.Update(ctx, b)
+}
 
-	bktAttr := &storage.BucketAttrs{
+// bucketAttrs returns the attributes of the bucket defined by the spec.
+func (r *BucketReconciler) bucketAttrs(b *storagev1.Bucket) *backend.BucketAttrs {
+	a := &backend.BucketAttrs{
+		Name:         b.Spec.Name,
 		StorageClass: b.Spec.StorageClass,
 		Location:     b.Spec.Location,
-		Labels:       labels,
+		Labels:       b.BucketLabels(r.ClusterID),
+		Lifecycle:    lifecycleRules(b.Spec.Lifecycle),
 	}
 
-	if err := bkt.Create(ctx, b.Spec.Project, bktAttr); err != nil {
-		r.Log.Error(err, fmt.Sprintf("unable to create gcs bucket %s", b.Spec.Name))
+	if b.Spec.UniformBucketLevelAccess != nil {
+		a.UniformBucketLevelAccess = *b.Spec.UniformBucketLevelAccess
+	}
+
+	if b.Spec.RetentionPeriod != nil {
+		a.RetentionPeriod = b.Spec.RetentionPeriod.Duration
+	}
+
+	if b.Spec.Versioning != nil {
+		a.VersioningEnabled = *b.Spec.Versioning
+	}
+
+	return a
+}
+
+func lifecycleRules(rules []storagev1.LifecycleRule) []backend.LifecycleRule {
+	var result []backend.LifecycleRule
+
+	for _, r := range rules {
+		result = append(result, backend.LifecycleRule{
+			Action:           string(r.Action),
+			StorageClass:     strings.ToUpper(r.StorageClass),
+			AgeDays:          r.AgeDays,
+			NumNewerVersions: r.NumNewerVersions,
+		})
+	}
+
+	return result
+}
+
+// reportUnsupported flags the resource when the spec defines attributes the
+// backend can't apply, the bucket is managed without them.
+func (r *BucketReconciler) reportUnsupported(ctx context.Context, b *storagev1.Bucket, be backend.BucketBackend) error {
+	unsupported := be.Unsupported(r.bucketAttrs(b))
+	if len(unsupported) == 0 {
+		if b.RemoveCondition(storagev1.BucketConditionUnsupported) {
+			return r.Update(ctx, b)
+		}
+
+		return nil
+	}
+
+	msg := fmt.Sprintf("ignored by the storage backend: %s", strings.Join(unsupported, "; "))
+
+	if !b.SetCondition(storagev1.BucketConditionUnsupported, corev1.ConditionTrue, "UnsupportedAttributes", msg) {
+		return nil
+	}
+
+	r.Log.Info(fmt.Sprintf("gcs bucket %s: %s", b.Spec.Name, msg))
+	r.Recorder.Event(b, corev1.EventTypeWarning, "Unsupported", msg)
+
+	return r.Update(ctx, b)
+}
+
+// migrateOwnership replaces the name-only owner label set by older versions
+// of the operator with the owner labels of the resource.
+func (r *BucketReconciler) migrateOwnership(ctx context.Context, b *storagev1.Bucket, be backend.BucketBackend, a *backend.BucketAttrs) error {
+	uattrs := backend.BucketAttrsToUpdate{MetagenerationMatch: a.Metageneration}
+	for k, v := range b.OwnerLabels(r.ClusterID) {
+		uattrs.SetLabel(k, v)
+	}
+
+	if _, err := be.Update(ctx, b.Spec.Name, uattrs); err != nil {
+		r.Log.Error(err, fmt.Sprintf("unable to migrate owner labels of gcs bucket %s", b.Spec.Name))
 
 		return err
 	}
 
-	b.Status.GCSBucketRef = b.Spec.Name
+	msg := fmt.Sprintf("owner labels of gcs bucket %s migrated", b.Spec.Name)
+	r.Log.Info(msg)
+	r.Recorder.Event(b, corev1.EventTypeNormal, "OwnershipMigrated", msg)
+
+	return nil
+}
+
+// reportForeignOwner flags the resource when the GCS bucket is managed from
+// another cluster, the bucket is never modified in that case.
+func (r *BucketReconciler) reportForeignOwner(ctx context.Context, b *storagev1.Bucket, a *backend.BucketAttrs) error {
+	msg := fmt.Sprintf("gcs bucket %s is managed from cluster %s", b.Spec.Name, a.Labels[storagev1.BucketClusterLabel])
+	r.Log.Info(msg)
+
+	if !b.SetCondition(storagev1.BucketConditionForeignOwner, corev1.ConditionTrue, "ManagedByOtherCluster", msg) {
+		return nil
+	}
+
+	ownershipConflictsTotal.WithLabelValues("ForeignCluster").Inc()
+
+	r.Recorder.Event(b, corev1.EventTypeWarning, "ForeignOwner", msg)
 
 	return r.Update(ctx, b)
 }
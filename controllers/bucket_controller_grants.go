@@ -0,0 +1,191 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+)
+
+// writeRole is the role granted to the writers of a bucket.
+const writeRole = "roles/storage.objectCreator"
+
+// reconcileGrants grants the write role to the writers of the spec and
+// records the grants made in the status. Only the recorded grants are
+// revoked, when a writer is removed from the spec or while the limits are
+// enforced, so the grants made outside of the operator are never changed.
+func (r *BucketReconciler) reconcileGrants(ctx context.Context, b *storagev1.Bucket) error {
+	if c := b.GetCondition(storagev1.BucketConditionLost); c != nil && c.Status == corev1.ConditionTrue {
+		return nil
+	}
+
+	enforced := b.Spec.Limits != nil && b.Spec.Limits.Enforcement == storagev1.LimitEnforcementRemoveWriteGrants &&
+		len(b.LimitViolations()) > 0
+
+	var writers []string
+	if !enforced {
+		writers = b.Spec.Writers
+	}
+
+	if sameMembers(writers, b.Status.ManagedWriters) {
+		return nil
+	}
+
+	be, err := r.bucketBackend(ctx, b)
+	if err != nil {
+		return err
+	}
+
+	policy, err := be.IAMPolicy(ctx, b.Spec.Name)
+	if errors.Is(err, backend.ErrNotSupported) {
+		msg := fmt.Sprintf("writers of gcs bucket %s ignored, the storage backend has no IAM policies", b.Spec.Name)
+		r.Log.Info(msg)
+		r.Recorder.Event(b, corev1.EventTypeWarning, "Unsupported", msg)
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	members, managed, granted, revoked := updateGrants(policy.Bindings[writeRole], b.Status.ManagedWriters, writers)
+
+	if len(granted) > 0 || len(revoked) > 0 {
+		if policy.Bindings == nil {
+			policy.Bindings = map[string][]string{}
+		}
+
+		policy.Bindings[writeRole] = members
+
+		if err := be.SetIAMPolicy(ctx, b.Spec.Name, policy); err != nil {
+			return err
+		}
+	}
+
+	if len(granted) > 0 {
+		msg := fmt.Sprintf("%s granted on gcs bucket %s to %s", writeRole, b.Spec.Name, strings.Join(granted, ", "))
+		r.Log.Info(msg)
+		r.Recorder.Event(b, corev1.EventTypeNormal, "WriteGrantsAdded", msg)
+	}
+
+	if len(revoked) > 0 {
+		msg := fmt.Sprintf("%s revoked on gcs bucket %s from %s", writeRole, b.Spec.Name, strings.Join(revoked, ", "))
+		if enforced {
+			msg += ", the limits are exceeded"
+		}
+
+		r.Log.Info(msg)
+		r.Recorder.Event(b, corev1.EventTypeWarning, "WriteGrantsRemoved", msg)
+	}
+
+	if sameMembers(managed, b.Status.ManagedWriters) {
+		return nil
+	}
+
+	b.Status.ManagedWriters = managed
+
+	return r.Update(ctx, b)
+}
+
+// updateGrants returns the members of the write role with the writers
+// granted and the managed writers no longer wanted revoked, along with the
+// writers managed by the operator afterwards. Writers already granted the
+// role outside of the operator are left unmanaged.
+func updateGrants(members, managed, writers []string) (result, nowManaged, granted, revoked []string) {
+	wanted := map[string]bool{}
+	for _, w := range writers {
+		wanted[w] = true
+	}
+
+	owned := map[string]bool{}
+	for _, m := range managed {
+		owned[m] = true
+	}
+
+	present := map[string]bool{}
+
+	for _, m := range members {
+		if owned[m] && !wanted[m] {
+			revoked = append(revoked, m)
+
+			continue
+		}
+
+		present[m] = true
+		result = append(result, m)
+	}
+
+	for _, m := range managed {
+		if !wanted[m] {
+			continue
+		}
+
+		nowManaged = append(nowManaged, m)
+
+		if !present[m] {
+			present[m] = true
+			result = append(result, m)
+			granted = append(granted, m)
+		}
+	}
+
+	for _, w := range writers {
+		if present[w] {
+			continue
+		}
+
+		present[w] = true
+		result = append(result, w)
+		nowManaged = append(nowManaged, w)
+		granted = append(granted, w)
+	}
+
+	sort.Strings(nowManaged)
+
+	return result, nowManaged, granted, revoked
+}
+
+// sameMembers returns true if both lists have the same members, in any
+// order.
+func sameMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	set := map[string]bool{}
+	for _, m := range a {
+		set[m] = true
+	}
+
+	for _, m := range b {
+		if !set[m] {
+			return false
+		}
+	}
+
+	return true
+}
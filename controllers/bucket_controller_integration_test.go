@@ -0,0 +1,276 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/googleapi"
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+)
+
+var _ = Describe("Bucket controller", func() {
+	const (
+		timeout  = 10 * time.Second
+		interval = 100 * time.Millisecond
+	)
+
+	ctx := context.Background()
+
+	var ns string
+
+	BeforeEach(func() {
+		n := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "bucket-test-"}}
+		Expect(k8sClient.Create(ctx, n)).To(Succeed())
+
+		ns = n.GetName()
+	})
+
+	newBucket := func(name string) *storagev1.Bucket {
+		return &storagev1.Bucket{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns},
+			Spec: storagev1.BucketSpec{
+				// GCS bucket names are global, the namespace keeps them
+				// unique across specs.
+				Name:         ns + "-" + name,
+				Project:      "my-project",
+				Location:     "EU",
+				StorageClass: "STANDARD",
+			},
+		}
+	}
+
+	fetch := func(name string) func() *storagev1.Bucket {
+		return func() *storagev1.Bucket {
+			b := &storagev1.Bucket{}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: ns, Name: name}, b); err != nil {
+				return nil
+			}
+
+			return b
+		}
+	}
+
+	gcsBucketRef := func(name string) func() string {
+		return func() string {
+			if b := fetch(name)(); b != nil {
+				return b.Status.GCSBucketRef
+			}
+
+			return ""
+		}
+	}
+
+	hasEvent := func(name, reason string) func() bool {
+		return func() bool {
+			events := &corev1.EventList{}
+			if err := k8sClient.List(ctx, events, client.InNamespace(ns)); err != nil {
+				return false
+			}
+
+			for _, e := range events.Items {
+				if e.InvolvedObject.Name == name && e.Reason == reason {
+					return true
+				}
+			}
+
+			return false
+		}
+	}
+
+	It("creates the GCS bucket", func() {
+		b := newBucket("create")
+		Expect(k8sClient.Create(ctx, b)).To(Succeed())
+
+		Eventually(gcsBucketRef("create"), timeout, interval).Should(Equal(b.Spec.Name))
+
+		a, err := gcsServer.Backend.Get(ctx, b.Spec.Name)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(a.Location).To(Equal("EU"))
+
+		b = fetch("create")()
+		Expect(b.HasFinalizer(storagev1.BucketFinalizerName)).To(BeTrue())
+		Expect(b.Owned(a.Labels, testClusterID)).To(BeTrue())
+	})
+
+	It("adopts an unowned GCS bucket", func() {
+		b := newBucket("adopt")
+		b.Spec.AdoptionPolicy = storagev1.AdoptionPolicyIfUnowned
+
+		Expect(gcsServer.Backend.Create(ctx, "my-project", &backend.BucketAttrs{Name: b.Spec.Name, Location: "EU"})).To(Succeed())
+		Expect(k8sClient.Create(ctx, b)).To(Succeed())
+
+		Eventually(gcsBucketRef("adopt"), timeout, interval).Should(Equal(b.Spec.Name))
+		Eventually(hasEvent("adopt", "Adopted"), timeout, interval).Should(BeTrue())
+
+		Expect(fetch("adopt")().Status.ImportedAttributes).ToNot(BeNil())
+	})
+
+	It("doesn't bind a GCS bucket owned by another resource", func() {
+		b := newBucket("conflict")
+
+		attrs := &backend.BucketAttrs{
+			Name:   b.Spec.Name,
+			Labels: map[string]string{storagev1.BucketOwnerLabel: "another-owner"},
+		}
+		Expect(gcsServer.Backend.Create(ctx, "my-project", attrs)).To(Succeed())
+		Expect(k8sClient.Create(ctx, b)).To(Succeed())
+
+		Eventually(func() bool {
+			b := fetch("conflict")()
+			return b != nil && b.HasFinalizer(storagev1.BucketFinalizerName)
+		}, timeout, interval).Should(BeTrue())
+		Consistently(gcsBucketRef("conflict"), time.Second, interval).Should(BeEmpty())
+
+		a, err := gcsServer.Backend.Get(ctx, b.Spec.Name)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(a.Labels[storagev1.BucketOwnerLabel]).To(Equal("another-owner"))
+	})
+
+	It("reports a GCS bucket managed from another cluster", func() {
+		b := newBucket("foreign")
+
+		attrs := &backend.BucketAttrs{
+			Name:   b.Spec.Name,
+			Labels: map[string]string{storagev1.BucketClusterLabel: "another-cluster"},
+		}
+		Expect(gcsServer.Backend.Create(ctx, "my-project", attrs)).To(Succeed())
+		Expect(k8sClient.Create(ctx, b)).To(Succeed())
+
+		Eventually(func() *storagev1.BucketCondition {
+			if b := fetch("foreign")(); b != nil {
+				return b.GetCondition(storagev1.BucketConditionForeignOwner)
+			}
+
+			return nil
+		}, timeout, interval).ShouldNot(BeNil())
+		Expect(gcsBucketRef("foreign")()).To(BeEmpty())
+	})
+
+	It("corrects the drift of an adopted GCS bucket in Reconcile mode", func() {
+		b := newBucket("drift")
+		b.Spec.AdoptionPolicy = storagev1.AdoptionPolicyIfUnowned
+		b.Spec.AdoptionMode = storagev1.AdoptionModeReconcile
+		b.Spec.Labels = map[string]string{"team": "storage"}
+		enabled := true
+		b.Spec.UniformBucketLevelAccess = &enabled
+
+		Expect(gcsServer.Backend.Create(ctx, "my-project", &backend.BucketAttrs{Name: b.Spec.Name, Location: "US"})).To(Succeed())
+		Expect(k8sClient.Create(ctx, b)).To(Succeed())
+
+		Eventually(gcsBucketRef("drift"), timeout, interval).Should(Equal(b.Spec.Name))
+
+		a, err := gcsServer.Backend.Get(ctx, b.Spec.Name)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(a.Labels).To(HaveKeyWithValue("team", "storage"))
+		Expect(a.UniformBucketLevelAccess).To(BeTrue())
+
+		// the location can't be changed, it's reported
+		Eventually(hasEvent("drift", "Drift"), timeout, interval).Should(BeTrue())
+	})
+
+	It("deletes the GCS bucket with the resource", func() {
+		b := newBucket("delete")
+		b.Spec.RemoveOnDelete = true
+		Expect(k8sClient.Create(ctx, b)).To(Succeed())
+
+		Eventually(gcsBucketRef("delete"), timeout, interval).Should(Equal(b.Spec.Name))
+		Expect(k8sClient.Delete(ctx, fetch("delete")())).To(Succeed())
+
+		Eventually(func() bool {
+			err := k8sClient.Get(ctx, types.NamespacedName{Namespace: ns, Name: "delete"}, &storagev1.Bucket{})
+			return k8serr.IsNotFound(err)
+		}, timeout, interval).Should(BeTrue())
+
+		_, err := gcsServer.Backend.Get(ctx, b.Spec.Name)
+		Expect(err).To(Equal(backend.ErrBucketNotExist))
+	})
+
+	It("retries the creation when GCS fails", func() {
+		gcsServer.Backend.SetError("Create", &googleapi.Error{
+			Code:    http.StatusForbidden,
+			Message: "sa@my-project.iam.gserviceaccount.com does not have storage.buckets.create access to the Google Cloud project.",
+		})
+
+		b := newBucket("retry")
+		Expect(k8sClient.Create(ctx, b)).To(Succeed())
+
+		Eventually(hasEvent("retry", "PermissionDenied"), timeout, interval).Should(BeTrue())
+		Expect(gcsBucketRef("retry")()).To(BeEmpty())
+
+		Eventually(func() string {
+			if c := fetch("retry")().GetCondition(storagev1.BucketConditionBackendError); c != nil {
+				return c.Message
+			}
+
+			return ""
+		}, timeout, interval).Should(ContainSubstring("missing permission storage.buckets.create"))
+
+		gcsServer.Backend.SetError("Create", nil)
+
+		Eventually(gcsBucketRef("retry"), timeout, interval).Should(Equal(b.Spec.Name))
+		Eventually(func() *storagev1.BucketCondition {
+			return fetch("retry")().GetCondition(storagev1.BucketConditionBackendError)
+		}, timeout, interval).Should(BeNil())
+	})
+
+	It("doesn't retry the creation when the name is taken", func() {
+		gcsServer.Backend.SetError("Create", &googleapi.Error{Code: http.StatusConflict, Message: "taken"})
+		defer gcsServer.Backend.SetError("Create", nil)
+
+		Expect(k8sClient.Create(ctx, newBucket("taken"))).To(Succeed())
+
+		Eventually(hasEvent("taken", "NameUnavailable"), timeout, interval).Should(BeTrue())
+		Eventually(func() string {
+			if c := fetch("taken")().GetCondition(storagev1.BucketConditionBackendError); c != nil {
+				return c.Reason
+			}
+
+			return ""
+		}, timeout, interval).Should(Equal("NameUnavailable"))
+		Expect(gcsBucketRef("taken")()).To(BeEmpty())
+	})
+
+	It("keeps the finalizer while the deletion of the GCS bucket fails", func() {
+		b := newBucket("stuck")
+		b.Spec.RemoveOnDelete = true
+		Expect(k8sClient.Create(ctx, b)).To(Succeed())
+
+		Eventually(gcsBucketRef("stuck"), timeout, interval).Should(Equal(b.Spec.Name))
+
+		gcsServer.Backend.SetError("Delete", &googleapi.Error{Code: http.StatusForbidden, Message: "denied"})
+		Expect(k8sClient.Delete(ctx, fetch("stuck")())).To(Succeed())
+
+		Consistently(func() *storagev1.Bucket { return fetch("stuck")() }, time.Second, interval).ShouldNot(BeNil())
+
+		gcsServer.Backend.SetError("Delete", nil)
+
+		Eventually(func() *storagev1.Bucket { return fetch("stuck")() }, timeout, interval).Should(BeNil())
+	})
+})
@@ -0,0 +1,53 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+)
+
+// enforceLimits flags the resource when the usage of the GCS bucket exceeds
+// the limits of the spec, the condition is removed once the usage drops
+// below them. It only reads the usage recorded in the status, the storage
+// backend is not called.
+func (r *BucketReconciler) enforceLimits(ctx context.Context, b *storagev1.Bucket) error {
+	violations := b.LimitViolations()
+	if len(violations) == 0 {
+		if b.RemoveCondition(storagev1.BucketConditionLimitExceeded) {
+			return r.Update(ctx, b)
+		}
+
+		return nil
+	}
+
+	msg := strings.Join(violations, "; ")
+
+	if b.SetCondition(storagev1.BucketConditionLimitExceeded, corev1.ConditionTrue, "LimitExceeded", msg) {
+		r.Log.Info(msg)
+		r.Recorder.Event(b, corev1.EventTypeWarning, "LimitExceeded", msg)
+
+		return r.Update(ctx, b)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+
+	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+)
+
+// enforcePolicies flags the resource when it violates the bucket policies of
+// its namespace, the bucket is not applied in that case.
+func (r *BucketReconciler) enforcePolicies(ctx context.Context, b *storagev1.Bucket) (StopReconciler, error) {
+	violations, err := storagev1.EvaluatePolicies(ctx, r, r.Rules, b)
+	if err != nil {
+		return true, err
+	}
+
+	if len(violations) == 0 {
+		if b.RemoveCondition(storagev1.BucketConditionPolicyViolation) {
+			return false, r.Update(ctx, b)
+		}
+
+		return false, nil
+	}
+
+	msg := strings.Join(violations, "; ")
+	r.Log.Info(msg)
+
+	if b.SetCondition(storagev1.BucketConditionPolicyViolation, corev1.ConditionTrue, "PolicyViolation", msg) {
+		r.Recorder.Event(b, corev1.EventTypeWarning, "PolicyViolation", msg)
+
+		return true, r.Update(ctx, b)
+	}
+
+	return true, nil
+}
+
+// allBuckets maps any object to a request for every Bucket, it's used to
+// evaluate again the buckets when a cluster wide setting changes.
+func (r *BucketReconciler) allBuckets(_ handler.MapObject) []ctrl.Request {
+	buckets := &storagev1.BucketList{}
+	if err := r.List(context.Background(), buckets); err != nil {
+		r.Log.Error(err, "unable to list buckets")
+
+		return nil
+	}
+
+	result := make([]ctrl.Request, 0, len(buckets.Items))
+	for _, b := range buckets.Items {
+		result = append(result, ctrl.Request{
+			NamespacedName: types.NamespacedName{Namespace: b.GetNamespace(), Name: b.GetName()},
+		})
+	}
+
+	return result
+}
@@ -0,0 +1,92 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+	"github.com/yriveiro/gcs-bucket-operator/internal/audit"
+)
+
+// polledProjects returns the projects of the buckets, except the buckets of
+// provider configs whose projects the operator credentials may not reach.
+func (r *BucketReconciler) polledProjects(ctx context.Context) ([]string, error) {
+	buckets := &storagev1.BucketList{}
+	if err := r.List(ctx, buckets); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+
+	var result []string
+
+	for _, b := range buckets.Items {
+		if b.Spec.ProviderConfigRef != nil || seen[b.Spec.Project] {
+			continue
+		}
+
+		seen[b.Spec.Project] = true
+		result = append(result, b.Spec.Project)
+	}
+
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// auditEvent reconciles the resources managing the GCS bucket changed by
+// the operation of the audit log, the attributes cached by the poller are
+// outdated.
+func (r *BucketReconciler) auditEvent(e audit.Event) {
+	r.Log.Info(fmt.Sprintf("gcs bucket %s changed: %s", e.Bucket, e.Method))
+
+	r.Poller.Invalidate(e.Bucket)
+	r.enqueueGCSBucket(e.Bucket)
+}
+
+// enqueueGCSBucket sends the resources managing the GCS bucket to the
+// reconciler, it's called when the bucket changed outside of the operator.
+// It doesn't block: when the channel is full, e.g. the controller is not
+// running because the manager is not the leader, the resource is dropped and
+// left to the next resync.
+func (r *BucketReconciler) enqueueGCSBucket(name string) {
+	buckets := &storagev1.BucketList{}
+	if err := r.List(context.Background(), buckets); err != nil {
+		r.Log.Error(err, "unable to list buckets")
+
+		return
+	}
+
+	for i := range buckets.Items {
+		b := &buckets.Items[i]
+		if b.Spec.Name != name {
+			continue
+		}
+
+		select {
+		case r.events <- event.GenericEvent{Meta: b, Object: b}:
+		default:
+			r.Log.Info(fmt.Sprintf("unable to enqueue bucket %s/%s, the events channel is full", b.GetNamespace(), b.GetName()))
+		}
+	}
+}
@@ -0,0 +1,53 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+
+	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+)
+
+// enforceProject flags the resource when its project is not the one its
+// namespace is bound to, the bucket is not applied in that case.
+func (r *BucketReconciler) enforceProject(ctx context.Context, b *storagev1.Bucket) (StopReconciler, error) {
+	msg, err := storagev1.EvaluateProject(ctx, r, b, r.RequireProjectBinding)
+	if err != nil {
+		return true, err
+	}
+
+	if msg == "" {
+		if b.RemoveCondition(storagev1.BucketConditionProjectMismatch) {
+			return false, r.Update(ctx, b)
+		}
+
+		return false, nil
+	}
+
+	r.Log.Info(msg)
+
+	if b.SetCondition(storagev1.BucketConditionProjectMismatch, corev1.ConditionTrue, "ProjectMismatch", msg) {
+		r.Recorder.Event(b, corev1.EventTypeWarning, "ProjectMismatch", msg)
+
+		return true, r.Update(ctx, b)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,97 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+
+	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+)
+
+// enforceQuotas flags the resource when binding it to a GCS bucket exceeds
+// the bucket quotas of its namespace, the bucket is not applied in that case.
+// Resources already bound are only stopped when their storage class is not
+// allowed, the number of buckets was checked when they were bound.
+func (r *BucketReconciler) enforceQuotas(ctx context.Context, b *storagev1.Bucket) (StopReconciler, error) {
+	var (
+		violations []string
+		err        error
+	)
+
+	if b.Status.GCSBucketRef == "" {
+		violations, err = storagev1.EvaluateQuotas(ctx, r, b, true)
+	} else {
+		violations, err = storagev1.EvaluateStorageClassQuotas(ctx, r, b)
+	}
+
+	if err != nil {
+		return true, err
+	}
+
+	if len(violations) == 0 {
+		if b.RemoveCondition(storagev1.BucketConditionQuotaExceeded) {
+			return false, r.Update(ctx, b)
+		}
+
+		return false, nil
+	}
+
+	msg := strings.Join(violations, "; ")
+	r.Log.Info(msg)
+
+	if b.SetCondition(storagev1.BucketConditionQuotaExceeded, corev1.ConditionTrue, "QuotaExceeded", msg) {
+		r.Recorder.Event(b, corev1.EventTypeWarning, "QuotaExceeded", msg)
+
+		return true, r.Update(ctx, b)
+	}
+
+	return true, nil
+}
+
+// namespaceBuckets maps an object to a request for every Bucket of its
+// namespace, or of the namespace itself, it's used to evaluate again the
+// buckets when a quota or a namespace changes.
+func (r *BucketReconciler) namespaceBuckets(o handler.MapObject) []ctrl.Request {
+	ns := o.Meta.GetNamespace()
+	if _, ok := o.Object.(*corev1.Namespace); ok {
+		ns = o.Meta.GetName()
+	}
+
+	buckets := &storagev1.BucketList{}
+	if err := r.List(context.Background(), buckets, client.InNamespace(ns)); err != nil {
+		r.Log.Error(err, "unable to list buckets")
+
+		return nil
+	}
+
+	result := make([]ctrl.Request, 0, len(buckets.Items))
+	for _, b := range buckets.Items {
+		result = append(result, ctrl.Request{
+			NamespacedName: types.NamespacedName{Namespace: b.GetNamespace(), Name: b.GetName()},
+		})
+	}
+
+	return result
+}
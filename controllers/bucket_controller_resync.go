@@ -0,0 +1,161 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+)
+
+// resyncJitter spreads the resyncs of the buckets reconciled together, up
+// to 10% of the interval is added.
+const resyncJitter = 0.1
+
+// resyncAfter returns when the GCS bucket of the resource must be checked
+// again for changes made outside of the operator, zero if never.
+func (r *BucketReconciler) resyncAfter(b *storagev1.Bucket) time.Duration {
+	d := r.ResyncInterval
+	if b.Spec.ResyncInterval != nil {
+		d = b.Spec.ResyncInterval.Duration
+	}
+
+	if d <= 0 {
+		return 0
+	}
+
+	return wait.Jitter(d, resyncJitter)
+}
+
+// bucketLost applies the recreate policy when the GCS bucket created or
+// adopted by the resource disappeared, it returns true when the bucket must
+// be created again.
+func (r *BucketReconciler) bucketLost(ctx context.Context, b *storagev1.Bucket) (bool, error) {
+	if b.Spec.RecreatePolicy == storagev1.RecreatePolicyMarkLost {
+		msg := fmt.Sprintf("gcs bucket %s not found, it was deleted outside of the operator", b.Spec.Name)
+		r.Log.Info(msg)
+
+		if !b.SetCondition(storagev1.BucketConditionLost, corev1.ConditionTrue, "NotFound", msg) {
+			return false, nil
+		}
+
+		r.Recorder.Event(b, corev1.EventTypeWarning, "Lost", msg)
+
+		return false, r.Update(ctx, b)
+	}
+
+	msg := fmt.Sprintf("gcs bucket %s not found, it was deleted outside of the operator, recreating", b.Spec.Name)
+	r.Log.Info(msg)
+	r.Recorder.Event(b, corev1.EventTypeWarning, "Recreating", msg)
+
+	return true, nil
+}
+
+// syncAttributes corrects the changes made outside of the operator to the
+// attributes of the owned GCS bucket.
+func (r *BucketReconciler) syncAttributes(ctx context.Context, b *storagev1.Bucket, be backend.BucketBackend, a *backend.BucketAttrs) error {
+	changed := b.RemoveCondition(storagev1.BucketConditionLost)
+
+	if b.Status.GCSBucketRef == "" {
+		b.Status.GCSBucketRef = b.Spec.Name
+		changed = true
+	}
+
+	uattrs, drift := r.attributesDrift(b, a)
+	if len(drift) > 0 {
+		uattrs.MetagenerationMatch = a.Metageneration
+
+		if _, err := be.Update(ctx, b.Spec.Name, uattrs); err != nil {
+			r.Log.Error(err, fmt.Sprintf("unable to correct the drift of gcs bucket %s", b.Spec.Name))
+
+			return err
+		}
+
+		for _, attr := range drift {
+			driftCorrectionsTotal.WithLabelValues(attr).Inc()
+		}
+
+		msg := fmt.Sprintf("drift of gcs bucket %s corrected: %s", b.Spec.Name, strings.Join(drift, ", "))
+		r.Log.Info(msg)
+		r.Recorder.Event(b, corev1.EventTypeNormal, "DriftCorrected", msg)
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return r.Update(ctx, b)
+}
+
+// attributesDrift returns the update applying the mutable attributes of the
+// spec to the bucket and the names of the attributes changed. Missing
+// labels are set but labels not defined by the spec are kept, they can't be
+// told apart from labels set by someone else.
+func (r *BucketReconciler) attributesDrift(b *storagev1.Bucket, a *backend.BucketAttrs) (backend.BucketAttrsToUpdate, []string) {
+	var (
+		uattrs backend.BucketAttrsToUpdate
+		drift  []string
+	)
+
+	for k, v := range b.BucketLabels(r.ClusterID) {
+		if current, ok := a.Labels[k]; !ok || current != v {
+			uattrs.SetLabel(k, v)
+		}
+	}
+
+	if len(uattrs.SetLabels) > 0 {
+		drift = append(drift, "labels")
+	}
+
+	// the backend ignores some attributes, they would never match
+	if b.GetCondition(storagev1.BucketConditionUnsupported) != nil {
+		return uattrs, drift
+	}
+
+	if ubla := b.Spec.UniformBucketLevelAccess; ubla != nil && *ubla != a.UniformBucketLevelAccess {
+		uattrs.UniformBucketLevelAccess = ubla
+		drift = append(drift, "uniform bucket-level access")
+	}
+
+	if rp := b.Spec.RetentionPeriod; rp != nil && a.RetentionPeriod != rp.Duration {
+		uattrs.RetentionPeriod = &rp.Duration
+		drift = append(drift, "retention period")
+	}
+
+	if v := b.Spec.Versioning; v != nil && *v != a.VersioningEnabled {
+		uattrs.VersioningEnabled = v
+		drift = append(drift, "versioning")
+	}
+
+	// lifecycle rules can't be removed from a GCS bucket, an empty list
+	// leaves them unmanaged
+	if rules := lifecycleRules(b.Spec.Lifecycle); len(rules) > 0 && !reflect.DeepEqual(rules, a.Lifecycle) {
+		uattrs.Lifecycle = &rules
+		drift = append(drift, "lifecycle")
+	}
+
+	return uattrs, drift
+}
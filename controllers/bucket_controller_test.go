@@ -0,0 +1,1089 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+	"github.com/yriveiro/gcs-bucket-operator/internal/audit"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+	fakebackend "github.com/yriveiro/gcs-bucket-operator/internal/backend/fake"
+	"github.com/yriveiro/gcs-bucket-operator/internal/gcp"
+	"github.com/yriveiro/gcs-bucket-operator/internal/poller"
+	"github.com/yriveiro/gcs-bucket-operator/internal/throttle"
+	"github.com/yriveiro/gcs-bucket-operator/internal/tracing"
+	"github.com/yriveiro/gcs-bucket-operator/internal/tracing/tracingtest"
+)
+
+const testClusterID = "test-cluster"
+
+func newTestReconciler(t *testing.T, be backend.BucketBackend, objs ...runtime.Object) *BucketReconciler {
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storagev1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+
+	objs = append(objs, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+
+	return &BucketReconciler{
+		Client:    fake.NewFakeClientWithScheme(s, objs...),
+		Backend:   be,
+		Log:       logf.NullLogger{},
+		Scheme:    s,
+		Recorder:  record.NewFakeRecorder(100),
+		ClusterID: testClusterID,
+	}
+}
+
+func newTestBucket(name string) *storagev1.Bucket {
+	return &storagev1.Bucket{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Namespace:  "default",
+			UID:        types.UID(name + "-uid"),
+			Finalizers: []string{storagev1.BucketFinalizerName},
+		},
+		Spec: storagev1.BucketSpec{
+			Name:         name,
+			Project:      "my-project",
+			Location:     "EU",
+			StorageClass: "STANDARD",
+		},
+	}
+}
+
+// reconcile runs a reconcile of the bucket and returns the resource after it.
+func reconcile(t *testing.T, r *BucketReconciler, name string) *storagev1.Bucket {
+	key := types.NamespacedName{Namespace: "default", Name: name}
+
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("Reconcile(%s): %v", name, err)
+	}
+
+	b := &storagev1.Bucket{}
+	if err := r.Get(context.Background(), key, b); err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// hasEvent drains the events recorded and returns true if one has the reason.
+func hasEvent(recorder *record.FakeRecorder, reason string) bool {
+	found := false
+
+	for {
+		select {
+		case e := <-recorder.Events:
+			if strings.Contains(e, " "+reason+" ") {
+				found = true
+			}
+		default:
+			return found
+		}
+	}
+}
+
+func TestReconcileCreatesBucket(t *testing.T) {
+	be := fakebackend.New()
+	r := newTestReconciler(t, be, newTestBucket("assets"))
+
+	b := reconcile(t, r, "assets")
+
+	if b.Status.GCSBucketRef != "assets" {
+		t.Errorf("GCSBucketRef = %q, want assets", b.Status.GCSBucketRef)
+	}
+
+	a, err := be.Get(context.Background(), "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !b.Owned(a.Labels, testClusterID) {
+		t.Errorf("bucket labels %v don't mark the resource as owner", a.Labels)
+	}
+
+	if p := be.Project("assets"); p != "my-project" {
+		t.Errorf("bucket project = %q, want my-project", p)
+	}
+}
+
+func TestReconcileAdoptsBucket(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+
+	if err := be.Create(ctx, "my-project", &backend.BucketAttrs{Name: "legacy", Location: "US"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newTestBucket("legacy")
+	b.Spec.AdoptionPolicy = storagev1.AdoptionPolicyIfUnowned
+
+	r := newTestReconciler(t, be, b)
+
+	b = reconcile(t, r, "legacy")
+
+	if b.Status.GCSBucketRef != "legacy" {
+		t.Errorf("GCSBucketRef = %q, want legacy", b.Status.GCSBucketRef)
+	}
+
+	if b.Status.ImportedAttributes == nil || b.Status.ImportedAttributes.Location != "US" {
+		t.Errorf("ImportedAttributes = %+v, want location US", b.Status.ImportedAttributes)
+	}
+
+	a, _ := be.Get(ctx, "legacy")
+	if !b.Owned(a.Labels, testClusterID) {
+		t.Errorf("bucket labels %v don't mark the resource as owner", a.Labels)
+	}
+}
+
+func TestReconcileMigratesLegacyOwnership(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+
+	attrs := &backend.BucketAttrs{
+		Name:   "assets",
+		Labels: map[string]string{storagev1.BucketOwnerLabel: "assets"},
+	}
+	if err := be.Create(ctx, "my-project", attrs); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newTestBucket("assets")
+	b.Status.GCSBucketRef = "assets"
+
+	r := newTestReconciler(t, be, b)
+
+	b = reconcile(t, r, "assets")
+
+	if len(b.Status.Conditions) != 0 {
+		t.Errorf("expected the legacy owned bucket not to be treated as foreign, got %+v", b.Status.Conditions)
+	}
+
+	if !hasEvent(r.Recorder.(*record.FakeRecorder), "OwnershipMigrated") {
+		t.Error("expected an OwnershipMigrated event")
+	}
+
+	a, _ := be.Get(ctx, "assets")
+	if !b.Owned(a.Labels, testClusterID) {
+		t.Errorf("bucket labels %v don't mark the resource as owner", a.Labels)
+	}
+
+	if a.Labels[storagev1.BucketClusterLabel] != storagev1.ClusterLabelValue(testClusterID) {
+		t.Errorf("bucket labels %v don't mark the cluster", a.Labels)
+	}
+}
+
+func TestReconcileReportsAdoptionRefused(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+
+	attrs := &backend.BucketAttrs{
+		Name:   "taken",
+		Labels: map[string]string{storagev1.BucketOwnerLabel: "someone-else"},
+	}
+	if err := be.Create(ctx, "my-project", attrs); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newTestBucket("taken")
+	b.Spec.AdoptionPolicy = storagev1.AdoptionPolicyIfUnowned
+
+	r := newTestReconciler(t, be, b)
+
+	b = reconcile(t, r, "taken")
+
+	if c := b.GetCondition(storagev1.BucketConditionAdoptionRefused); c == nil || c.Status != corev1.ConditionTrue {
+		t.Errorf("expected the AdoptionRefused condition, got %+v", b.Status.Conditions)
+	}
+
+	if !hasEvent(r.Recorder.(*record.FakeRecorder), "AdoptionRefused") {
+		t.Error("expected an AdoptionRefused event")
+	}
+
+	if b.Status.GCSBucketRef != "" {
+		t.Errorf("GCSBucketRef = %q, want empty", b.Status.GCSBucketRef)
+	}
+
+	// the adoption is allowed once the policy changes
+	b.Spec.AdoptionPolicy = storagev1.AdoptionPolicyForce
+	if err := r.Update(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+
+	if b = reconcile(t, r, "taken"); b.GetCondition(storagev1.BucketConditionAdoptionRefused) != nil {
+		t.Errorf("expected the AdoptionRefused condition to be removed, got %+v", b.Status.Conditions)
+	}
+}
+
+func TestReconcileLabelsCluster(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+
+	r := newTestReconciler(t, be, newTestBucket("assets"))
+	r.ClusterID = "Prod.EU"
+
+	reconcile(t, r, "assets")
+
+	a, err := be.Get(ctx, "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := a.Labels[storagev1.BucketClusterLabel]; v != storagev1.ClusterLabelValue("Prod.EU") {
+		t.Errorf("cluster label = %q, want %q", v, storagev1.ClusterLabelValue("Prod.EU"))
+	}
+
+	// the same resource in another cluster doesn't take the bucket over
+	other := newTestReconciler(t, be, newTestBucket("assets"))
+	other.ClusterID = "Prod.US"
+
+	if b := reconcile(t, other, "assets"); b.GetCondition(storagev1.BucketConditionForeignOwner) == nil {
+		t.Errorf("expected the ForeignOwner condition in the other cluster, got %+v", b.Status.Conditions)
+	}
+}
+
+func TestReconcileReportsForeignOwner(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+
+	attrs := &backend.BucketAttrs{
+		Name:   "shared",
+		Labels: map[string]string{storagev1.BucketClusterLabel: "other-cluster"},
+	}
+	if err := be.Create(ctx, "my-project", attrs); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestReconciler(t, be, newTestBucket("shared"))
+
+	b := reconcile(t, r, "shared")
+
+	if c := b.GetCondition(storagev1.BucketConditionForeignOwner); c == nil || c.Status != corev1.ConditionTrue {
+		t.Errorf("expected the ForeignOwner condition, got %+v", b.Status.Conditions)
+	}
+
+	if b.Status.GCSBucketRef != "" {
+		t.Errorf("GCSBucketRef = %q, want empty", b.Status.GCSBucketRef)
+	}
+}
+
+func TestReconcileDeletesBucket(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+
+	b := newTestBucket("tmp")
+	b.Spec.RemoveOnDelete = true
+	b.Status.GCSBucketRef = "tmp"
+
+	now := metav1.Now()
+	b.DeletionTimestamp = &now
+
+	if err := be.Create(ctx, "my-project", &backend.BucketAttrs{Name: "tmp", Labels: b.BucketLabels(testClusterID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestReconciler(t, be, b)
+
+	b = reconcile(t, r, "tmp")
+
+	if b.HasFinalizer(storagev1.BucketFinalizerName) {
+		t.Error("expected the finalizer to be removed")
+	}
+
+	if _, err := be.Get(ctx, "tmp"); err != backend.ErrBucketNotExist {
+		t.Errorf("expected the bucket to be deleted, got %v", err)
+	}
+}
+
+// s3LikeBackend rejects the storage classes other than STANDARD, like the
+// s3 backend.
+type s3LikeBackend struct {
+	*fakebackend.Backend
+}
+
+func (s3LikeBackend) Unsupported(attrs *backend.BucketAttrs) []string {
+	if attrs.StorageClass != "STANDARD" {
+		return []string{"storage class " + attrs.StorageClass}
+	}
+
+	return nil
+}
+
+func TestReconcileReportsUnsupported(t *testing.T) {
+	be := s3LikeBackend{fakebackend.New()}
+
+	b := newTestBucket("cold")
+	b.Spec.StorageClass = "COLDLINE"
+	versioning := true
+	b.Spec.Versioning = &versioning
+
+	r := newTestReconciler(t, be, b)
+
+	b = reconcile(t, r, "cold")
+
+	if c := b.GetCondition(storagev1.BucketConditionUnsupported); c == nil || c.Status != corev1.ConditionTrue {
+		t.Errorf("expected the Unsupported condition, got %+v", b.Status.Conditions)
+	}
+
+	a, err := be.Get(context.Background(), "cold")
+	if err != nil {
+		t.Fatalf("expected the bucket to be created: %v", err)
+	}
+
+	if !a.VersioningEnabled {
+		t.Error("expected versioning to be enabled")
+	}
+
+	b.Spec.StorageClass = "STANDARD"
+	if err := r.Update(context.Background(), b); err != nil {
+		t.Fatal(err)
+	}
+
+	b = reconcile(t, r, "cold")
+
+	if c := b.GetCondition(storagev1.BucketConditionUnsupported); c != nil {
+		t.Errorf("expected the Unsupported condition to be removed, got %+v", c)
+	}
+}
+
+func TestReconcileClassifiesBackendErrors(t *testing.T) {
+	tests := []struct {
+		err     *backend.Error
+		requeue bool
+		fails   bool
+	}{
+		{&backend.Error{Reason: backend.ReasonNameUnavailable, Err: errors.New("conflict")}, false, false},
+		{&backend.Error{Reason: backend.ReasonPreconditionFailed, Err: errors.New("precondition")}, true, false},
+		{&backend.Error{Reason: backend.ReasonPermissionDenied, Permission: "storage.buckets.create", Err: errors.New("denied")}, false, true},
+		{&backend.Error{Reason: backend.ReasonUnavailable, Err: errors.New("unavailable")}, false, true},
+	}
+
+	for _, tt := range tests {
+		be := fakebackend.New()
+		be.SetError("Create", tt.err)
+
+		r := newTestReconciler(t, be, newTestBucket("taken"))
+		key := types.NamespacedName{Namespace: "default", Name: "taken"}
+
+		result, err := r.Reconcile(ctrl.Request{NamespacedName: key})
+		if (err != nil) != tt.fails || result.Requeue != tt.requeue {
+			t.Errorf("%s: Reconcile() = %+v, %v, want requeue %v and failure %v", tt.err.Reason, result, err, tt.requeue, tt.fails)
+		}
+
+		b := &storagev1.Bucket{}
+		if err := r.Get(context.Background(), key, b); err != nil {
+			t.Fatal(err)
+		}
+
+		c := b.GetCondition(storagev1.BucketConditionBackendError)
+		if c == nil || c.Reason != string(tt.err.Reason) {
+			t.Errorf("%s: unexpected BackendError condition %+v", tt.err.Reason, c)
+
+			continue
+		}
+
+		if tt.err.Permission != "" && !strings.Contains(c.Message, tt.err.Permission) {
+			t.Errorf("%s: condition message %q doesn't report the permission", tt.err.Reason, c.Message)
+		}
+
+		be.SetError("Create", nil)
+
+		if b = reconcile(t, r, "taken"); b.GetCondition(storagev1.BucketConditionBackendError) != nil {
+			t.Errorf("%s: expected the BackendError condition to be removed", tt.err.Reason)
+		}
+	}
+}
+
+func TestReconcileThrottlesCreation(t *testing.T) {
+	be := fakebackend.New()
+	r := newTestReconciler(t, be, newTestBucket("first"), newTestBucket("second"))
+	r.Limiter = throttle.New(0.5, 1)
+
+	reconcile(t, r, "first")
+
+	key := types.NamespacedName{Namespace: "default", Name: "second"}
+
+	result, err := r.Reconcile(ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.RequeueAfter <= 0 || result.RequeueAfter > 2*time.Second {
+		t.Errorf("RequeueAfter = %s, want up to 2s", result.RequeueAfter)
+	}
+
+	if _, err := be.Get(context.Background(), "second"); err != backend.ErrBucketNotExist {
+		t.Errorf("expected the creation to be delayed, got %v", err)
+	}
+
+	time.Sleep(result.RequeueAfter)
+
+	if b := reconcile(t, r, "second"); b.Status.GCSBucketRef != "second" {
+		t.Errorf("GCSBucketRef = %q, want second", b.Status.GCSBucketRef)
+	}
+}
+
+func TestHandleFinalizerForgetsThrottledCreation(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBucket("second")
+
+	r := newTestReconciler(t, fakebackend.New(), newTestBucket("first"), b)
+	r.Limiter = throttle.New(0.5, 1)
+
+	reconcile(t, r, "first")
+
+	if err := r.throttle(b, "create"); err == nil {
+		t.Fatal("expected the creation to be throttled")
+	}
+
+	now := metav1.Now()
+	b.SetDeletionTimestamp(&now)
+
+	if err := r.handleFinalizer(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+
+	// the reserved token was dropped, a new one is reserved after it
+	var e *throttledError
+	if err := r.throttle(b, "create"); !errors.As(err, &e) || e.delay <= 3*time.Second {
+		t.Errorf("throttle() = %v, want a new reservation after the forgotten one", err)
+	}
+}
+
+func TestReconcileStopsDuringOutage(t *testing.T) {
+	be := fakebackend.New()
+	be.SetError("Get", &backend.Error{Reason: backend.ReasonUnavailable, Err: errors.New("unavailable")})
+
+	r := newTestReconciler(t, be, newTestBucket("assets"))
+	r.Breaker = backend.NewBreaker(1, 100*time.Millisecond)
+
+	key := types.NamespacedName{Namespace: "default", Name: "assets"}
+
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: key}); err == nil {
+		t.Fatal("expected the reconcile to fail while the backend is unavailable")
+	}
+
+	result, err := r.Reconcile(ctrl.Request{NamespacedName: key})
+	if err != nil || result.RequeueAfter <= 0 {
+		t.Fatalf("Reconcile() with the breaker open = %+v, %v, want requeue after the cooldown", result, err)
+	}
+
+	b := &storagev1.Bucket{}
+	if err := r.Get(context.Background(), key, b); err != nil {
+		t.Fatal(err)
+	}
+
+	if c := b.GetCondition(storagev1.BucketConditionBackendUnavailable); c == nil || c.Reason != "CircuitOpen" {
+		t.Errorf("unexpected BackendUnavailable condition %+v", c)
+	}
+
+	be.SetError("Get", nil)
+	time.Sleep(result.RequeueAfter)
+
+	b = reconcile(t, r, "assets")
+
+	if b.GetCondition(storagev1.BucketConditionBackendUnavailable) != nil || b.GetCondition(storagev1.BucketConditionBackendError) != nil {
+		t.Errorf("expected the backend conditions to be removed, got %+v", b.Status.Conditions)
+	}
+}
+
+func TestReconcileCorrectsDrift(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+
+	bucket := newTestBucket("assets")
+	bucket.Spec.Labels = map[string]string{"team": "storage"}
+	bucket.Spec.ResyncInterval = &metav1.Duration{Duration: time.Minute}
+
+	r := newTestReconciler(t, be, bucket)
+	key := types.NamespacedName{Namespace: "default", Name: "assets"}
+
+	reconcile(t, r, "assets")
+
+	result, err := r.Reconcile(ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.RequeueAfter < time.Minute || result.RequeueAfter > time.Minute+6*time.Second {
+		t.Errorf("RequeueAfter = %s, want the resync interval with jitter", result.RequeueAfter)
+	}
+
+	enabled := true
+	uattrs := backend.BucketAttrsToUpdate{VersioningEnabled: &enabled}
+	uattrs.DeleteLabel("team")
+
+	if _, err := be.Update(ctx, "assets", uattrs); err != nil {
+		t.Fatal(err)
+	}
+
+	bucket = reconcile(t, r, "assets")
+	bucket.Spec.Versioning = new(bool)
+
+	if err := r.Update(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	reconcile(t, r, "assets")
+
+	a, err := be.Get(ctx, "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Labels["team"] != "storage" || a.VersioningEnabled {
+		t.Errorf("attributes after the resync = %+v, want the drift corrected", a)
+	}
+}
+
+func TestReconcileLostBucket(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+
+	bucket := newTestBucket("assets")
+	bucket.Spec.RecreatePolicy = storagev1.RecreatePolicyMarkLost
+
+	r := newTestReconciler(t, be, bucket)
+
+	reconcile(t, r, "assets")
+
+	if err := be.Delete(ctx, "assets"); err != nil {
+		t.Fatal(err)
+	}
+
+	bucket = reconcile(t, r, "assets")
+
+	if c := bucket.GetCondition(storagev1.BucketConditionLost); c == nil || c.Status != corev1.ConditionTrue {
+		t.Errorf("unexpected Lost condition %+v", c)
+	}
+
+	if _, err := be.Get(ctx, "assets"); err != backend.ErrBucketNotExist {
+		t.Errorf("expected the lost bucket not to be recreated, got %v", err)
+	}
+
+	bucket.Spec.RecreatePolicy = storagev1.RecreatePolicyRecreate
+
+	if err := r.Update(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	if bucket = reconcile(t, r, "assets"); bucket.GetCondition(storagev1.BucketConditionLost) != nil {
+		t.Errorf("expected the Lost condition to be removed")
+	}
+
+	if _, err := be.Get(ctx, "assets"); err != nil {
+		t.Errorf("expected the bucket to be recreated, got %v", err)
+	}
+}
+
+func TestReconcileUsesPoller(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+
+	r := newTestReconciler(t, be, newTestBucket("assets"))
+	r.Poller = poller.New(be, time.Minute, logf.NullLogger{})
+	r.Poller.Projects = r.polledProjects
+	r.Poller.Changed = r.enqueueGCSBucket
+	r.events = make(chan event.GenericEvent, 1)
+
+	reconcile(t, r, "assets")
+
+	if err := r.Poller.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	be.SetError("Get", errors.New("unexpected read"))
+	reconcile(t, r, "assets")
+	be.SetError("Get", nil)
+
+	uattrs := backend.BucketAttrsToUpdate{}
+	uattrs.DeleteLabel(storagev1.BucketOwnerLabel)
+
+	if _, err := be.Update(ctx, "assets", uattrs); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Poller.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case e := <-r.events:
+		if e.Meta.GetName() != "assets" {
+			t.Errorf("reconcile triggered for %s, want assets", e.Meta.GetName())
+		}
+	default:
+		t.Fatal("expected a reconcile of the bucket changed outside of the operator")
+	}
+}
+
+func TestEnqueueGCSBucketDoesNotBlock(t *testing.T) {
+	r := newTestReconciler(t, fakebackend.New(), newTestBucket("assets"))
+	r.events = make(chan event.GenericEvent, 1)
+
+	done := make(chan struct{})
+
+	go func() {
+		r.enqueueGCSBucket("assets")
+		r.enqueueGCSBucket("assets")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("enqueueGCSBucket blocked on the full events channel")
+	}
+
+	if len(r.events) != 1 {
+		t.Errorf("expected one queued event, got %d", len(r.events))
+	}
+}
+
+func TestReconcileAuditEvent(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+
+	r := newTestReconciler(t, be, newTestBucket("assets"))
+	r.Poller = poller.New(be, time.Minute, logf.NullLogger{})
+	r.Poller.Projects = r.polledProjects
+	r.Poller.Changed = r.enqueueGCSBucket
+	r.events = make(chan event.GenericEvent, 1)
+
+	reconcile(t, r, "assets")
+
+	if err := r.Poller.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := be.Delete(ctx, "assets"); err != nil {
+		t.Fatal(err)
+	}
+
+	r.auditEvent(audit.Event{Bucket: "assets", Method: audit.MethodDelete})
+
+	select {
+	case e := <-r.events:
+		if e.Meta.GetName() != "assets" {
+			t.Errorf("reconcile triggered for %s, want assets", e.Meta.GetName())
+		}
+	default:
+		t.Fatal("expected a reconcile of the bucket of the audit log")
+	}
+
+	reconcile(t, r, "assets")
+
+	if _, err := be.Get(ctx, "assets"); err != nil {
+		t.Errorf("expected the deleted bucket to be recreated, got %v", err)
+	}
+}
+
+func TestReconcileReportsLimitExceeded(t *testing.T) {
+	ctx := context.Background()
+
+	// the s3 backend doesn't support IAM policies, the limits must not
+	// read them
+	be := fakebackend.New()
+	be.SetError("IAMPolicy", backend.ErrNotSupported)
+	be.SetError("SetIAMPolicy", backend.ErrNotSupported)
+
+	maxObjects := int64(1)
+
+	bucket := newTestBucket("assets")
+	bucket.Spec.Limits = &storagev1.BucketLimits{MaxObjects: &maxObjects}
+
+	r := newTestReconciler(t, be, bucket)
+	recorder := r.Recorder.(*record.FakeRecorder)
+
+	bucket = reconcile(t, r, "assets")
+	bucket.Status.Usage = &storagev1.BucketUsage{Objects: 2}
+
+	if err := r.Update(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	bucket = reconcile(t, r, "assets")
+
+	if c := bucket.GetCondition(storagev1.BucketConditionLimitExceeded); c == nil || c.Status != corev1.ConditionTrue {
+		t.Errorf("unexpected LimitExceeded condition %+v", c)
+	}
+
+	if !hasEvent(recorder, "LimitExceeded") {
+		t.Error("expected a LimitExceeded event")
+	}
+
+	bucket.Status.Usage = &storagev1.BucketUsage{Objects: 1}
+
+	if err := r.Update(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	if bucket = reconcile(t, r, "assets"); bucket.GetCondition(storagev1.BucketConditionLimitExceeded) != nil {
+		t.Errorf("expected the LimitExceeded condition to be removed")
+	}
+}
+
+func TestReconcileEnforcesLimitsOnManagedWriters(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+
+	maxObjects := int64(1)
+
+	bucket := newTestBucket("assets")
+	bucket.Spec.Limits = &storagev1.BucketLimits{
+		MaxObjects:  &maxObjects,
+		Enforcement: storagev1.LimitEnforcementRemoveWriteGrants,
+	}
+
+	r := newTestReconciler(t, be, bucket)
+	recorder := r.Recorder.(*record.FakeRecorder)
+
+	// the grants of people are set up before the writers are added
+	bucket = reconcile(t, r, "assets")
+
+	people := map[string][]string{
+		writeRole:                    {"user:owner@example.com", "user:person@example.com"},
+		"roles/storage.objectViewer": {"user:reader@example.com"},
+	}
+
+	if err := be.SetIAMPolicy(ctx, "assets", &backend.IAMPolicy{Bindings: people}); err != nil {
+		t.Fatal(err)
+	}
+
+	bucket.Spec.Writers = []string{"serviceAccount:app@example.com", "user:owner@example.com"}
+
+	if err := r.Update(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	bucket = reconcile(t, r, "assets")
+
+	if want := []string{"serviceAccount:app@example.com"}; !reflect.DeepEqual(bucket.Status.ManagedWriters, want) {
+		t.Errorf("managed writers = %v, want %v", bucket.Status.ManagedWriters, want)
+	}
+
+	policy, err := be.IAMPolicy(ctx, "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	granted := []string{"serviceAccount:app@example.com", "user:owner@example.com", "user:person@example.com"}
+	if !reflect.DeepEqual(policy.Bindings[writeRole], granted) {
+		t.Errorf("writers = %v, want %v", policy.Bindings[writeRole], granted)
+	}
+
+	bucket.Status.Usage = &storagev1.BucketUsage{Objects: 2}
+
+	if err := r.Update(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	bucket = reconcile(t, r, "assets")
+
+	if len(bucket.Status.ManagedWriters) != 0 {
+		t.Errorf("managed writers left while the limits are exceeded: %v", bucket.Status.ManagedWriters)
+	}
+
+	if !hasEvent(recorder, "WriteGrantsRemoved") {
+		t.Error("expected a WriteGrantsRemoved event")
+	}
+
+	if policy, err = be.IAMPolicy(ctx, "assets"); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the grant made by the operator is revoked
+	if !reflect.DeepEqual(policy.Bindings, people) {
+		t.Errorf("bindings while the limits are exceeded = %v, want %v", policy.Bindings, people)
+	}
+
+	bucket.Status.Usage = &storagev1.BucketUsage{Objects: 1}
+
+	if err := r.Update(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	bucket = reconcile(t, r, "assets")
+
+	if want := []string{"serviceAccount:app@example.com"}; !reflect.DeepEqual(bucket.Status.ManagedWriters, want) {
+		t.Errorf("managed writers after the usage dropped = %v, want %v", bucket.Status.ManagedWriters, want)
+	}
+
+	if policy, err = be.IAMPolicy(ctx, "assets"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(policy.Bindings[writeRole], granted) {
+		t.Errorf("writers after the usage dropped = %v, want %v", policy.Bindings[writeRole], granted)
+	}
+
+	// removing a writer from the spec never revokes a grant made by people
+	bucket.Spec.Writers = nil
+
+	if err := r.Update(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	reconcile(t, r, "assets")
+
+	if policy, err = be.IAMPolicy(ctx, "assets"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(policy.Bindings, people) {
+		t.Errorf("bindings without writers = %v, want %v", policy.Bindings, people)
+	}
+}
+
+func TestReconcileTraces(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+
+	c := tracingtest.NewCollector()
+	defer c.Close()
+
+	tmp := newTestBucket("tmp")
+	tmp.Spec.RemoveOnDelete = true
+	tmp.Status.GCSBucketRef = "tmp"
+
+	now := metav1.Now()
+	tmp.DeletionTimestamp = &now
+
+	if err := be.Create(ctx, "my-project", &backend.BucketAttrs{Name: "tmp", Labels: tmp.BucketLabels(testClusterID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestReconciler(t, be, newTestBucket("assets"), tmp)
+	r.Tracer = tracing.New(c.Endpoint(), "test", logf.NullLogger{})
+
+	reconcile(t, r, "assets")
+	reconcile(t, r, "tmp")
+
+	if err := r.Tracer.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := map[string]tracingtest.Span{}
+	for _, s := range c.Spans() {
+		spans[s.Attributes["bucket"]+"/"+s.Name] = s
+	}
+
+	root := spans["assets/Reconcile"]
+	if root.Attributes["namespace"] != "default" || root.Attributes["name"] != "assets" || root.ParentSpanID != "" {
+		t.Errorf("unexpected reconcile span %+v", root)
+	}
+
+	for _, name := range []string{"assets/Get", "assets/Create"} {
+		if s, ok := spans[name]; !ok || s.ParentSpanID != root.SpanID || s.TraceID != root.TraceID {
+			t.Errorf("unexpected %s span %+v of %+v", name, s, root)
+		}
+	}
+
+	finalizer := spans["tmp/HandleFinalizer"]
+	if finalizer.ParentSpanID != spans["tmp/Reconcile"].SpanID {
+		t.Errorf("unexpected finalizer span %+v", finalizer)
+	}
+
+	if s := spans["tmp/Delete"]; s.ParentSpanID != finalizer.SpanID || s.Error != "" {
+		t.Errorf("unexpected delete span %+v", s)
+	}
+}
+
+func TestReconcileStorageClassQuotaOfBoundBucket(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+
+	quota := &storagev1.BucketQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: "default"},
+		Spec:       storagev1.BucketQuotaSpec{AllowedStorageClasses: []string{"STANDARD"}},
+	}
+
+	r := newTestReconciler(t, be, newTestBucket("assets"), quota)
+
+	bucket := reconcile(t, r, "assets")
+	if bucket.Status.GCSBucketRef != "assets" {
+		t.Fatalf("GCSBucketRef = %q, want assets", bucket.Status.GCSBucketRef)
+	}
+
+	bucket.Spec.StorageClass = "COLDLINE"
+
+	if err := r.Update(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	bucket = reconcile(t, r, "assets")
+
+	if c := bucket.GetCondition(storagev1.BucketConditionQuotaExceeded); c == nil || c.Status != corev1.ConditionTrue {
+		t.Errorf("unexpected QuotaExceeded condition %+v", c)
+	}
+
+	bucket.Spec.StorageClass = "STANDARD"
+
+	if err := r.Update(ctx, bucket); err != nil {
+		t.Fatal(err)
+	}
+
+	if bucket = reconcile(t, r, "assets"); bucket.GetCondition(storagev1.BucketConditionQuotaExceeded) != nil {
+		t.Error("expected the QuotaExceeded condition to be removed")
+	}
+}
+
+func TestReconcileQuotaExceeded(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+
+	one := int32(1)
+	quota := &storagev1.BucketQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: "default"},
+		Spec:       storagev1.BucketQuotaSpec{MaxBuckets: &one},
+	}
+
+	r := newTestReconciler(t, be, newTestBucket("assets"), newTestBucket("logs"), quota)
+	recorder := r.Recorder.(*record.FakeRecorder)
+
+	if b := reconcile(t, r, "assets"); b.GetCondition(storagev1.BucketConditionQuotaExceeded) != nil {
+		t.Errorf("unexpected QuotaExceeded condition of the first bucket")
+	}
+
+	b := reconcile(t, r, "logs")
+
+	if c := b.GetCondition(storagev1.BucketConditionQuotaExceeded); c == nil || c.Status != corev1.ConditionTrue {
+		t.Errorf("unexpected QuotaExceeded condition %+v", c)
+	}
+
+	if !hasEvent(recorder, "QuotaExceeded") {
+		t.Error("expected a QuotaExceeded event")
+	}
+
+	if _, err := be.Get(ctx, "logs"); err != backend.ErrBucketNotExist {
+		t.Errorf("expected the bucket over the quota not to be created, got %v", err)
+	}
+
+	// the bound bucket is still reconciled
+	if b := reconcile(t, r, "assets"); b.GetCondition(storagev1.BucketConditionQuotaExceeded) != nil {
+		t.Errorf("unexpected QuotaExceeded condition of the bound bucket")
+	}
+
+	quota.Spec.MaxBuckets = nil
+
+	if err := r.Update(ctx, quota); err != nil {
+		t.Fatal(err)
+	}
+
+	if b = reconcile(t, r, "logs"); b.GetCondition(storagev1.BucketConditionQuotaExceeded) != nil {
+		t.Error("expected the QuotaExceeded condition to be removed")
+	}
+
+	if _, err := be.Get(ctx, "logs"); err != nil {
+		t.Errorf("expected the bucket to be created, got %v", err)
+	}
+}
+
+func TestSecretBuckets(t *testing.T) {
+	pc := &storagev1.ProviderConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "tenant", Namespace: "default"},
+		Spec: storagev1.ProviderConfigSpec{
+			SecretRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "tenant-credentials"},
+				Key:                  "key.json",
+			},
+		},
+	}
+
+	bound := newTestBucket("bound")
+	bound.Spec.ProviderConfigRef = &corev1.LocalObjectReference{Name: "tenant"}
+
+	r := newTestReconciler(t, fakebackend.New(), pc, bound, newTestBucket("other"))
+
+	secret := func(name string) handler.MapObject {
+		s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+
+		return handler.MapObject{Meta: s, Object: s}
+	}
+
+	got := r.secretBuckets(secret("tenant-credentials"))
+	if len(got) != 1 || got[0].Name != "bound" {
+		t.Errorf("secretBuckets(tenant-credentials) = %v, want the bound bucket", got)
+	}
+
+	if got := r.secretBuckets(secret("unrelated")); len(got) != 0 {
+		t.Errorf("secretBuckets(unrelated) = %v, want none", got)
+	}
+}
+
+func TestProviderConfigImpersonationAcrossTenants(t *testing.T) {
+	ctx := context.Background()
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "tenant-a",
+		Annotations: map[string]string{storagev1.ImpersonateServiceAccountAnnotation: "tenant-a@project.iam.gserviceaccount.com"},
+	}}
+	pc := &storagev1.ProviderConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "borrowed", Namespace: "tenant-a"},
+		Spec:       storagev1.ProviderConfigSpec{ImpersonateServiceAccount: "tenant-b@project.iam.gserviceaccount.com"},
+	}
+
+	b := newTestBucket("assets")
+	b.Namespace = "tenant-a"
+	b.Spec.ProviderConfigRef = &corev1.LocalObjectReference{Name: "borrowed"}
+
+	r := newTestReconciler(t, fakebackend.New(), ns, pc, b)
+	r.Clients = gcp.NewClientCache(option.WithEndpoint("http://127.0.0.1:1/storage/v1/"))
+
+	_, err := r.storageClient(ctx, b)
+
+	var e *backend.Error
+	if !errors.As(err, &e) || e.Reason != backend.ReasonPermissionDenied {
+		t.Fatalf("storageClient() = %v, want PermissionDenied for the service account of another tenant", err)
+	}
+
+	// the administrator allows the service account in the namespace
+	ns.Annotations[storagev1.AllowedServiceAccountsAnnotation] = "tenant-b@project.iam.gserviceaccount.com"
+	if err := r.Update(ctx, ns); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client is created with the operator credentials, missing here
+	if _, err := r.storageClient(ctx, b); errors.As(err, &e) {
+		t.Errorf("storageClient() = %v, want the allowed service account to be impersonated", err)
+	}
+}
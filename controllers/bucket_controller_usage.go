@@ -0,0 +1,140 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+	"github.com/yriveiro/gcs-bucket-operator/internal/usage"
+)
+
+// usageJitter spreads the passes, up to 10% of the interval is added.
+const usageJitter = 0.1
+
+// usageCollector measures the usage of the buckets every UsageInterval of
+// the reconciler, records it in their status and exports it as metrics.
+type usageCollector struct {
+	r *BucketReconciler
+
+	// projects of the buckets whose usage is exported.
+	projects map[types.NamespacedName]string
+}
+
+// Start implements manager.Runnable, it collects until stop is closed.
+func (c *usageCollector) Start(stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	wait.JitterUntil(func() {
+		if err := c.collect(ctx); err != nil {
+			c.r.Log.Error(err, "unable to collect the usage of the buckets")
+		}
+	}, c.r.UsageInterval, usageJitter, true, stop)
+
+	return nil
+}
+
+// collect runs a pass, measuring the buckets one at a time. The metrics of
+// the buckets that fail to be measured are kept as they were.
+func (c *usageCollector) collect(ctx context.Context) error {
+	buckets := &storagev1.BucketList{}
+	if err := c.r.List(ctx, buckets); err != nil {
+		return err
+	}
+
+	projects := map[types.NamespacedName]string{}
+
+	for i := range buckets.Items {
+		b := &buckets.Items[i]
+		if b.IsBeingDeleted() || b.Status.GCSBucketRef == "" {
+			continue
+		}
+
+		key := types.NamespacedName{Namespace: b.GetNamespace(), Name: b.GetName()}
+
+		u, err := c.measure(ctx, b)
+		if err != nil {
+			c.r.Log.Error(err, "unable to measure the usage of the bucket", "bucket", key.String())
+
+			if project, ok := c.projects[key]; ok {
+				projects[key] = project
+			}
+
+			continue
+		}
+
+		bucketObjects.WithLabelValues(key.Namespace, key.Name, b.Spec.Project).Set(float64(u.Objects))
+		bucketBytes.WithLabelValues(key.Namespace, key.Name, b.Spec.Project).Set(float64(u.Bytes))
+		projects[key] = b.Spec.Project
+
+		// the resource is only written when the usage changes, every write
+		// triggers a reconcile of the bucket
+		if cur := b.Status.Usage; cur != nil && cur.Objects == u.Objects && cur.Bytes == u.Bytes && cur.Estimated == u.Estimated {
+			continue
+		}
+
+		b.Status.Usage = &storagev1.BucketUsage{
+			Objects:      u.Objects,
+			Bytes:        u.Bytes,
+			Estimated:    u.Estimated,
+			LastMeasured: metav1.Now(),
+		}
+
+		// a conflict is retried by the next pass
+		if err := c.r.Update(ctx, b); err != nil {
+			c.r.Log.Error(err, "unable to record the usage of the bucket", "bucket", key.String())
+		}
+	}
+
+	for key, project := range c.projects {
+		if projects[key] != project {
+			bucketObjects.DeleteLabelValues(key.Namespace, key.Name, project)
+			bucketBytes.DeleteLabelValues(key.Namespace, key.Name, project)
+		}
+	}
+
+	c.projects = projects
+
+	return nil
+}
+
+// measure returns the usage of the GCS bucket.
+func (c *usageCollector) measure(ctx context.Context, b *storagev1.Bucket) (usage.Usage, error) {
+	be, err := c.r.usageBackend(ctx, b)
+	if err != nil {
+		return usage.Usage{}, err
+	}
+
+	u, err := c.r.Usage.Measure(ctx, be, b.Status.GCSBucketRef)
+	if err != nil {
+		return usage.Usage{}, fmt.Errorf("error when listing the objects: %v", err)
+	}
+
+	return u, nil
+}
@@ -0,0 +1,93 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+)
+
+// BucketPolicyReconciler reconciles a BucketPolicy object
+type BucketPolicyReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// Rules compiles the rules of the policies, the rules are compiled on
+	// every reconcile when nil.
+	Rules storagev1.RuleCompiler
+}
+
+// +kubebuilder:rbac:groups=storage.k8s.riveiro.io,resources=bucketpolicies,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=storage.k8s.riveiro.io,resources=bucketpolicies/status,verbs=get;update;patch
+
+// Reconcile compiles the rules of the policy and reports the errors in its
+// status
+func (r *BucketPolicyReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	l := r.Log.WithValues("bucketpolicy", req.NamespacedName)
+
+	p := &storagev1.BucketPolicy{}
+
+	if err := r.Get(ctx, req.NamespacedName, p); err != nil {
+		if k8serr.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	errs := p.RuleErrors(r.Rules)
+
+	if p.Status.ObservedGeneration == p.GetGeneration() && reflect.DeepEqual(p.Status.RuleErrors, errs) {
+		return ctrl.Result{}, nil
+	}
+
+	for _, e := range errs {
+		l.Info(fmt.Sprintf("invalid rule: %s", e))
+		r.Recorder.Event(p, corev1.EventTypeWarning, "InvalidRule", e)
+	}
+
+	p.Status.ObservedGeneration = p.GetGeneration()
+	p.Status.RuleErrors = errs
+
+	if err := r.Update(ctx, p); err != nil {
+		return ctrl.Result{}, fmt.Errorf("error when updating policy status: %v", err)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager setup the controller with a manager
+func (r *BucketPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&storagev1.BucketPolicy{}).
+		Complete(r)
+}
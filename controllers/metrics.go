@@ -0,0 +1,243 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+)
+
+// metricsNamespace prefixes the metrics of the operator.
+const metricsNamespace = "gcs_bucket_operator"
+
+var (
+	throttleWaitSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "throttle_wait_seconds",
+		Help:      "Time the operations on the storage backend are delayed by the per project rate limiter.",
+		Buckets:   []float64{0.5, 1, 2, 5, 10, 30, 60, 120, 300},
+	}, []string{"operation"})
+
+	backendRequestSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "backend_request_duration_seconds",
+		Help:      "Duration of the operations on the storage backend.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	backendRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "backend_requests_total",
+		Help:      "Operations on the storage backend by result reason.",
+	}, []string{"operation", "reason"})
+
+	driftCorrectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "drift_corrections_total",
+		Help:      "Attributes of the buckets changed outside of the operator and corrected.",
+	}, []string{"attribute"})
+
+	deletionSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "finalizer_deletion_duration_seconds",
+		Help:      "Time from the deletion of a Bucket to the removal of its finalizer.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 300, 900, 3600},
+	})
+
+	ownershipConflictsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "ownership_conflicts_total",
+		Help:      "Operations refused because the bucket is owned by another resource or cluster.",
+	}, []string{"reason"})
+
+	bucketObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "bucket_objects",
+		Help:      "Objects stored in the bucket, measured by the usage collector.",
+	}, []string{"namespace", "name", "project"})
+
+	bucketBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "bucket_bytes",
+		Help:      "Bytes stored in the bucket, measured by the usage collector.",
+	}, []string{"namespace", "name", "project"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		throttleWaitSeconds,
+		backendRequestSeconds,
+		backendRequestsTotal,
+		driftCorrectionsTotal,
+		deletionSeconds,
+		ownershipConflictsTotal,
+		bucketObjects,
+		bucketBytes,
+	)
+}
+
+// observeBackend records an operation on the storage backend, the
+// operations rejected by the circuit breaker are counted without a
+// duration as they never reached the backend.
+func observeBackend(op string, d time.Duration, err error) {
+	reason := resultReason(err)
+	if reason != "CircuitOpen" {
+		backendRequestSeconds.WithLabelValues(op).Observe(d.Seconds())
+	}
+
+	backendRequestsTotal.WithLabelValues(op, reason).Inc()
+}
+
+// resultReason classifies the result of an operation on the storage backend.
+func resultReason(err error) string {
+	var (
+		be *backend.Error
+		ce *backend.CircuitOpenError
+	)
+
+	switch {
+	case err == nil:
+		return "OK"
+	case err == backend.ErrBucketNotExist, err == backend.ErrNotificationNotExist:
+		return "NotFound"
+	case err == backend.ErrNotSupported:
+		return "NotSupported"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "DeadlineExceeded"
+	case errors.As(err, &ce):
+		return "CircuitOpen"
+	case errors.As(err, &be):
+		return string(be.Reason)
+	}
+
+	return "Unknown"
+}
+
+const (
+	phasePending  = "Pending"
+	phaseReady    = "Ready"
+	phaseFailed   = "Failed"
+	phaseLost     = "Lost"
+	phaseDeleting = "Deleting"
+)
+
+// failedConditions are the conditions blocking the reconcile of a bucket.
+var failedConditions = []storagev1.BucketConditionType{
+	storagev1.BucketConditionForeignOwner,
+	storagev1.BucketConditionPolicyViolation,
+	storagev1.BucketConditionProjectMismatch,
+	storagev1.BucketConditionQuotaExceeded,
+	storagev1.BucketConditionBackendError,
+	storagev1.BucketConditionBackendUnavailable,
+}
+
+// bucketPhase summarizes the state of the resource.
+func bucketPhase(b *storagev1.Bucket) string {
+	if b.IsBeingDeleted() {
+		return phaseDeleting
+	}
+
+	if c := b.GetCondition(storagev1.BucketConditionLost); c != nil && c.Status == corev1.ConditionTrue {
+		return phaseLost
+	}
+
+	for _, t := range failedConditions {
+		if c := b.GetCondition(t); c != nil && c.Status == corev1.ConditionTrue {
+			return phaseFailed
+		}
+	}
+
+	if b.Status.GCSBucketRef != "" {
+		return phaseReady
+	}
+
+	return phasePending
+}
+
+// bucketCollector reports the number of buckets by phase and by condition
+// from the cache of the manager on each scrape.
+type bucketCollector struct {
+	client client.Reader
+
+	phases     *prometheus.Desc
+	conditions *prometheus.Desc
+}
+
+func newBucketCollector(c client.Reader) *bucketCollector {
+	return &bucketCollector{
+		client: c,
+		phases: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "buckets"),
+			"Buckets by phase.", []string{"phase"}, nil),
+		conditions: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "bucket_conditions"),
+			"Buckets by condition type and status.", []string{"condition", "status"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *bucketCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.phases
+	ch <- c.conditions
+}
+
+// Collect implements prometheus.Collector
+func (c *bucketCollector) Collect(ch chan<- prometheus.Metric) {
+	buckets := &storagev1.BucketList{}
+	if err := c.client.List(context.Background(), buckets); err != nil {
+		ch <- prometheus.NewInvalidMetric(c.phases, err)
+
+		return
+	}
+
+	phases := map[string]int{
+		phasePending:  0,
+		phaseReady:    0,
+		phaseFailed:   0,
+		phaseLost:     0,
+		phaseDeleting: 0,
+	}
+
+	type condition struct{ t, status string }
+
+	conditions := map[condition]int{}
+
+	for i := range buckets.Items {
+		b := &buckets.Items[i]
+		phases[bucketPhase(b)]++
+
+		for _, cond := range b.Status.Conditions {
+			conditions[condition{string(cond.Type), string(cond.Status)}]++
+		}
+	}
+
+	for phase, n := range phases {
+		ch <- prometheus.MustNewConstMetric(c.phases, prometheus.GaugeValue, float64(n), phase)
+	}
+
+	for cond, n := range conditions {
+		ch <- prometheus.MustNewConstMetric(c.conditions, prometheus.GaugeValue, float64(n), cond.t, cond.status)
+	}
+}
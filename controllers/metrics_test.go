@@ -0,0 +1,205 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+	fakebackend "github.com/yriveiro/gcs-bucket-operator/internal/backend/fake"
+	"github.com/yriveiro/gcs-bucket-operator/internal/usage"
+)
+
+func TestResultReason(t *testing.T) {
+	tests := map[error]string{
+		nil:                         "OK",
+		backend.ErrBucketNotExist:   "NotFound",
+		context.DeadlineExceeded:    "DeadlineExceeded",
+		errors.New("connection"):    "Unknown",
+		&backend.CircuitOpenError{}: "CircuitOpen",
+		fmt.Errorf("wrapped: %w", &backend.Error{Reason: backend.ReasonRateLimited, Err: errors.New("429")}): "RateLimited",
+	}
+
+	for err, want := range tests {
+		if got := resultReason(err); got != want {
+			t.Errorf("resultReason(%v) = %s, want %s", err, got, want)
+		}
+	}
+}
+
+func TestObserveBackendCountsCircuitOpen(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+	be.SetError("Get", &backend.Error{Reason: backend.ReasonUnavailable, Err: errors.New("503")})
+
+	r := newTestReconciler(t, be)
+	r.Breaker = backend.NewBreaker(1, time.Minute)
+
+	rejected := backendRequestsTotal.WithLabelValues("Get", "CircuitOpen")
+	before := testutil.ToFloat64(rejected)
+
+	wrapped := r.wrapBackend(be)
+
+	// the first failure opens the breaker, which rejects the second one
+	for i := 0; i < 2; i++ {
+		if _, err := wrapped.Get(ctx, "assets"); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+
+	if got := testutil.ToFloat64(rejected) - before; got != 1 {
+		t.Errorf("operations rejected by the circuit breaker counted = %v, want 1", got)
+	}
+}
+
+func TestBucketCollector(t *testing.T) {
+	ready := newTestBucket("ready")
+	ready.Status.GCSBucketRef = "ready"
+
+	failed := newTestBucket("failed")
+	failed.SetCondition(storagev1.BucketConditionBackendError, corev1.ConditionTrue, "PermissionDenied", "denied")
+
+	deleting := newTestBucket("deleting")
+	deleting.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+	r := newTestReconciler(t, nil, ready, failed, deleting, newTestBucket("pending"))
+
+	expected := `
+# HELP gcs_bucket_operator_bucket_conditions Buckets by condition type and status.
+# TYPE gcs_bucket_operator_bucket_conditions gauge
+gcs_bucket_operator_bucket_conditions{condition="BackendError",status="True"} 1
+# HELP gcs_bucket_operator_buckets Buckets by phase.
+# TYPE gcs_bucket_operator_buckets gauge
+gcs_bucket_operator_buckets{phase="Deleting"} 1
+gcs_bucket_operator_buckets{phase="Failed"} 1
+gcs_bucket_operator_buckets{phase="Lost"} 0
+gcs_bucket_operator_buckets{phase="Pending"} 1
+gcs_bucket_operator_buckets{phase="Ready"} 1
+`
+
+	if err := testutil.CollectAndCompare(newBucketCollector(r.Client), strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUsageCollector(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+
+	r := newTestReconciler(t, be, newTestBucket("assets"), newTestBucket("pending"))
+	r.Usage = usage.NewMeter(100, 4, usage.DefaultMaxCalls)
+
+	reconcile(t, r, "assets")
+
+	for name, size := range map[string]int64{"a": 10, "b": 20} {
+		if err := be.PutObject("assets", name, size); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := &usageCollector{r: r}
+	if err := c.collect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	bytes := `
+# HELP gcs_bucket_operator_bucket_bytes Bytes stored in the bucket, measured by the usage collector.
+# TYPE gcs_bucket_operator_bucket_bytes gauge
+gcs_bucket_operator_bucket_bytes{name="assets",namespace="default",project="my-project"} 30
+`
+	objects := `
+# HELP gcs_bucket_operator_bucket_objects Objects stored in the bucket, measured by the usage collector.
+# TYPE gcs_bucket_operator_bucket_objects gauge
+gcs_bucket_operator_bucket_objects{name="assets",namespace="default",project="my-project"} 2
+`
+
+	if err := testutil.CollectAndCompare(bucketBytes, strings.NewReader(bytes)); err != nil {
+		t.Error(err)
+	}
+
+	if err := testutil.CollectAndCompare(bucketObjects, strings.NewReader(objects)); err != nil {
+		t.Error(err)
+	}
+
+	b := &storagev1.Bucket{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "assets"}, b); err != nil {
+		t.Fatal(err)
+	}
+
+	if u := b.Status.Usage; u == nil || u.Objects != 2 || u.Bytes != 30 || u.Estimated {
+		t.Errorf("usage = %+v, want 2 objects and 30 bytes", u)
+	}
+
+	if err := c.collect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	unchanged := &storagev1.Bucket{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "assets"}, unchanged); err != nil {
+		t.Fatal(err)
+	}
+
+	if unchanged.GetResourceVersion() != b.GetResourceVersion() {
+		t.Error("expected the bucket not to be updated when the usage didn't change")
+	}
+
+	if err := r.Delete(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.collect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testutil.CollectAndCompare(bucketObjects, strings.NewReader("")); err != nil {
+		t.Errorf("usage metrics left after the deletion of the bucket: %v", err)
+	}
+}
+
+func TestUsageCollectorBypassesBreaker(t *testing.T) {
+	ctx := context.Background()
+	be := fakebackend.New()
+
+	r := newTestReconciler(t, be, newTestBucket("assets"))
+	r.Usage = usage.NewMeter(100, 4, usage.DefaultMaxCalls)
+	r.Breaker = backend.NewBreaker(1, time.Minute)
+
+	reconcile(t, r, "assets")
+
+	be.SetError("Objects", &backend.Error{Reason: backend.ReasonUnavailable, Err: context.DeadlineExceeded})
+
+	c := &usageCollector{r: r}
+	if err := c.collect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Breaker.Open() {
+		t.Error("expected the slow listings of the usage not to open the circuit breaker")
+	}
+}
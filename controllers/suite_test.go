@@ -17,13 +17,16 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
 	"path/filepath"
 	"testing"
 
+	"cloud.google.com/go/storage"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
@@ -31,6 +34,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend/fake"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend/gcs"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend/gcs/gcstest"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -41,6 +47,11 @@ var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
 
+// gcsServer serves the GCS API used by the manager, its backend holds the
+// buckets.
+var gcsServer *gcstest.Server
+var stopManager chan struct{}
+
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
 
@@ -71,11 +82,41 @@ var _ = BeforeSuite(func(done Done) {
 	Expect(err).ToNot(HaveOccurred())
 	Expect(k8sClient).ToNot(BeNil())
 
+	gcsServer = gcstest.NewServer(fake.New())
+
+	storageClient, err := storage.NewClient(context.Background(), gcsServer.ClientOptions()...)
+	Expect(err).ToNot(HaveOccurred())
+
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:             scheme.Scheme,
+		MetricsBindAddress: "0",
+	})
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&BucketReconciler{
+		Client:    mgr.GetClient(),
+		Backend:   gcs.New(storageClient),
+		Log:       ctrl.Log.WithName("controllers").WithName("Bucket"),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("bucket-controller"),
+		ClusterID: testClusterID,
+	}).SetupWithManager(mgr)
+	Expect(err).ToNot(HaveOccurred())
+
+	stopManager = make(chan struct{})
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(stopManager)).To(Succeed())
+	}()
+
 	close(done)
 }, 60)
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
+	close(stopManager)
+	gcsServer.Close()
+
 	err := testEnv.Stop()
 	Expect(err).ToNot(HaveOccurred())
 })
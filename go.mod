@@ -5,10 +5,16 @@ go 1.15
 require (
 	cloud.google.com/go v0.38.0
 	github.com/go-logr/logr v0.1.0
+	github.com/googleapis/gax-go/v2 v2.0.4
+	github.com/minio/minio-go v6.0.14+incompatible
 	github.com/onsi/ginkgo v1.11.0
 	github.com/onsi/gomega v1.8.1
-	github.com/prometheus/client_golang v1.1.0 // indirect
+	github.com/prometheus/client_golang v1.1.0
+	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
 	golang.org/x/sys v0.0.0-20191210023423-ac6580df4449 // indirect
+	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4
+	google.golang.org/api v0.4.0
+	google.golang.org/genproto v0.0.0-20190502173448-54afdca5d873
 	k8s.io/api v0.17.2
 	k8s.io/apimachinery v0.17.2
 	k8s.io/client-go v0.17.2
@@ -0,0 +1,90 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit receives the Cloud Audit Logs of the storage service, from
+// a Pub/Sub subscription of a log sink or from an HTTP webhook, to report
+// the buckets changed outside of the operator.
+package audit
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	// MethodUpdate is the audit log method of a bucket update.
+	MethodUpdate = "storage.buckets.update"
+
+	// MethodDelete is the audit log method of a bucket deletion.
+	MethodDelete = "storage.buckets.delete"
+)
+
+// Event reports an operation on a bucket.
+type Event struct {
+	Bucket string
+	Method string
+}
+
+// Source reports the events of the buckets to the handler set with Notify
+// once started, until stop is closed.
+type Source interface {
+	Notify(handle func(Event))
+	Start(stop <-chan struct{}) error
+}
+
+// logEntry is the subset of a log entry with an audit log used to find the
+// bucket and the operation.
+type logEntry struct {
+	ProtoPayload struct {
+		ServiceName  string `json:"serviceName"`
+		MethodName   string `json:"methodName"`
+		ResourceName string `json:"resourceName"`
+	} `json:"protoPayload"`
+	Resource struct {
+		Labels struct {
+			BucketName string `json:"bucket_name"`
+		} `json:"labels"`
+	} `json:"resource"`
+}
+
+// ParseLogEntry parses a log entry in JSON, it returns nil when the entry is
+// not an audit log of a bucket update or deletion.
+func ParseLogEntry(data []byte) (*Event, error) {
+	var e logEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+
+	p := e.ProtoPayload
+	if p.MethodName != MethodUpdate && p.MethodName != MethodDelete {
+		return nil, nil
+	}
+
+	bucket := e.Resource.Labels.BucketName
+	if bucket == "" {
+		// projects/_/buckets/<name>
+		if i := strings.LastIndex(p.ResourceName, "/buckets/"); i >= 0 {
+			bucket = p.ResourceName[i+len("/buckets/"):]
+		}
+	}
+
+	if bucket == "" {
+		return nil, nil
+	}
+
+	return &Event{Bucket: bucket, Method: p.MethodName}, nil
+}
@@ -0,0 +1,61 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"testing"
+)
+
+const updateEntry = `{
+  "protoPayload": {
+    "@type": "type.googleapis.com/google.cloud.audit.AuditLog",
+    "serviceName": "storage.googleapis.com",
+    "methodName": "storage.buckets.update",
+    "resourceName": "projects/_/buckets/assets"
+  },
+  "resource": {"type": "gcs_bucket", "labels": {"bucket_name": "assets", "project_id": "my-project"}}
+}`
+
+func TestParseLogEntry(t *testing.T) {
+	tests := []struct {
+		entry string
+		want  *Event
+	}{
+		{updateEntry, &Event{Bucket: "assets", Method: MethodUpdate}},
+		{`{"protoPayload": {"methodName": "storage.buckets.delete", "resourceName": "projects/_/buckets/logs"}}`, &Event{Bucket: "logs", Method: MethodDelete}},
+		{`{"protoPayload": {"methodName": "storage.objects.create", "resourceName": "projects/_/buckets/logs/objects/a"}}`, nil},
+		{`{"textPayload": "hello"}`, nil},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLogEntry([]byte(tt.entry))
+		if err != nil {
+			t.Errorf("ParseLogEntry(%s): %v", tt.entry, err)
+
+			continue
+		}
+
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("ParseLogEntry(%s) = %+v, want %+v", tt.entry, got, tt.want)
+		}
+	}
+
+	if _, err := ParseLogEntry([]byte("{")); err == nil {
+		t.Errorf("no error parsing invalid JSON")
+	}
+}
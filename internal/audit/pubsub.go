@@ -0,0 +1,155 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"time"
+
+	"github.com/go-logr/logr"
+	gax "github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/option"
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+// maxMessages is the number of messages pulled at once.
+const maxMessages = 100
+
+// Subscriber pulls the audit logs published by a log sink to a Pub/Sub
+// topic from a subscription of the topic. While the subscription is
+// unavailable the pulls are retried with backoff, the changes are then only
+// detected by the resync of the buckets.
+type Subscriber struct {
+	// Subscription is the full name of the subscription,
+	// projects/<project>/subscriptions/<name>.
+	Subscription string
+	Log          logr.Logger
+
+	service *pubsub.Service
+	handle  func(Event)
+	backoff gax.Backoff
+}
+
+var _ Source = &Subscriber{}
+
+// NewSubscriber returns a subscriber of the subscription.
+func NewSubscriber(ctx context.Context, subscription string, log logr.Logger, opts ...option.ClientOption) (*Subscriber, error) {
+	service, err := pubsub.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Subscriber{
+		Subscription: subscription,
+		Log:          log,
+		service:      service,
+		backoff:      gax.Backoff{Initial: time.Second, Max: 5 * time.Minute},
+	}, nil
+}
+
+// Notify implements Source.
+func (s *Subscriber) Notify(handle func(Event)) {
+	s.handle = handle
+}
+
+// Start implements Source, it pulls until stop is closed.
+func (s *Subscriber) Start(stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	unavailable := false
+
+	for ctx.Err() == nil {
+		err := s.Pull(ctx)
+
+		switch {
+		case ctx.Err() != nil:
+		case err != nil:
+			if !unavailable {
+				s.Log.Error(err, "subscription unavailable, bucket changes are detected by the resync only", "subscription", s.Subscription)
+				unavailable = true
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(s.backoff.Pause()):
+			}
+		case unavailable:
+			s.Log.Info("subscription available again", "subscription", s.Subscription)
+			unavailable = false
+			s.backoff = gax.Backoff{Initial: s.backoff.Initial, Max: s.backoff.Max}
+		}
+	}
+
+	return nil
+}
+
+// Pull pulls a batch of messages, handles their events and acknowledges
+// them. Messages that can't be parsed are acknowledged and dropped.
+func (s *Subscriber) Pull(ctx context.Context) error {
+	resp, err := s.service.Projects.Subscriptions.Pull(s.Subscription, &pubsub.PullRequest{
+		MaxMessages: maxMessages,
+	}).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+
+	if len(resp.ReceivedMessages) == 0 {
+		return nil
+	}
+
+	ackIDs := make([]string, 0, len(resp.ReceivedMessages))
+
+	for _, m := range resp.ReceivedMessages {
+		ackIDs = append(ackIDs, m.AckId)
+
+		if m.Message == nil {
+			continue
+		}
+
+		data, err := base64.StdEncoding.DecodeString(m.Message.Data)
+		if err != nil {
+			s.Log.Error(err, "unable to decode message", "id", m.Message.MessageId)
+
+			continue
+		}
+
+		e, err := ParseLogEntry(data)
+		if err != nil {
+			s.Log.Error(err, "unable to parse log entry", "id", m.Message.MessageId)
+
+			continue
+		}
+
+		if e != nil {
+			s.handle(*e)
+		}
+	}
+
+	_, err = s.service.Projects.Subscriptions.Acknowledge(s.Subscription, &pubsub.AcknowledgeRequest{
+		AckIds: ackIDs,
+	}).Context(ctx).Do()
+
+	return err
+}
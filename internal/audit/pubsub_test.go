@@ -0,0 +1,162 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+	pubsub "google.golang.org/api/pubsub/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/yriveiro/gcs-bucket-operator/internal/gcp"
+)
+
+const testSubscription = "projects/my-project/subscriptions/bucket-events"
+
+func newTestSubscriber(t *testing.T, endpoint string) (*Subscriber, *[]Event) {
+	opts := append(gcp.PubSubClientOptions(endpoint), option.WithoutAuthentication())
+
+	s, err := NewSubscriber(context.Background(), testSubscription, logf.NullLogger{}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []Event
+	s.Notify(func(e Event) { events = append(events, e) })
+
+	return s, &events
+}
+
+func TestSubscriberPull(t *testing.T) {
+	var acked []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/" + testSubscription + ":pull":
+			_ = json.NewEncoder(w).Encode(&pubsub.PullResponse{ReceivedMessages: []*pubsub.ReceivedMessage{
+				{AckId: "1", Message: &pubsub.PubsubMessage{Data: base64.StdEncoding.EncodeToString([]byte(updateEntry))}},
+				{AckId: "2", Message: &pubsub.PubsubMessage{Data: "not base64"}},
+			}})
+		case "/v1/" + testSubscription + ":acknowledge":
+			var req pubsub.AcknowledgeRequest
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			acked = req.AckIds
+			_, _ = w.Write([]byte("{}"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	s, events := newTestSubscriber(t, srv.URL)
+
+	if err := s.Pull(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []Event{{Bucket: "assets", Method: MethodUpdate}}; !reflect.DeepEqual(*events, want) {
+		t.Errorf("events = %+v, want %+v", *events, want)
+	}
+
+	if want := []string{"1", "2"}; !reflect.DeepEqual(acked, want) {
+		t.Errorf("acknowledged messages = %v, want %v", acked, want)
+	}
+}
+
+func TestSubscriberUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": {"code": 503}}`, http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	s, _ := newTestSubscriber(t, srv.URL)
+
+	stop := make(chan struct{})
+	done := make(chan error)
+
+	go func() { done <- s.Start(stop) }()
+
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start() = %v, want nil while the subscription is unavailable", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscriber not stopped")
+	}
+}
+
+// TestEmulator runs against the Pub/Sub emulator of PUBSUB_EMULATOR_HOST:
+//
+//	gcloud beta emulators pubsub start --host-port=localhost:8085
+//	PUBSUB_EMULATOR_HOST=localhost:8085 go test ./internal/audit/
+func TestEmulator(t *testing.T) {
+	if os.Getenv(gcp.PubSubEmulatorHostEnv) == "" {
+		t.Skipf("%s not set", gcp.PubSubEmulatorHostEnv)
+	}
+
+	ctx := context.Background()
+
+	service, err := pubsub.NewService(ctx, gcp.PubSubClientOptions("")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic := "projects/my-project/topics/bucket-events"
+
+	if _, err := service.Projects.Topics.Create(topic, &pubsub.Topic{}).Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _, _ = service.Projects.Topics.Delete(topic).Do() }()
+
+	if _, err := service.Projects.Subscriptions.Create(testSubscription, &pubsub.Subscription{Topic: topic}).Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _, _ = service.Projects.Subscriptions.Delete(testSubscription).Do() }()
+
+	_, err = service.Projects.Topics.Publish(topic, &pubsub.PublishRequest{Messages: []*pubsub.PubsubMessage{
+		{Data: base64.StdEncoding.EncodeToString([]byte(updateEntry))},
+	}}).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, events := newTestSubscriber(t, "")
+
+	if err := s.Pull(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []Event{{Bucket: "assets", Method: MethodUpdate}}; !reflect.DeepEqual(*events, want) {
+		t.Errorf("events = %+v, want %+v", *events, want)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// maxBodySize bounds the requests accepted by the receiver.
+const maxBodySize = 1 << 20
+
+// Receiver serves an HTTP webhook receiving the audit logs, as log entries
+// in JSON or wrapped in the messages of a Pub/Sub push subscription.
+type Receiver struct {
+	// Addr is the address the receiver listens on, e.g. :8090.
+	Addr string
+	Log  logr.Logger
+
+	handle func(Event)
+}
+
+var _ Source = &Receiver{}
+
+// Notify implements Source.
+func (r *Receiver) Notify(handle func(Event)) {
+	r.handle = handle
+}
+
+// Start implements Source, it serves until stop is closed.
+func (r *Receiver) Start(stop <-chan struct{}) error {
+	l, err := net.Listen("tcp", r.Addr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: r}
+
+	go func() {
+		<-stop
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		_ = srv.Shutdown(ctx)
+	}()
+
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// pushRequest is the body of the requests of a Pub/Sub push subscription.
+type pushRequest struct {
+	Message *struct {
+		Data string `json:"data"`
+	} `json:"message"`
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	var push pushRequest
+	if err := json.Unmarshal(data, &push); err == nil && push.Message != nil {
+		if data, err = base64.StdEncoding.DecodeString(push.Message.Data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	e, err := ParseLogEntry(data)
+	if err != nil {
+		r.Log.Error(err, "unable to parse log entry")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if e != nil {
+		r.handle(*e)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
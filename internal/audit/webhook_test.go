@@ -0,0 +1,59 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestReceiver(t *testing.T) {
+	var events []Event
+
+	r := &Receiver{Log: logf.NullLogger{}}
+	r.Notify(func(e Event) { events = append(events, e) })
+
+	push := fmt.Sprintf(`{"message": {"data": %q, "messageId": "1"}, "subscription": "projects/p/subscriptions/s"}`,
+		base64.StdEncoding.EncodeToString([]byte(updateEntry)))
+
+	for _, body := range []string{updateEntry, push} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+
+		if w.Code != http.StatusNoContent {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusNoContent)
+		}
+	}
+
+	if len(events) != 2 || events[0].Bucket != "assets" || events[1].Bucket != "assets" {
+		t.Errorf("events = %+v, want two updates of assets", events)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{")))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status of an invalid entry = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,243 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package backend defines the operations the reconcilers run against the
+// object storage service holding the buckets.
+package backend
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrBucketNotExist is returned when the bucket doesn't exist.
+	ErrBucketNotExist = errors.New("bucket doesn't exist")
+
+	// ErrNotificationNotExist is returned when the notification doesn't
+	// exist.
+	ErrNotificationNotExist = errors.New("notification doesn't exist")
+
+	// ErrStop is returned by the function passed to Objects to stop the
+	// listing, Objects returns nil in that case.
+	ErrStop = errors.New("stop listing")
+
+	// ErrNotSupported is returned by the operations the backend doesn't
+	// implement.
+	ErrNotSupported = errors.New("operation not supported by the backend")
+)
+
+// Reason classifies the errors of the storage service.
+type Reason string
+
+const (
+	// ReasonNameUnavailable means the bucket name is taken by someone else,
+	// bucket names are globally unique.
+	ReasonNameUnavailable Reason = "NameUnavailable"
+
+	// ReasonPermissionDenied means the credentials lack a permission.
+	ReasonPermissionDenied Reason = "PermissionDenied"
+
+	// ReasonRateLimited means the service throttled the request.
+	ReasonRateLimited Reason = "RateLimited"
+
+	// ReasonUnavailable means the service failed to serve the request.
+	ReasonUnavailable Reason = "ServiceUnavailable"
+
+	// ReasonPreconditionFailed means the resource changed since it was
+	// read.
+	ReasonPreconditionFailed Reason = "PreconditionFailed"
+)
+
+// Error is an error of the storage service classified by its reason.
+type Error struct {
+	Reason Reason
+
+	// Permission is the missing permission of a PermissionDenied error,
+	// empty when the service doesn't report it.
+	Permission string
+
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error of the service.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Transient reports if the operation can succeed when it's retried later
+// without any change.
+func (e *Error) Transient() bool {
+	return e.Reason == ReasonRateLimited || e.Reason == ReasonUnavailable
+}
+
+const (
+	// LifecycleDelete deletes the objects matching the rule.
+	LifecycleDelete = "Delete"
+
+	// LifecycleSetStorageClass changes the storage class of the objects
+	// matching the rule.
+	LifecycleSetStorageClass = "SetStorageClass"
+)
+
+// LifecycleRule is an action applied to the objects of a bucket matching
+// all the conditions set.
+type LifecycleRule struct {
+	Action string
+
+	// StorageClass is the target of the SetStorageClass action.
+	StorageClass string
+
+	// AgeDays matches the objects older than the number of days.
+	AgeDays int64
+
+	// NumNewerVersions matches the noncurrent versions with at least this
+	// number of newer versions.
+	NumNewerVersions int64
+}
+
+// BucketAttrs are the attributes of a bucket
+type BucketAttrs struct {
+	Name                     string
+	Location                 string
+	StorageClass             string
+	Labels                   map[string]string
+	UniformBucketLevelAccess bool
+
+	// RetentionPeriod is zero when the bucket has no retention policy.
+	RetentionPeriod time.Duration
+
+	VersioningEnabled bool
+	Lifecycle         []LifecycleRule
+
+	// Metageneration is incremented on every update of the attributes.
+	Metageneration int64
+	Created        time.Time
+}
+
+// BucketAttrsToUpdate are the attributes of a bucket to change, nil fields
+// are not changed.
+type BucketAttrsToUpdate struct {
+	// MetagenerationMatch makes the update fail with PreconditionFailed
+	// when the bucket metageneration is not the given one, it has no effect
+	// when zero. Updates with a precondition are safe to retry.
+	MetagenerationMatch int64
+
+	UniformBucketLevelAccess *bool
+
+	// RetentionPeriod removes the retention policy when it's zero.
+	RetentionPeriod *time.Duration
+
+	VersioningEnabled *bool
+
+	// Lifecycle replaces the lifecycle rules, an empty list removes them.
+	Lifecycle *[]LifecycleRule
+
+	SetLabels    map[string]string
+	DeleteLabels []string
+}
+
+// SetLabel sets a label of the bucket.
+func (u *BucketAttrsToUpdate) SetLabel(name, value string) {
+	if u.SetLabels == nil {
+		u.SetLabels = map[string]string{}
+	}
+
+	u.SetLabels[name] = value
+}
+
+// DeleteLabel removes a label of the bucket.
+func (u *BucketAttrsToUpdate) DeleteLabel(name string) {
+	u.DeleteLabels = append(u.DeleteLabels, name)
+}
+
+// IAMPolicy is the IAM policy of a bucket
+type IAMPolicy struct {
+	// Bindings maps each role to its members.
+	Bindings map[string][]string
+
+	// Etag of the policy, setting a policy fails if it was changed after
+	// it was read.
+	Etag []byte
+}
+
+// Notification is a Pub/Sub notification configuration of a bucket
+type Notification struct {
+	ID               string
+	TopicProjectID   string
+	TopicID          string
+	EventTypes       []string
+	ObjectNamePrefix string
+	CustomAttributes map[string]string
+	PayloadFormat    string
+}
+
+// ObjectAttrs are the attributes of an object
+type ObjectAttrs struct {
+	Name    string
+	Size    int64
+	Updated time.Time
+}
+
+// BucketBackend manages the buckets of an object storage service
+type BucketBackend interface {
+	// Get returns the attributes of the bucket, ErrBucketNotExist if it
+	// doesn't exist.
+	Get(ctx context.Context, name string) (*BucketAttrs, error)
+
+	// List calls fn with the attributes of each bucket of the project,
+	// until fn returns an error. ErrStop stops the listing without error.
+	List(ctx context.Context, project string, fn func(*BucketAttrs) error) error
+
+	// Create creates the bucket in the project.
+	Create(ctx context.Context, project string, attrs *BucketAttrs) error
+
+	// Update changes the attributes of the bucket and returns the result.
+	Update(ctx context.Context, name string, uattrs BucketAttrsToUpdate) (*BucketAttrs, error)
+
+	// Unsupported describes the attributes the backend can't apply to the
+	// bucket, they are ignored by Create and Update.
+	Unsupported(attrs *BucketAttrs) []string
+
+	// Delete removes the bucket, it must be empty.
+	Delete(ctx context.Context, name string) error
+
+	// IAMPolicy returns the IAM policy of the bucket.
+	IAMPolicy(ctx context.Context, name string) (*IAMPolicy, error)
+
+	// SetIAMPolicy replaces the IAM policy of the bucket.
+	SetIAMPolicy(ctx context.Context, name string, policy *IAMPolicy) error
+
+	// Notifications returns the notifications of the bucket by ID.
+	Notifications(ctx context.Context, name string) (map[string]*Notification, error)
+
+	// AddNotification adds a notification to the bucket and returns it
+	// with its ID.
+	AddNotification(ctx context.Context, name string, n *Notification) (*Notification, error)
+
+	// DeleteNotification removes a notification of the bucket.
+	DeleteNotification(ctx context.Context, name, id string) error
+
+	// Objects calls fn for each object of the bucket with the prefix, until
+	// fn returns an error. ErrStop stops the listing without error.
+	Objects(ctx context.Context, name, prefix string, fn func(*ObjectAttrs) error) error
+}
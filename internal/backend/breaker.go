@@ -0,0 +1,278 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// CircuitOpenError is returned without calling the storage service while
+// the circuit breaker is open.
+type CircuitOpenError struct {
+	// RetryAfter is the time until the breaker lets an operation through.
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("storage service unavailable, circuit breaker open for %s", e.RetryAfter.Round(time.Second))
+}
+
+// Breaker stops calling the storage service after a number of consecutive
+// failures, during an outage, for a cooldown period. After the cooldown a
+// single operation is let through, the breaker closes if it succeeds and
+// opens again otherwise.
+//
+// Only transient errors, timeouts and network errors count as failures,
+// any other answer of the service means it's available.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// NewBreaker returns a breaker opening after threshold consecutive
+// failures for cooldown.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Wrap returns a backend running the operations of be through the breaker,
+// a nil breaker returns be. Every backend wrapped by the same breaker
+// shares its state.
+func (b *Breaker) Wrap(be BucketBackend) BucketBackend {
+	if b == nil || b.threshold <= 0 {
+		return be
+	}
+
+	return &breakerBackend{be: be, b: b}
+}
+
+// Open reports if the breaker is rejecting operations.
+func (b *Breaker) Open() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.threshold > 0 && b.failures >= b.threshold
+}
+
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+
+	if now := b.now(); now.Before(b.openUntil) {
+		return &CircuitOpenError{RetryAfter: b.openUntil.Sub(now)}
+	}
+
+	if b.probing {
+		return &CircuitOpenError{RetryAfter: b.cooldown}
+	}
+
+	b.probing = true
+
+	return nil
+}
+
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	if !isOutage(err) {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+
+	if b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// isOutage checks if the error means the service is unavailable.
+func isOutage(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Transient()
+	}
+
+	var ne net.Error
+
+	return errors.As(err, &ne)
+}
+
+type breakerBackend struct {
+	be BucketBackend
+	b  *Breaker
+}
+
+func (b *breakerBackend) Get(ctx context.Context, name string) (*BucketAttrs, error) {
+	if err := b.b.allow(); err != nil {
+		return nil, err
+	}
+
+	a, err := b.be.Get(ctx, name)
+	b.b.record(err)
+
+	return a, err
+}
+
+func (b *breakerBackend) List(ctx context.Context, project string, fn func(*BucketAttrs) error) error {
+	if err := b.b.allow(); err != nil {
+		return err
+	}
+
+	err := b.be.List(ctx, project, fn)
+	b.b.record(err)
+
+	return err
+}
+
+func (b *breakerBackend) Create(ctx context.Context, project string, attrs *BucketAttrs) error {
+	if err := b.b.allow(); err != nil {
+		return err
+	}
+
+	err := b.be.Create(ctx, project, attrs)
+	b.b.record(err)
+
+	return err
+}
+
+func (b *breakerBackend) Update(ctx context.Context, name string, uattrs BucketAttrsToUpdate) (*BucketAttrs, error) {
+	if err := b.b.allow(); err != nil {
+		return nil, err
+	}
+
+	a, err := b.be.Update(ctx, name, uattrs)
+	b.b.record(err)
+
+	return a, err
+}
+
+func (b *breakerBackend) Unsupported(attrs *BucketAttrs) []string {
+	return b.be.Unsupported(attrs)
+}
+
+func (b *breakerBackend) Delete(ctx context.Context, name string) error {
+	if err := b.b.allow(); err != nil {
+		return err
+	}
+
+	err := b.be.Delete(ctx, name)
+	b.b.record(err)
+
+	return err
+}
+
+func (b *breakerBackend) IAMPolicy(ctx context.Context, name string) (*IAMPolicy, error) {
+	if err := b.b.allow(); err != nil {
+		return nil, err
+	}
+
+	p, err := b.be.IAMPolicy(ctx, name)
+	b.b.record(err)
+
+	return p, err
+}
+
+func (b *breakerBackend) SetIAMPolicy(ctx context.Context, name string, policy *IAMPolicy) error {
+	if err := b.b.allow(); err != nil {
+		return err
+	}
+
+	err := b.be.SetIAMPolicy(ctx, name, policy)
+	b.b.record(err)
+
+	return err
+}
+
+func (b *breakerBackend) Notifications(ctx context.Context, name string) (map[string]*Notification, error) {
+	if err := b.b.allow(); err != nil {
+		return nil, err
+	}
+
+	n, err := b.be.Notifications(ctx, name)
+	b.b.record(err)
+
+	return n, err
+}
+
+func (b *breakerBackend) AddNotification(ctx context.Context, name string, n *Notification) (*Notification, error) {
+	if err := b.b.allow(); err != nil {
+		return nil, err
+	}
+
+	n, err := b.be.AddNotification(ctx, name, n)
+	b.b.record(err)
+
+	return n, err
+}
+
+func (b *breakerBackend) DeleteNotification(ctx context.Context, name, id string) error {
+	if err := b.b.allow(); err != nil {
+		return err
+	}
+
+	err := b.be.DeleteNotification(ctx, name, id)
+	b.b.record(err)
+
+	return err
+}
+
+func (b *breakerBackend) Objects(ctx context.Context, name, prefix string, fn func(*ObjectAttrs) error) error {
+	if err := b.b.allow(); err != nil {
+		return err
+	}
+
+	err := b.be.Objects(ctx, name, prefix, fn)
+	b.b.record(err)
+
+	return err
+}
@@ -0,0 +1,120 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// stub implements Get, the other operations of the embedded nil backend
+// panic.
+type stub struct {
+	BucketBackend
+
+	calls int
+	err   error
+}
+
+func (s *stub) Get(ctx context.Context, _ string) (*BucketAttrs, error) {
+	s.calls++
+
+	if s.err == errBlock {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	return &BucketAttrs{}, s.err
+}
+
+var errBlock = errors.New("block")
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+
+	b := NewBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	s := &stub{err: &Error{Reason: ReasonUnavailable, Err: errors.New("503")}}
+	be := b.Wrap(s)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := be.Get(ctx, "b"); err != s.err {
+			t.Fatalf("error of call %d = %v, want %v", i, err, s.err)
+		}
+	}
+
+	var open *CircuitOpenError
+
+	if _, err := be.Get(ctx, "b"); !errors.As(err, &open) || open.RetryAfter != time.Minute {
+		t.Fatalf("error with the breaker open = %v, want circuit open for 1m", err)
+	}
+
+	if s.calls != 2 {
+		t.Errorf("calls with the breaker open = %d, want 2", s.calls)
+	}
+
+	now = now.Add(time.Minute)
+
+	if _, err := be.Get(ctx, "b"); err != s.err {
+		t.Fatalf("error of the probe = %v, want %v", err, s.err)
+	}
+
+	if _, err := be.Get(ctx, "b"); !errors.As(err, &open) {
+		t.Fatalf("error after a failed probe = %v, want circuit open", err)
+	}
+
+	now = now.Add(time.Minute)
+	s.err = ErrBucketNotExist
+
+	if _, err := be.Get(ctx, "b"); err != ErrBucketNotExist {
+		t.Fatalf("error of the probe = %v, want %v", err, ErrBucketNotExist)
+	}
+
+	if b.Open() {
+		t.Errorf("breaker open after the service answered")
+	}
+}
+
+func TestBreakerIgnoresClientErrors(t *testing.T) {
+	b := NewBreaker(1, time.Minute)
+	s := &stub{err: &Error{Reason: ReasonPermissionDenied, Err: errors.New("403")}}
+	be := b.Wrap(s)
+
+	for i := 0; i < 3; i++ {
+		if _, err := be.Get(context.Background(), "b"); err != s.err {
+			t.Fatalf("error of call %d = %v, want %v", i, err, s.err)
+		}
+	}
+}
+
+func TestNilBreaker(t *testing.T) {
+	var b *Breaker
+
+	s := &stub{}
+	if be := b.Wrap(s); be != s {
+		t.Errorf("nil breaker wrapped the backend")
+	}
+
+	if b.Open() {
+		t.Errorf("nil breaker open")
+	}
+}
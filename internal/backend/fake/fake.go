@@ -0,0 +1,431 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake implements an in-memory bucket backend for tests.
+package fake
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+)
+
+// ErrBucketExists is returned when creating a bucket that already exists.
+var ErrBucketExists = errors.New("bucket already exists")
+
+// ErrBucketNotEmpty is returned when deleting a bucket with objects.
+var ErrBucketNotEmpty = errors.New("bucket is not empty")
+
+// ErrPreconditionFailed is returned when setting an IAM policy with an
+// outdated etag or updating a bucket with an outdated metageneration.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+type bucket struct {
+	project       string
+	attrs         backend.BucketAttrs
+	policy        map[string][]string
+	policyVersion int
+	notifications map[string]*backend.Notification
+	objects       map[string]*backend.ObjectAttrs
+}
+
+// Backend keeps the buckets in memory, it's safe for concurrent use.
+type Backend struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	errors  map[string]error
+	nextID  int
+}
+
+var _ backend.BucketBackend = &Backend{}
+
+// New returns an empty backend.
+func New() *Backend {
+	return &Backend{buckets: map[string]*bucket{}, errors: map[string]error{}}
+}
+
+// SetError makes every call to the operation, named as the method of the
+// interface, fail with err. A nil err restores the operation.
+func (f *Backend) SetError(op string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil {
+		delete(f.errors, op)
+	} else {
+		f.errors[op] = err
+	}
+}
+
+// PutObject adds an object to an existing bucket.
+func (f *Backend) PutObject(name, object string, size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b, ok := f.buckets[name]
+	if !ok {
+		return backend.ErrBucketNotExist
+	}
+
+	b.objects[object] = &backend.ObjectAttrs{Name: object, Size: size, Updated: time.Now()}
+
+	return nil
+}
+
+// Project returns the project of the bucket, empty if it doesn't exist.
+func (f *Backend) Project(name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if b, ok := f.buckets[name]; ok {
+		return b.project
+	}
+
+	return ""
+}
+
+// Get implements backend.BucketBackend
+func (f *Backend) Get(_ context.Context, name string) (*backend.BucketAttrs, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b, err := f.bucket("Get", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyAttrs(&b.attrs), nil
+}
+
+// List implements backend.BucketBackend
+func (f *Backend) List(_ context.Context, project string, fn func(*backend.BucketAttrs) error) error {
+	f.mu.Lock()
+
+	if err := f.errors["List"]; err != nil {
+		f.mu.Unlock()
+
+		return err
+	}
+
+	var buckets []*backend.BucketAttrs
+	for _, b := range f.buckets {
+		if b.project == project {
+			buckets = append(buckets, copyAttrs(&b.attrs))
+		}
+	}
+
+	f.mu.Unlock()
+
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+
+	for _, a := range buckets {
+		if err := fn(a); err != nil {
+			if err == backend.ErrStop {
+				return nil
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Create implements backend.BucketBackend
+func (f *Backend) Create(_ context.Context, project string, attrs *backend.BucketAttrs) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.errors["Create"]; err != nil {
+		return err
+	}
+
+	if _, ok := f.buckets[attrs.Name]; ok {
+		return ErrBucketExists
+	}
+
+	b := &bucket{
+		project:       project,
+		attrs:         *copyAttrs(attrs),
+		policy:        map[string][]string{},
+		notifications: map[string]*backend.Notification{},
+		objects:       map[string]*backend.ObjectAttrs{},
+	}
+
+	b.attrs.Location = strings.ToUpper(b.attrs.Location)
+	b.attrs.Metageneration = 1
+	b.attrs.Created = time.Now()
+
+	if b.attrs.StorageClass == "" {
+		b.attrs.StorageClass = "STANDARD"
+	}
+
+	f.buckets[attrs.Name] = b
+
+	return nil
+}
+
+// Update implements backend.BucketBackend
+func (f *Backend) Update(_ context.Context, name string, uattrs backend.BucketAttrsToUpdate) (*backend.BucketAttrs, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b, err := f.bucket("Update", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if m := uattrs.MetagenerationMatch; m != 0 && m != b.attrs.Metageneration {
+		return nil, ErrPreconditionFailed
+	}
+
+	if uattrs.UniformBucketLevelAccess != nil {
+		b.attrs.UniformBucketLevelAccess = *uattrs.UniformBucketLevelAccess
+	}
+
+	if uattrs.RetentionPeriod != nil {
+		b.attrs.RetentionPeriod = *uattrs.RetentionPeriod
+	}
+
+	if uattrs.VersioningEnabled != nil {
+		b.attrs.VersioningEnabled = *uattrs.VersioningEnabled
+	}
+
+	if uattrs.Lifecycle != nil {
+		b.attrs.Lifecycle = append([]backend.LifecycleRule(nil), *uattrs.Lifecycle...)
+	}
+
+	if b.attrs.Labels == nil {
+		b.attrs.Labels = map[string]string{}
+	}
+
+	for k, v := range uattrs.SetLabels {
+		b.attrs.Labels[k] = v
+	}
+
+	for _, k := range uattrs.DeleteLabels {
+		delete(b.attrs.Labels, k)
+	}
+
+	b.attrs.Metageneration++
+
+	return copyAttrs(&b.attrs), nil
+}
+
+// Unsupported implements backend.BucketBackend, the fake supports every
+// attribute.
+func (f *Backend) Unsupported(_ *backend.BucketAttrs) []string {
+	return nil
+}
+
+// Delete implements backend.BucketBackend
+func (f *Backend) Delete(_ context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b, err := f.bucket("Delete", name)
+	if err != nil {
+		return err
+	}
+
+	if len(b.objects) > 0 {
+		return ErrBucketNotEmpty
+	}
+
+	delete(f.buckets, name)
+
+	return nil
+}
+
+// IAMPolicy implements backend.BucketBackend
+func (f *Backend) IAMPolicy(_ context.Context, name string) (*backend.IAMPolicy, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b, err := f.bucket("IAMPolicy", name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &backend.IAMPolicy{
+		Bindings: make(map[string][]string, len(b.policy)),
+		Etag:     []byte(strconv.Itoa(b.policyVersion)),
+	}
+
+	for role, members := range b.policy {
+		result.Bindings[role] = append([]string(nil), members...)
+	}
+
+	return result, nil
+}
+
+// SetIAMPolicy implements backend.BucketBackend
+func (f *Backend) SetIAMPolicy(_ context.Context, name string, policy *backend.IAMPolicy) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b, err := f.bucket("SetIAMPolicy", name)
+	if err != nil {
+		return err
+	}
+
+	if policy.Etag != nil && string(policy.Etag) != strconv.Itoa(b.policyVersion) {
+		return ErrPreconditionFailed
+	}
+
+	b.policy = map[string][]string{}
+	for role, members := range policy.Bindings {
+		if len(members) > 0 {
+			b.policy[role] = append([]string(nil), members...)
+			sort.Strings(b.policy[role])
+		}
+	}
+
+	b.policyVersion++
+
+	return nil
+}
+
+// Notifications implements backend.BucketBackend
+func (f *Backend) Notifications(_ context.Context, name string) (map[string]*backend.Notification, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b, err := f.bucket("Notifications", name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*backend.Notification, len(b.notifications))
+	for id, n := range b.notifications {
+		c := *n
+		result[id] = &c
+	}
+
+	return result, nil
+}
+
+// AddNotification implements backend.BucketBackend
+func (f *Backend) AddNotification(_ context.Context, name string, n *backend.Notification) (*backend.Notification, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b, err := f.bucket("AddNotification", name)
+	if err != nil {
+		return nil, err
+	}
+
+	f.nextID++
+
+	c := *n
+	c.ID = strconv.Itoa(f.nextID)
+	b.notifications[c.ID] = &c
+
+	result := c
+
+	return &result, nil
+}
+
+// DeleteNotification implements backend.BucketBackend
+func (f *Backend) DeleteNotification(_ context.Context, name, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b, err := f.bucket("DeleteNotification", name)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := b.notifications[id]; !ok {
+		return backend.ErrNotificationNotExist
+	}
+
+	delete(b.notifications, id)
+
+	return nil
+}
+
+// Objects implements backend.BucketBackend, objects are listed by name.
+func (f *Backend) Objects(_ context.Context, name, prefix string, fn func(*backend.ObjectAttrs) error) error {
+	f.mu.Lock()
+
+	b, err := f.bucket("Objects", name)
+	if err != nil {
+		f.mu.Unlock()
+
+		return err
+	}
+
+	var objects []backend.ObjectAttrs
+	for _, o := range b.objects {
+		if strings.HasPrefix(o.Name, prefix) {
+			objects = append(objects, *o)
+		}
+	}
+
+	f.mu.Unlock()
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+
+	for i := range objects {
+		if err := fn(&objects[i]); err != nil {
+			if err == backend.ErrStop {
+				return nil
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bucket returns the bucket after checking the errors set for the
+// operation, the lock must be held.
+func (f *Backend) bucket(op, name string) (*bucket, error) {
+	if err := f.errors[op]; err != nil {
+		return nil, err
+	}
+
+	b, ok := f.buckets[name]
+	if !ok {
+		return nil, backend.ErrBucketNotExist
+	}
+
+	return b, nil
+}
+
+func copyAttrs(a *backend.BucketAttrs) *backend.BucketAttrs {
+	c := *a
+
+	if a.Labels != nil {
+		c.Labels = make(map[string]string, len(a.Labels))
+		for k, v := range a.Labels {
+			c.Labels[k] = v
+		}
+	}
+
+	c.Lifecycle = append([]backend.LifecycleRule(nil), a.Lifecycle...)
+
+	return &c
+}
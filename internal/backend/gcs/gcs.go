@@ -0,0 +1,401 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gcs implements the bucket backend with Google Cloud Storage.
+package gcs
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"time"
+
+	"cloud.google.com/go/iam"
+	"cloud.google.com/go/storage"
+	gax "github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	iampb "google.golang.org/genproto/googleapis/iam/v1"
+
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+)
+
+// permissionRegexp matches the permission in the message of the 403 errors,
+// e.g. "sa@my-project.iam.gserviceaccount.com does not have
+// storage.buckets.create access to the Google Cloud project."
+var permissionRegexp = regexp.MustCompile(`does not have ([a-z]+(?:\.[a-zA-Z]+)+) access`)
+
+// Backend manages the buckets with a storage client
+type Backend struct {
+	client *storage.Client
+}
+
+var _ backend.BucketBackend = &Backend{}
+
+// New returns a backend using the client.
+func New(client *storage.Client) *Backend {
+	return &Backend{client: client}
+}
+
+// Get implements backend.BucketBackend
+func (g *Backend) Get(ctx context.Context, name string) (*backend.BucketAttrs, error) {
+	a, err := g.client.Bucket(name).Attrs(ctx)
+	if err != nil {
+		return nil, translate(err, backend.ErrBucketNotExist)
+	}
+
+	return fromBucketAttrs(a), nil
+}
+
+// List implements backend.BucketBackend
+func (g *Backend) List(ctx context.Context, project string, fn func(*backend.BucketAttrs) error) error {
+	it := g.client.Buckets(ctx, project)
+
+	for {
+		a, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+
+		if err != nil {
+			return translate(err, err)
+		}
+
+		if err := fn(fromBucketAttrs(a)); err != nil {
+			if err == backend.ErrStop {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+// Create implements backend.BucketBackend
+func (g *Backend) Create(ctx context.Context, project string, attrs *backend.BucketAttrs) error {
+	a := &storage.BucketAttrs{
+		Location:          attrs.Location,
+		StorageClass:      attrs.StorageClass,
+		Labels:            attrs.Labels,
+		BucketPolicyOnly:  storage.BucketPolicyOnly{Enabled: attrs.UniformBucketLevelAccess},
+		VersioningEnabled: attrs.VersioningEnabled,
+		Lifecycle:         toLifecycle(attrs.Lifecycle),
+	}
+
+	if attrs.RetentionPeriod > 0 {
+		a.RetentionPolicy = &storage.RetentionPolicy{RetentionPeriod: attrs.RetentionPeriod}
+	}
+
+	err := g.client.Bucket(attrs.Name).Create(ctx, project, a)
+
+	// the bucket didn't exist when the reconcile started, a conflict means
+	// the name is used in a project we can't see, or a creation retried by
+	// the storage client succeeded on a previous attempt, recognised by the
+	// labels requested
+	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusConflict {
+		if existing, gerr := g.Get(ctx, attrs.Name); gerr == nil && len(attrs.Labels) > 0 && hasLabels(existing.Labels, attrs.Labels) {
+			return nil
+		}
+
+		return &backend.Error{Reason: backend.ReasonNameUnavailable, Err: err}
+	}
+
+	return translate(err, backend.ErrBucketNotExist)
+}
+
+// Update implements backend.BucketBackend
+func (g *Backend) Update(ctx context.Context, name string, uattrs backend.BucketAttrsToUpdate) (*backend.BucketAttrs, error) {
+	var (
+		ua  storage.BucketAttrsToUpdate
+		a   *storage.BucketAttrs
+		err error
+	)
+
+	if uattrs.UniformBucketLevelAccess != nil {
+		ua.BucketPolicyOnly = &storage.BucketPolicyOnly{Enabled: *uattrs.UniformBucketLevelAccess}
+	}
+
+	if uattrs.RetentionPeriod != nil {
+		// an empty policy removes the retention policy
+		ua.RetentionPolicy = &storage.RetentionPolicy{RetentionPeriod: *uattrs.RetentionPeriod}
+	}
+
+	if uattrs.VersioningEnabled != nil {
+		ua.VersioningEnabled = *uattrs.VersioningEnabled
+	}
+
+	if uattrs.Lifecycle != nil {
+		// the storage client omits an empty lifecycle, existing rules can
+		// be replaced but not removed
+		l := toLifecycle(*uattrs.Lifecycle)
+		ua.Lifecycle = &l
+	}
+
+	for k, v := range uattrs.SetLabels {
+		ua.SetLabel(k, v)
+	}
+
+	for _, k := range uattrs.DeleteLabels {
+		ua.DeleteLabel(k)
+	}
+
+	bkt := g.client.Bucket(name)
+
+	call := func() (err error) {
+		a, err = bkt.Update(ctx, ua)
+		return err
+	}
+
+	// the storage client doesn't retry updates, they're only retried here
+	// when the precondition makes them idempotent
+	if m := uattrs.MetagenerationMatch; m != 0 {
+		bkt = bkt.If(storage.BucketConditions{MetagenerationMatch: m})
+		err = retry(ctx, call)
+	} else {
+		err = call()
+	}
+
+	if err != nil {
+		return nil, translate(err, backend.ErrBucketNotExist)
+	}
+
+	return fromBucketAttrs(a), nil
+}
+
+// Unsupported implements backend.BucketBackend, GCS supports every
+// attribute.
+func (g *Backend) Unsupported(_ *backend.BucketAttrs) []string {
+	return nil
+}
+
+// Delete implements backend.BucketBackend
+func (g *Backend) Delete(ctx context.Context, name string) error {
+	return translate(g.client.Bucket(name).Delete(ctx), backend.ErrBucketNotExist)
+}
+
+// IAMPolicy implements backend.BucketBackend
+func (g *Backend) IAMPolicy(ctx context.Context, name string) (*backend.IAMPolicy, error) {
+	p, err := g.client.Bucket(name).IAM().Policy(ctx)
+	if err != nil {
+		return nil, translate(err, backend.ErrBucketNotExist)
+	}
+
+	result := &backend.IAMPolicy{Bindings: map[string][]string{}}
+	if p.InternalProto != nil {
+		result.Etag = p.InternalProto.Etag
+	}
+
+	for _, r := range p.Roles() {
+		result.Bindings[string(r)] = p.Members(r)
+	}
+
+	return result, nil
+}
+
+// SetIAMPolicy implements backend.BucketBackend
+func (g *Backend) SetIAMPolicy(ctx context.Context, name string, policy *backend.IAMPolicy) error {
+	p := &iam.Policy{InternalProto: &iampb.Policy{Etag: policy.Etag}}
+	for role, members := range policy.Bindings {
+		for _, m := range members {
+			p.Add(m, iam.RoleName(role))
+		}
+	}
+
+	return translate(g.client.Bucket(name).IAM().SetPolicy(ctx, p), backend.ErrBucketNotExist)
+}
+
+// Notifications implements backend.BucketBackend
+func (g *Backend) Notifications(ctx context.Context, name string) (map[string]*backend.Notification, error) {
+	ns, err := g.client.Bucket(name).Notifications(ctx)
+	if err != nil {
+		return nil, translate(err, backend.ErrBucketNotExist)
+	}
+
+	result := make(map[string]*backend.Notification, len(ns))
+	for id, n := range ns {
+		result[id] = fromNotification(n)
+	}
+
+	return result, nil
+}
+
+// AddNotification implements backend.BucketBackend
+func (g *Backend) AddNotification(ctx context.Context, name string, n *backend.Notification) (*backend.Notification, error) {
+	created, err := g.client.Bucket(name).AddNotification(ctx, &storage.Notification{
+		TopicProjectID:   n.TopicProjectID,
+		TopicID:          n.TopicID,
+		EventTypes:       n.EventTypes,
+		ObjectNamePrefix: n.ObjectNamePrefix,
+		CustomAttributes: n.CustomAttributes,
+		PayloadFormat:    n.PayloadFormat,
+	})
+	if err != nil {
+		return nil, translate(err, backend.ErrBucketNotExist)
+	}
+
+	return fromNotification(created), nil
+}
+
+// DeleteNotification implements backend.BucketBackend
+func (g *Backend) DeleteNotification(ctx context.Context, name, id string) error {
+	return translate(g.client.Bucket(name).DeleteNotification(ctx, id), backend.ErrNotificationNotExist)
+}
+
+// Objects implements backend.BucketBackend
+func (g *Backend) Objects(ctx context.Context, name, prefix string, fn func(*backend.ObjectAttrs) error) error {
+	it := g.client.Bucket(name).Objects(ctx, &storage.Query{Prefix: prefix})
+
+	for {
+		o, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+
+		if err != nil {
+			return translate(err, backend.ErrBucketNotExist)
+		}
+
+		if err := fn(&backend.ObjectAttrs{Name: o.Name, Size: o.Size, Updated: o.Updated}); err != nil {
+			if err == backend.ErrStop {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+// retry calls fn until it succeeds, fails with an error that is not
+// transient or ctx is done.
+func retry(ctx context.Context, fn func() error) error {
+	bo := gax.Backoff{Initial: 100 * time.Millisecond, Max: 5 * time.Second}
+
+	for {
+		err := fn()
+
+		e, ok := err.(*googleapi.Error)
+		if !ok || (e.Code != http.StatusTooManyRequests && e.Code < http.StatusInternalServerError) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(bo.Pause()):
+		}
+	}
+}
+
+// hasLabels checks labels contains every label of want.
+func hasLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// translate replaces the not found errors with notFound and classifies the
+// errors of the API by their code.
+func translate(err, notFound error) error {
+	if err == storage.ErrBucketNotExist {
+		return notFound
+	}
+
+	e, ok := err.(*googleapi.Error)
+	if !ok {
+		return err
+	}
+
+	switch {
+	case e.Code == http.StatusNotFound:
+		return notFound
+	case e.Code == http.StatusForbidden:
+		result := &backend.Error{Reason: backend.ReasonPermissionDenied, Err: err}
+		if m := permissionRegexp.FindStringSubmatch(e.Message); m != nil {
+			result.Permission = m[1]
+		}
+
+		return result
+	case e.Code == http.StatusPreconditionFailed:
+		return &backend.Error{Reason: backend.ReasonPreconditionFailed, Err: err}
+	case e.Code == http.StatusTooManyRequests:
+		return &backend.Error{Reason: backend.ReasonRateLimited, Err: err}
+	case e.Code >= http.StatusInternalServerError:
+		return &backend.Error{Reason: backend.ReasonUnavailable, Err: err}
+	}
+
+	return err
+}
+
+func fromBucketAttrs(a *storage.BucketAttrs) *backend.BucketAttrs {
+	result := &backend.BucketAttrs{
+		Name:                     a.Name,
+		Location:                 a.Location,
+		StorageClass:             a.StorageClass,
+		Labels:                   a.Labels,
+		UniformBucketLevelAccess: a.BucketPolicyOnly.Enabled,
+		VersioningEnabled:        a.VersioningEnabled,
+		Metageneration:           a.MetaGeneration,
+		Created:                  a.Created,
+	}
+
+	if a.RetentionPolicy != nil {
+		result.RetentionPeriod = a.RetentionPolicy.RetentionPeriod
+	}
+
+	for _, r := range a.Lifecycle.Rules {
+		result.Lifecycle = append(result.Lifecycle, backend.LifecycleRule{
+			Action:           r.Action.Type,
+			StorageClass:     r.Action.StorageClass,
+			AgeDays:          r.Condition.AgeInDays,
+			NumNewerVersions: r.Condition.NumNewerVersions,
+		})
+	}
+
+	return result
+}
+
+func toLifecycle(rules []backend.LifecycleRule) storage.Lifecycle {
+	var l storage.Lifecycle
+
+	for _, r := range rules {
+		l.Rules = append(l.Rules, storage.LifecycleRule{
+			Action: storage.LifecycleAction{Type: r.Action, StorageClass: r.StorageClass},
+			Condition: storage.LifecycleCondition{
+				AgeInDays:        r.AgeDays,
+				NumNewerVersions: r.NumNewerVersions,
+			},
+		})
+	}
+
+	return l
+}
+
+func fromNotification(n *storage.Notification) *backend.Notification {
+	return &backend.Notification{
+		ID:               n.ID,
+		TopicProjectID:   n.TopicProjectID,
+		TopicID:          n.TopicID,
+		EventTypes:       n.EventTypes,
+		ObjectNamePrefix: n.ObjectNamePrefix,
+		CustomAttributes: n.CustomAttributes,
+		PayloadFormat:    n.PayloadFormat,
+	}
+}
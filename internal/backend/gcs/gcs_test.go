@@ -0,0 +1,345 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcs_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend/fake"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend/gcs"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend/gcs/gcstest"
+)
+
+func newTestBackend(t *testing.T) (*gcs.Backend, *gcstest.Server) {
+	srv := gcstest.NewServer(fake.New())
+	t.Cleanup(srv.Close)
+
+	client, err := storage.NewClient(context.Background(), srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return gcs.New(client), srv
+}
+
+func TestBucketLifecycle(t *testing.T) {
+	ctx := context.Background()
+	be, _ := newTestBackend(t)
+
+	if _, err := be.Get(ctx, "assets"); err != backend.ErrBucketNotExist {
+		t.Fatalf("Get() of a missing bucket = %v, want ErrBucketNotExist", err)
+	}
+
+	err := be.Create(ctx, "my-project", &backend.BucketAttrs{
+		Name:                     "assets",
+		Location:                 "EU",
+		StorageClass:             "NEARLINE",
+		Labels:                   map[string]string{"team": "storage", "tmp": "yes"},
+		UniformBucketLevelAccess: true,
+		RetentionPeriod:          time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := be.Get(ctx, "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Location != "EU" || a.StorageClass != "NEARLINE" || !a.UniformBucketLevelAccess || a.RetentionPeriod != time.Hour {
+		t.Errorf("unexpected attributes %+v", a)
+	}
+
+	var uattrs backend.BucketAttrsToUpdate
+	uattrs.SetLabel("team", "platform")
+	uattrs.DeleteLabel("tmp")
+
+	disabled, none := false, time.Duration(0)
+	uattrs.UniformBucketLevelAccess = &disabled
+	uattrs.RetentionPeriod = &none
+
+	a, err = be.Update(ctx, "assets", uattrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Labels["team"] != "platform" || a.Labels["tmp"] != "" || a.UniformBucketLevelAccess || a.RetentionPeriod != 0 {
+		t.Errorf("unexpected attributes after the update %+v", a)
+	}
+
+	if a.Metageneration != 2 {
+		t.Errorf("Metageneration = %d, want 2", a.Metageneration)
+	}
+
+	if err := be.Delete(ctx, "assets"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := be.Delete(ctx, "assets"); err != backend.ErrBucketNotExist {
+		t.Errorf("Delete() of a missing bucket = %v, want ErrBucketNotExist", err)
+	}
+}
+
+func TestVersioningAndLifecycle(t *testing.T) {
+	ctx := context.Background()
+	be, _ := newTestBackend(t)
+
+	rules := []backend.LifecycleRule{
+		{Action: backend.LifecycleSetStorageClass, StorageClass: "COLDLINE", AgeDays: 30},
+		{Action: backend.LifecycleDelete, NumNewerVersions: 3},
+	}
+
+	err := be.Create(ctx, "my-project", &backend.BucketAttrs{Name: "assets", VersioningEnabled: true, Lifecycle: rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := be.Get(ctx, "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !a.VersioningEnabled || !reflect.DeepEqual(a.Lifecycle, rules) {
+		t.Errorf("unexpected attributes %+v", a)
+	}
+
+	disabled := false
+	rules = rules[1:]
+
+	a, err = be.Update(ctx, "assets", backend.BucketAttrsToUpdate{VersioningEnabled: &disabled, Lifecycle: &rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.VersioningEnabled || !reflect.DeepEqual(a.Lifecycle, rules) {
+		t.Errorf("unexpected attributes after the update %+v", a)
+	}
+}
+
+func TestIAMNotificationsAndObjects(t *testing.T) {
+	ctx := context.Background()
+	be, srv := newTestBackend(t)
+
+	if err := be.Create(ctx, "my-project", &backend.BucketAttrs{Name: "assets"}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := be.IAMPolicy(ctx, "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Bindings["roles/storage.objectViewer"] = []string{"user:jane@example.com"}
+	if err := be.SetIAMPolicy(ctx, "assets", p); err != nil {
+		t.Fatal(err)
+	}
+
+	if p, _ = be.IAMPolicy(ctx, "assets"); len(p.Bindings["roles/storage.objectViewer"]) != 1 {
+		t.Errorf("unexpected bindings %v", p.Bindings)
+	}
+
+	n, err := be.AddNotification(ctx, "assets", &backend.Notification{
+		TopicProjectID: "my-project",
+		TopicID:        "uploads",
+		PayloadFormat:  storage.JSONPayload,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ns, err := be.Notifications(ctx, "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ns[n.ID]; got == nil || got.TopicID != "uploads" || got.TopicProjectID != "my-project" {
+		t.Errorf("unexpected notifications %v", ns)
+	}
+
+	if err := be.DeleteNotification(ctx, "assets", n.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, o := range []string{"a/1", "a/2", "b/1"} {
+		if err := srv.Backend.PutObject("assets", o, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var size int64
+
+	err = be.Objects(ctx, "assets", "a/", func(o *backend.ObjectAttrs) error {
+		size += o.Size
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size != 20 {
+		t.Errorf("size of the objects with prefix a/ = %d, want 20", size)
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.Background()
+	be, _ := newTestBackend(t)
+
+	for _, b := range []struct{ project, name string }{{"p1", "b"}, {"p1", "a"}, {"p2", "c"}} {
+		if err := be.Create(ctx, b.project, &backend.BucketAttrs{Name: b.name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var names []string
+
+	err := be.List(ctx, "p1", func(a *backend.BucketAttrs) error {
+		names = append(names, a.Name)
+
+		if a.Metageneration == 0 {
+			t.Errorf("bucket %s listed without metageneration", a.Name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a", "b"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("buckets of p1 = %v, want %v", names, want)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	be, srv := newTestBackend(t)
+
+	srv.Backend.SetError("Get", &googleapi.Error{
+		Code:    http.StatusForbidden,
+		Message: "sa@my-project.iam.gserviceaccount.com does not have storage.buckets.get access to the Google Cloud Storage bucket.",
+	})
+
+	_, err := be.Get(ctx, "assets")
+
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || gerr.Code != http.StatusForbidden {
+		t.Errorf("Get() = %v, want a 403 error", err)
+	}
+
+	if e, ok := err.(*backend.Error); !ok || e.Reason != backend.ReasonPermissionDenied || e.Permission != "storage.buckets.get" {
+		t.Errorf("Get() = %#v, want PermissionDenied for storage.buckets.get", err)
+	}
+
+	srv.Backend.SetError("Get", &googleapi.Error{Code: http.StatusPreconditionFailed})
+
+	if _, err := be.Get(ctx, "assets"); !hasReason(err, backend.ReasonPreconditionFailed) {
+		t.Errorf("Get() = %v, want PreconditionFailed", err)
+	}
+
+	srv.Backend.SetError("Get", nil)
+
+	if err := be.Create(ctx, "my-project", &backend.BucketAttrs{Name: "assets"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = be.Create(ctx, "my-project", &backend.BucketAttrs{Name: "assets"})
+	if !errors.As(err, &gerr) || gerr.Code != http.StatusConflict {
+		t.Errorf("Create() of an existing bucket = %v, want a 409 error", err)
+	}
+
+	if !hasReason(err, backend.ReasonNameUnavailable) {
+		t.Errorf("Create() of an existing bucket = %v, want NameUnavailable", err)
+	}
+}
+
+func TestUpdatePrecondition(t *testing.T) {
+	ctx := context.Background()
+	be, srv := newTestBackend(t)
+
+	err := be.Create(ctx, "my-project", &backend.BucketAttrs{Name: "assets", Labels: map[string]string{"owner": "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a retried creation that succeeded on the first attempt
+	if err := be.Create(ctx, "my-project", &backend.BucketAttrs{Name: "assets", Labels: map[string]string{"owner": "a"}}); err != nil {
+		t.Errorf("Create() of a bucket with the requested labels = %v, want nil", err)
+	}
+
+	if err := be.Create(ctx, "my-project", &backend.BucketAttrs{Name: "assets", Labels: map[string]string{"owner": "b"}}); !hasReason(err, backend.ReasonNameUnavailable) {
+		t.Errorf("Create() of a bucket with other labels = %v, want NameUnavailable", err)
+	}
+
+	a, err := be.Get(ctx, "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uattrs := backend.BucketAttrsToUpdate{MetagenerationMatch: a.Metageneration + 1}
+	uattrs.SetLabel("team", "storage")
+
+	if _, err := be.Update(ctx, "assets", uattrs); !hasReason(err, backend.ReasonPreconditionFailed) {
+		t.Errorf("Update() with an outdated metageneration = %v, want PreconditionFailed", err)
+	}
+
+	unavailable := &googleapi.Error{Code: http.StatusServiceUnavailable}
+	srv.Backend.SetError("Update", unavailable)
+
+	uattrs.MetagenerationMatch = 0
+
+	if _, err := be.Update(ctx, "assets", uattrs); !hasReason(err, backend.ReasonUnavailable) {
+		t.Errorf("Update() without precondition = %v, want ServiceUnavailable", err)
+	}
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		srv.Backend.SetError("Update", nil)
+	}()
+
+	uattrs.MetagenerationMatch = a.Metageneration
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	a, err = be.Update(ctx, "assets", uattrs)
+	if err != nil {
+		t.Fatalf("Update() with precondition = %v, want it retried", err)
+	}
+
+	if a.Labels["team"] != "storage" {
+		t.Errorf("labels after the update = %v, want team=storage", a.Labels)
+	}
+}
+
+func hasReason(err error, reason backend.Reason) bool {
+	e, ok := err.(*backend.Error)
+
+	return ok && e.Reason == reason
+}
@@ -0,0 +1,481 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gcstest implements an in-process server of the subset of the GCS
+// JSON API used by the operator, storing the buckets in a fake backend.
+package gcstest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	raw "google.golang.org/api/storage/v1"
+
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend/fake"
+)
+
+// basePath is the path of the JSON API served.
+const basePath = "/storage/v1/"
+
+// Server serves the GCS JSON API from a fake backend
+type Server struct {
+	// Backend holds the buckets served, errors set in it are returned by
+	// the server.
+	Backend *fake.Backend
+
+	srv *httptest.Server
+}
+
+// NewServer starts a server storing the buckets in be.
+func NewServer(be *fake.Backend) *Server {
+	s := &Server{Backend: be}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// Endpoint returns the endpoint of the JSON API.
+func (s *Server) Endpoint() string {
+	return s.srv.URL + basePath
+}
+
+// ClientOptions returns the options of a storage client using the server.
+func (s *Server) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{option.WithEndpoint(s.Endpoint()), option.WithoutAuthentication()}
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, basePath+"b") {
+		writeError(w, http.StatusNotFound, "not found")
+
+		return
+	}
+
+	ctx := r.Context()
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, basePath), "/"), "/")
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.listBuckets(ctx, w, r)
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		s.insertBucket(ctx, w, r)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		s.getBucket(ctx, w, parts[1])
+	case len(parts) == 2 && r.Method == http.MethodPatch:
+		s.patchBucket(ctx, w, r, parts[1])
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		s.result(w, nil, s.Backend.Delete(ctx, parts[1]))
+	case len(parts) == 3 && parts[2] == "o" && r.Method == http.MethodGet:
+		s.listObjects(ctx, w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "iam" && r.Method == http.MethodGet:
+		s.getIAMPolicy(ctx, w, parts[1])
+	case len(parts) == 3 && parts[2] == "iam" && r.Method == http.MethodPut:
+		s.setIAMPolicy(ctx, w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "notificationConfigs" && r.Method == http.MethodGet:
+		s.listNotifications(ctx, w, parts[1])
+	case len(parts) == 3 && parts[2] == "notificationConfigs" && r.Method == http.MethodPost:
+		s.insertNotification(ctx, w, r, parts[1])
+	case len(parts) == 4 && parts[2] == "notificationConfigs" && r.Method == http.MethodDelete:
+		s.result(w, nil, s.Backend.DeleteNotification(ctx, parts[1], parts[3]))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s %s not supported", r.Method, r.URL.Path))
+	}
+}
+
+func (s *Server) insertBucket(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var rb raw.Bucket
+	if err := json.NewDecoder(r.Body).Decode(&rb); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	attrs := &backend.BucketAttrs{
+		Name:         rb.Name,
+		Location:     rb.Location,
+		StorageClass: rb.StorageClass,
+		Labels:       rb.Labels,
+	}
+
+	if rb.IamConfiguration != nil && rb.IamConfiguration.BucketPolicyOnly != nil {
+		attrs.UniformBucketLevelAccess = rb.IamConfiguration.BucketPolicyOnly.Enabled
+	}
+
+	if rb.RetentionPolicy != nil {
+		attrs.RetentionPeriod = time.Duration(rb.RetentionPolicy.RetentionPeriod) * time.Second
+	}
+
+	if rb.Versioning != nil {
+		attrs.VersioningEnabled = rb.Versioning.Enabled
+	}
+
+	attrs.Lifecycle = fromRawLifecycle(rb.Lifecycle)
+
+	if err := s.Backend.Create(ctx, r.URL.Query().Get("project"), attrs); err != nil {
+		s.result(w, nil, err)
+
+		return
+	}
+
+	s.getBucket(ctx, w, rb.Name)
+}
+
+func (s *Server) listBuckets(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	result := &raw.Buckets{Kind: "storage#buckets"}
+
+	err := s.Backend.List(ctx, r.URL.Query().Get("project"), func(a *backend.BucketAttrs) error {
+		result.Items = append(result.Items, toRawBucket(a))
+
+		return nil
+	})
+
+	s.result(w, result, err)
+}
+
+func (s *Server) getBucket(ctx context.Context, w http.ResponseWriter, name string) {
+	a, err := s.Backend.Get(ctx, name)
+	if err != nil {
+		s.result(w, nil, err)
+
+		return
+	}
+
+	s.result(w, toRawBucket(a), nil)
+}
+
+// patchBucket applies a patch, null labels and a null retention policy are
+// removed.
+func (s *Server) patchBucket(ctx context.Context, w http.ResponseWriter, r *http.Request, name string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	var fields map[string]json.RawMessage
+
+	var patch struct {
+		Labels           map[string]*string          `json:"labels"`
+		IamConfiguration *raw.BucketIamConfiguration `json:"iamConfiguration"`
+		RetentionPolicy  *raw.BucketRetentionPolicy  `json:"retentionPolicy"`
+		Versioning       *raw.BucketVersioning       `json:"versioning"`
+		Lifecycle        *raw.BucketLifecycle        `json:"lifecycle"`
+	}
+
+	for _, v := range []interface{}{&fields, &patch} {
+		if err := json.Unmarshal(body, v); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+
+			return
+		}
+	}
+
+	var uattrs backend.BucketAttrsToUpdate
+
+	if m := r.URL.Query().Get("ifMetagenerationMatch"); m != "" {
+		uattrs.MetagenerationMatch, err = strconv.ParseInt(m, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+
+			return
+		}
+	}
+
+	for k, v := range patch.Labels {
+		if v == nil {
+			uattrs.DeleteLabel(k)
+		} else {
+			uattrs.SetLabel(k, *v)
+		}
+	}
+
+	if c := patch.IamConfiguration; c != nil && c.BucketPolicyOnly != nil {
+		enabled := c.BucketPolicyOnly.Enabled
+		uattrs.UniformBucketLevelAccess = &enabled
+	}
+
+	// a null retention policy is sent to remove it
+	if _, ok := fields["retentionPolicy"]; ok {
+		var period time.Duration
+		if rp := patch.RetentionPolicy; rp != nil {
+			period = time.Duration(rp.RetentionPeriod) * time.Second
+		}
+
+		uattrs.RetentionPeriod = &period
+	}
+
+	if v := patch.Versioning; v != nil {
+		enabled := v.Enabled
+		uattrs.VersioningEnabled = &enabled
+	}
+
+	if patch.Lifecycle != nil {
+		rules := fromRawLifecycle(patch.Lifecycle)
+		uattrs.Lifecycle = &rules
+	}
+
+	a, err := s.Backend.Update(ctx, name, uattrs)
+	if err != nil {
+		s.result(w, nil, err)
+
+		return
+	}
+
+	s.result(w, toRawBucket(a), nil)
+}
+
+func (s *Server) listObjects(ctx context.Context, w http.ResponseWriter, r *http.Request, name string) {
+	result := &raw.Objects{Kind: "storage#objects"}
+
+	err := s.Backend.Objects(ctx, name, r.URL.Query().Get("prefix"), func(o *backend.ObjectAttrs) error {
+		result.Items = append(result.Items, &raw.Object{
+			Bucket:  name,
+			Name:    o.Name,
+			Size:    uint64(o.Size),
+			Updated: o.Updated.Format(time.RFC3339),
+		})
+
+		return nil
+	})
+
+	s.result(w, result, err)
+}
+
+func (s *Server) getIAMPolicy(ctx context.Context, w http.ResponseWriter, name string) {
+	p, err := s.Backend.IAMPolicy(ctx, name)
+	if err != nil {
+		s.result(w, nil, err)
+
+		return
+	}
+
+	result := &raw.Policy{Kind: "storage#policy", Etag: string(p.Etag)}
+	for role, members := range p.Bindings {
+		result.Bindings = append(result.Bindings, &raw.PolicyBindings{Role: role, Members: members})
+	}
+
+	s.result(w, result, nil)
+}
+
+func (s *Server) setIAMPolicy(ctx context.Context, w http.ResponseWriter, r *http.Request, name string) {
+	var rp raw.Policy
+	if err := json.NewDecoder(r.Body).Decode(&rp); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	p := &backend.IAMPolicy{Bindings: map[string][]string{}}
+	if rp.Etag != "" {
+		p.Etag = []byte(rp.Etag)
+	}
+
+	for _, b := range rp.Bindings {
+		p.Bindings[b.Role] = append(p.Bindings[b.Role], b.Members...)
+	}
+
+	if err := s.Backend.SetIAMPolicy(ctx, name, p); err != nil {
+		s.result(w, nil, err)
+
+		return
+	}
+
+	s.getIAMPolicy(ctx, w, name)
+}
+
+func (s *Server) listNotifications(ctx context.Context, w http.ResponseWriter, name string) {
+	ns, err := s.Backend.Notifications(ctx, name)
+	if err != nil {
+		s.result(w, nil, err)
+
+		return
+	}
+
+	result := &raw.Notifications{Kind: "storage#notifications"}
+	for _, n := range ns {
+		result.Items = append(result.Items, toRawNotification(n))
+	}
+
+	s.result(w, result, nil)
+}
+
+func (s *Server) insertNotification(ctx context.Context, w http.ResponseWriter, r *http.Request, name string) {
+	var rn raw.Notification
+	if err := json.NewDecoder(r.Body).Decode(&rn); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	n := &backend.Notification{
+		EventTypes:       rn.EventTypes,
+		ObjectNamePrefix: rn.ObjectNamePrefix,
+		CustomAttributes: rn.CustomAttributes,
+		PayloadFormat:    rn.PayloadFormat,
+	}
+
+	// topics are formatted as //pubsub.googleapis.com/projects/{p}/topics/{t}
+	if parts := strings.Split(rn.Topic, "/"); len(parts) == 7 {
+		n.TopicProjectID, n.TopicID = parts[4], parts[6]
+	}
+
+	created, err := s.Backend.AddNotification(ctx, name, n)
+	if err != nil {
+		s.result(w, nil, err)
+
+		return
+	}
+
+	s.result(w, toRawNotification(created), nil)
+}
+
+// result writes v as the response, or the status code matching err.
+func (s *Server) result(w http.ResponseWriter, v interface{}, err error) {
+	if err != nil {
+		writeError(w, statusCode(err), err.Error())
+
+		return
+	}
+
+	if v == nil {
+		w.WriteHeader(http.StatusNoContent)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func statusCode(err error) int {
+	switch err {
+	case backend.ErrBucketNotExist, backend.ErrNotificationNotExist:
+		return http.StatusNotFound
+	case fake.ErrBucketExists, fake.ErrBucketNotEmpty:
+		return http.StatusConflict
+	case fake.ErrPreconditionFailed:
+		return http.StatusPreconditionFailed
+	}
+
+	if e, ok := err.(*googleapi.Error); ok {
+		return e.Code
+	}
+
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": msg},
+	})
+}
+
+func toRawBucket(a *backend.BucketAttrs) *raw.Bucket {
+	rb := &raw.Bucket{
+		Kind:             "storage#bucket",
+		Id:               a.Name,
+		Name:             a.Name,
+		Location:         a.Location,
+		StorageClass:     a.StorageClass,
+		Labels:           a.Labels,
+		Metageneration:   a.Metageneration,
+		TimeCreated:      a.Created.Format(time.RFC3339),
+		IamConfiguration: &raw.BucketIamConfiguration{BucketPolicyOnly: &raw.BucketIamConfigurationBucketPolicyOnly{Enabled: a.UniformBucketLevelAccess}},
+	}
+
+	if a.RetentionPeriod > 0 {
+		rb.RetentionPolicy = &raw.BucketRetentionPolicy{
+			RetentionPeriod: int64(a.RetentionPeriod / time.Second),
+			EffectiveTime:   a.Created.Format(time.RFC3339),
+		}
+	}
+
+	if a.VersioningEnabled {
+		rb.Versioning = &raw.BucketVersioning{Enabled: true}
+	}
+
+	if len(a.Lifecycle) > 0 {
+		rb.Lifecycle = &raw.BucketLifecycle{}
+		for _, r := range a.Lifecycle {
+			rb.Lifecycle.Rule = append(rb.Lifecycle.Rule, &raw.BucketLifecycleRule{
+				Action: &raw.BucketLifecycleRuleAction{Type: r.Action, StorageClass: r.StorageClass},
+				Condition: &raw.BucketLifecycleRuleCondition{
+					Age:              r.AgeDays,
+					NumNewerVersions: r.NumNewerVersions,
+				},
+			})
+		}
+	}
+
+	return rb
+}
+
+func fromRawLifecycle(l *raw.BucketLifecycle) []backend.LifecycleRule {
+	if l == nil {
+		return nil
+	}
+
+	var rules []backend.LifecycleRule
+
+	for _, r := range l.Rule {
+		rule := backend.LifecycleRule{}
+		if r.Action != nil {
+			rule.Action = r.Action.Type
+			rule.StorageClass = r.Action.StorageClass
+		}
+
+		if r.Condition != nil {
+			rule.AgeDays = r.Condition.Age
+			rule.NumNewerVersions = r.Condition.NumNewerVersions
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+func toRawNotification(n *backend.Notification) *raw.Notification {
+	return &raw.Notification{
+		Kind:             "storage#notification",
+		Id:               n.ID,
+		Topic:            fmt.Sprintf("//pubsub.googleapis.com/projects/%s/topics/%s", n.TopicProjectID, n.TopicID),
+		EventTypes:       n.EventTypes,
+		ObjectNamePrefix: n.ObjectNamePrefix,
+		CustomAttributes: n.CustomAttributes,
+		PayloadFormat:    n.PayloadFormat,
+	}
+}
@@ -0,0 +1,134 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"time"
+)
+
+// Observer is called after each operation of a backend with the name of
+// the method, its duration and its error.
+type Observer func(op string, d time.Duration, err error)
+
+// WithObserver returns a backend reporting the operations of be to observe.
+func WithObserver(be BucketBackend, observe Observer) BucketBackend {
+	return &observedBackend{be: be, observe: observe}
+}
+
+type observedBackend struct {
+	be      BucketBackend
+	observe Observer
+}
+
+func (b *observedBackend) done(op string, start time.Time, err error) {
+	b.observe(op, time.Since(start), err)
+}
+
+func (b *observedBackend) Get(ctx context.Context, name string) (*BucketAttrs, error) {
+	start := time.Now()
+	a, err := b.be.Get(ctx, name)
+	b.done("Get", start, err)
+
+	return a, err
+}
+
+func (b *observedBackend) List(ctx context.Context, project string, fn func(*BucketAttrs) error) error {
+	start := time.Now()
+	err := b.be.List(ctx, project, fn)
+	b.done("List", start, err)
+
+	return err
+}
+
+func (b *observedBackend) Create(ctx context.Context, project string, attrs *BucketAttrs) error {
+	start := time.Now()
+	err := b.be.Create(ctx, project, attrs)
+	b.done("Create", start, err)
+
+	return err
+}
+
+func (b *observedBackend) Update(ctx context.Context, name string, uattrs BucketAttrsToUpdate) (*BucketAttrs, error) {
+	start := time.Now()
+	a, err := b.be.Update(ctx, name, uattrs)
+	b.done("Update", start, err)
+
+	return a, err
+}
+
+func (b *observedBackend) Unsupported(attrs *BucketAttrs) []string {
+	return b.be.Unsupported(attrs)
+}
+
+func (b *observedBackend) Delete(ctx context.Context, name string) error {
+	start := time.Now()
+	err := b.be.Delete(ctx, name)
+	b.done("Delete", start, err)
+
+	return err
+}
+
+func (b *observedBackend) IAMPolicy(ctx context.Context, name string) (*IAMPolicy, error) {
+	start := time.Now()
+	p, err := b.be.IAMPolicy(ctx, name)
+	b.done("IAMPolicy", start, err)
+
+	return p, err
+}
+
+func (b *observedBackend) SetIAMPolicy(ctx context.Context, name string, policy *IAMPolicy) error {
+	start := time.Now()
+	err := b.be.SetIAMPolicy(ctx, name, policy)
+	b.done("SetIAMPolicy", start, err)
+
+	return err
+}
+
+func (b *observedBackend) Notifications(ctx context.Context, name string) (map[string]*Notification, error) {
+	start := time.Now()
+	n, err := b.be.Notifications(ctx, name)
+	b.done("Notifications", start, err)
+
+	return n, err
+}
+
+func (b *observedBackend) AddNotification(ctx context.Context, name string, n *Notification) (*Notification, error) {
+	start := time.Now()
+	n, err := b.be.AddNotification(ctx, name, n)
+	b.done("AddNotification", start, err)
+
+	return n, err
+}
+
+func (b *observedBackend) DeleteNotification(ctx context.Context, name, id string) error {
+	start := time.Now()
+	err := b.be.DeleteNotification(ctx, name, id)
+	b.done("DeleteNotification", start, err)
+
+	return err
+}
+
+// Objects includes the time spent by fn.
+func (b *observedBackend) Objects(ctx context.Context, name, prefix string, fn func(*ObjectAttrs) error) error {
+	start := time.Now()
+	err := b.be.Objects(ctx, name, prefix, fn)
+	b.done("Objects", start, err)
+
+	return err
+}
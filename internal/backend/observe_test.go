@@ -0,0 +1,45 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWithObserver(t *testing.T) {
+	var (
+		ops  []string
+		errs []error
+	)
+
+	s := &stub{err: ErrBucketNotExist}
+	be := WithObserver(s, func(op string, d time.Duration, err error) {
+		ops = append(ops, op)
+		errs = append(errs, err)
+	})
+
+	if _, err := be.Get(context.Background(), "b"); err != ErrBucketNotExist {
+		t.Fatalf("Get() = %v, want %v", err, ErrBucketNotExist)
+	}
+
+	if len(ops) != 1 || ops[0] != "Get" || errs[0] != ErrBucketNotExist {
+		t.Errorf("observed operations %v with errors %v, want Get failing with %v", ops, errs, ErrBucketNotExist)
+	}
+}
@@ -0,0 +1,589 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package s3 implements the bucket backend with the S3 API, as served by
+// MinIO and other S3 compatible services.
+//
+// Labels are stored as bucket tags and the storage classes of the lifecycle
+// rules are mapped to their S3 counterparts. S3 has no projects, IAM
+// bindings or Pub/Sub notifications: the project is ignored and the listing,
+// IAM and notification operations return backend.ErrNotSupported.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/pkg/s3signer"
+
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+)
+
+// DefaultRegion is the region of the buckets created without a location.
+const DefaultRegion = "us-east-1"
+
+// storageClasses maps the GCS storage classes to the S3 storage classes
+// used in lifecycle transitions.
+var storageClasses = map[string]string{
+	"NEARLINE": "STANDARD_IA",
+	"COLDLINE": "GLACIER",
+	"ARCHIVE":  "DEEP_ARCHIVE",
+}
+
+// Config defines how to reach the S3 service.
+type Config struct {
+	// Endpoint is the URL of the service, e.g. http://minio:9000.
+	Endpoint string
+
+	// Region used to sign the requests, defaults to DefaultRegion.
+	Region string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Insecure skips the verification of the TLS certificate.
+	Insecure bool
+}
+
+// Error is an error response of the S3 API.
+type Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Backend manages the buckets with path-style requests signed with
+// signature V4.
+type Backend struct {
+	endpoint *url.URL
+	config   Config
+	client   *http.Client
+}
+
+var _ backend.BucketBackend = &Backend{}
+
+// New returns a backend for the service.
+func New(c Config) (*Backend, error) {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %s: %v", c.Endpoint, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid endpoint %s: scheme must be http or https", c.Endpoint)
+	}
+
+	if c.Region == "" {
+		c.Region = DefaultRegion
+	}
+
+	client := &http.Client{}
+	if c.Insecure {
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	return &Backend{endpoint: u, config: c, client: client}, nil
+}
+
+// Get implements backend.BucketBackend
+func (s *Backend) Get(ctx context.Context, name string) (*backend.BucketAttrs, error) {
+	var location struct {
+		Region string `xml:",chardata"`
+	}
+
+	if err := s.do(ctx, http.MethodGet, name, "location", nil, &location); err != nil {
+		return nil, err
+	}
+
+	a := &backend.BucketAttrs{
+		Name:         name,
+		Location:     location.Region,
+		StorageClass: "STANDARD",
+	}
+
+	if a.Location == "" {
+		a.Location = DefaultRegion
+	}
+
+	tags, err := s.tags(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	a.Labels = tags
+
+	var versioning versioningConfiguration
+	if err := s.do(ctx, http.MethodGet, name, "versioning", nil, &versioning); err != nil {
+		return nil, err
+	}
+
+	a.VersioningEnabled = versioning.Status == "Enabled"
+
+	var lifecycle lifecycleConfiguration
+
+	err = s.do(ctx, http.MethodGet, name, "lifecycle", nil, &lifecycle)
+	if err != nil && !isCode(err, "NoSuchLifecycleConfiguration") {
+		return nil, err
+	}
+
+	a.Lifecycle = fromLifecycle(&lifecycle)
+
+	return a, nil
+}
+
+// Create implements backend.BucketBackend, the project is ignored. The
+// bucket is removed if its attributes can't be set, so a bucket without
+// the owner tags is never left behind.
+func (s *Backend) Create(ctx context.Context, _ string, attrs *backend.BucketAttrs) error {
+	var body interface{}
+	if l := attrs.Location; l != "" && !strings.EqualFold(l, DefaultRegion) {
+		body = &createBucketConfiguration{Xmlns: xmlns, LocationConstraint: strings.ToLower(l)}
+	}
+
+	if err := s.do(ctx, http.MethodPut, attrs.Name, "", body, nil); err != nil {
+		return err
+	}
+
+	err := s.putTags(ctx, attrs.Name, attrs.Labels)
+
+	if err == nil && attrs.VersioningEnabled {
+		err = s.putVersioning(ctx, attrs.Name, true)
+	}
+
+	if err == nil && len(attrs.Lifecycle) > 0 {
+		err = s.putLifecycle(ctx, attrs.Name, attrs.Lifecycle)
+	}
+
+	if err != nil {
+		if derr := s.Delete(ctx, attrs.Name); derr != nil {
+			return fmt.Errorf("%v, and the bucket couldn't be removed: %v", err, derr)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Update implements backend.BucketBackend, the uniform bucket-level access
+// and the retention period are ignored.
+func (s *Backend) Update(ctx context.Context, name string, uattrs backend.BucketAttrsToUpdate) (*backend.BucketAttrs, error) {
+	if len(uattrs.SetLabels) > 0 || len(uattrs.DeleteLabels) > 0 {
+		tags, err := s.tags(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range uattrs.SetLabels {
+			tags[k] = v
+		}
+
+		for _, k := range uattrs.DeleteLabels {
+			delete(tags, k)
+		}
+
+		if err := s.putTags(ctx, name, tags); err != nil {
+			return nil, err
+		}
+	}
+
+	if uattrs.VersioningEnabled != nil {
+		if err := s.putVersioning(ctx, name, *uattrs.VersioningEnabled); err != nil {
+			return nil, err
+		}
+	}
+
+	if uattrs.Lifecycle != nil {
+		if err := s.putLifecycle(ctx, name, *uattrs.Lifecycle); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.Get(ctx, name)
+}
+
+// Unsupported implements backend.BucketBackend
+func (s *Backend) Unsupported(attrs *backend.BucketAttrs) []string {
+	var result []string
+
+	if sc := strings.ToUpper(attrs.StorageClass); sc != "" && sc != "STANDARD" {
+		result = append(result, fmt.Sprintf("storage class %s, buckets have no default storage class", attrs.StorageClass))
+	}
+
+	if attrs.UniformBucketLevelAccess {
+		result = append(result, "uniform bucket-level access")
+	}
+
+	if attrs.RetentionPeriod > 0 {
+		result = append(result, "retention period")
+	}
+
+	for i, r := range attrs.Lifecycle {
+		if r.NumNewerVersions > 0 {
+			result = append(result, fmt.Sprintf("lifecycle rule %d: number of newer versions", i))
+		}
+
+		if r.Action == backend.LifecycleSetStorageClass && storageClasses[r.StorageClass] == "" {
+			result = append(result, fmt.Sprintf("lifecycle rule %d: storage class %s", i, r.StorageClass))
+		}
+	}
+
+	return result
+}
+
+// Delete implements backend.BucketBackend
+func (s *Backend) Delete(ctx context.Context, name string) error {
+	return s.do(ctx, http.MethodDelete, name, "", nil, nil)
+}
+
+// List implements backend.BucketBackend, it's not supported: S3 has no
+// projects and the listing doesn't include the attributes of the buckets.
+func (s *Backend) List(_ context.Context, _ string, _ func(*backend.BucketAttrs) error) error {
+	return backend.ErrNotSupported
+}
+
+// IAMPolicy implements backend.BucketBackend, it's not supported.
+func (s *Backend) IAMPolicy(_ context.Context, _ string) (*backend.IAMPolicy, error) {
+	return nil, backend.ErrNotSupported
+}
+
+// SetIAMPolicy implements backend.BucketBackend, it's not supported.
+func (s *Backend) SetIAMPolicy(_ context.Context, _ string, _ *backend.IAMPolicy) error {
+	return backend.ErrNotSupported
+}
+
+// Notifications implements backend.BucketBackend, it's not supported.
+func (s *Backend) Notifications(_ context.Context, _ string) (map[string]*backend.Notification, error) {
+	return nil, backend.ErrNotSupported
+}
+
+// AddNotification implements backend.BucketBackend, it's not supported.
+func (s *Backend) AddNotification(_ context.Context, _ string, _ *backend.Notification) (*backend.Notification, error) {
+	return nil, backend.ErrNotSupported
+}
+
+// DeleteNotification implements backend.BucketBackend, it's not supported.
+func (s *Backend) DeleteNotification(_ context.Context, _, _ string) error {
+	return backend.ErrNotSupported
+}
+
+// Objects implements backend.BucketBackend
+func (s *Backend) Objects(ctx context.Context, name, prefix string, fn func(*backend.ObjectAttrs) error) error {
+	q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+
+	for {
+		var result listBucketResult
+		if err := s.do(ctx, http.MethodGet, name, q.Encode(), nil, &result); err != nil {
+			return err
+		}
+
+		for _, o := range result.Contents {
+			if err := fn(&backend.ObjectAttrs{Name: o.Key, Size: o.Size, Updated: o.LastModified}); err != nil {
+				if err == backend.ErrStop {
+					return nil
+				}
+
+				return err
+			}
+		}
+
+		if !result.IsTruncated {
+			return nil
+		}
+
+		q.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s *Backend) tags(ctx context.Context, name string) (map[string]string, error) {
+	var t tagging
+
+	err := s.do(ctx, http.MethodGet, name, "tagging", nil, &t)
+	if err != nil && !isCode(err, "NoSuchTagSet") {
+		return nil, err
+	}
+
+	tags := make(map[string]string, len(t.Tags))
+	for _, tag := range t.Tags {
+		tags[tag.Key] = tag.Value
+	}
+
+	return tags, nil
+}
+
+func (s *Backend) putTags(ctx context.Context, name string, tags map[string]string) error {
+	if len(tags) == 0 {
+		return s.do(ctx, http.MethodDelete, name, "tagging", nil, nil)
+	}
+
+	t := &tagging{}
+	for k, v := range tags {
+		t.Tags = append(t.Tags, tag{Key: k, Value: v})
+	}
+
+	return s.do(ctx, http.MethodPut, name, "tagging", t, nil)
+}
+
+func (s *Backend) putVersioning(ctx context.Context, name string, enabled bool) error {
+	v := &versioningConfiguration{Xmlns: xmlns, Status: "Suspended"}
+	if enabled {
+		v.Status = "Enabled"
+	}
+
+	return s.do(ctx, http.MethodPut, name, "versioning", v, nil)
+}
+
+// putLifecycle replaces the lifecycle rules, the rules that can't be
+// expressed in S3 are skipped.
+func (s *Backend) putLifecycle(ctx context.Context, name string, rules []backend.LifecycleRule) error {
+	l := toLifecycle(rules)
+	if len(l.Rules) == 0 {
+		return s.do(ctx, http.MethodDelete, name, "lifecycle", nil, nil)
+	}
+
+	return s.do(ctx, http.MethodPut, name, "lifecycle", l, nil)
+}
+
+// do sends a signed request to the bucket with the raw query, the body and
+// the result are encoded as XML.
+func (s *Backend) do(ctx context.Context, method, bucket, query string, body, result interface{}) error {
+	var payload []byte
+
+	if body != nil {
+		var err error
+
+		payload, err = xml.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/"
+	u.RawQuery = query
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(payload)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+
+	if body != nil {
+		// required by the tagging and lifecycle operations
+		md5sum := md5.Sum(payload)
+		req.Header.Set("Content-Md5", base64.StdEncoding.EncodeToString(md5sum[:]))
+		req.Header.Set("Content-Type", "application/xml")
+	}
+
+	req = s3signer.SignV4(*req, s.config.AccessKeyID, s.config.SecretAccessKey, s.config.SessionToken, s.config.Region)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return translate(resp.StatusCode, data)
+	}
+
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+
+	return xml.Unmarshal(data, result)
+}
+
+// translate returns backend.ErrBucketNotExist for the missing buckets and
+// an *Error otherwise, wrapped in a *backend.Error when it's classified.
+func translate(code int, data []byte) error {
+	e := &Error{StatusCode: code}
+	if err := xml.Unmarshal(data, e); err != nil || e.Code == "" {
+		e.Code = http.StatusText(code)
+	}
+
+	switch {
+	case e.Code == "NoSuchBucket":
+		return backend.ErrBucketNotExist
+	case e.Code == "BucketAlreadyExists":
+		return &backend.Error{Reason: backend.ReasonNameUnavailable, Err: e}
+	case code == http.StatusForbidden:
+		return &backend.Error{Reason: backend.ReasonPermissionDenied, Err: e}
+	case code == http.StatusPreconditionFailed:
+		return &backend.Error{Reason: backend.ReasonPreconditionFailed, Err: e}
+	case code == http.StatusTooManyRequests, e.Code == "SlowDown":
+		return &backend.Error{Reason: backend.ReasonRateLimited, Err: e}
+	case code >= http.StatusInternalServerError:
+		return &backend.Error{Reason: backend.ReasonUnavailable, Err: e}
+	}
+
+	return e
+}
+
+func isCode(err error, code string) bool {
+	var e *Error
+
+	return errors.As(err, &e) && e.Code == code
+}
+
+// xmlns is the name space of the S3 documents.
+const xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+type createBucketConfiguration struct {
+	XMLName            xml.Name `xml:"CreateBucketConfiguration"`
+	Xmlns              string   `xml:"xmlns,attr"`
+	LocationConstraint string   `xml:"LocationConstraint"`
+}
+
+type tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+type tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	Tags    []tag    `xml:"TagSet>Tag"`
+}
+
+type versioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+type lifecycleConfiguration struct {
+	XMLName xml.Name        `xml:"LifecycleConfiguration"`
+	Rules   []lifecycleRule `xml:"Rule"`
+}
+
+type lifecycleRule struct {
+	ID         string               `xml:"ID"`
+	Status     string               `xml:"Status"`
+	Prefix     string               `xml:"Filter>Prefix"`
+	Expiration *lifecycleExpiration `xml:"Expiration,omitempty"`
+	Transition *lifecycleTransition `xml:"Transition,omitempty"`
+}
+
+type lifecycleExpiration struct {
+	Days int64 `xml:"Days"`
+}
+
+type lifecycleTransition struct {
+	Days         int64  `xml:"Days"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func toLifecycle(rules []backend.LifecycleRule) *lifecycleConfiguration {
+	l := &lifecycleConfiguration{}
+
+	for i, r := range rules {
+		// the rules based on the number of newer versions have no
+		// equivalent, they're reported as unsupported
+		if r.NumNewerVersions > 0 {
+			continue
+		}
+
+		rule := lifecycleRule{ID: fmt.Sprintf("rule-%d", i), Status: "Enabled"}
+
+		switch r.Action {
+		case backend.LifecycleDelete:
+			rule.Expiration = &lifecycleExpiration{Days: r.AgeDays}
+		case backend.LifecycleSetStorageClass:
+			sc, ok := storageClasses[r.StorageClass]
+			if !ok {
+				continue
+			}
+
+			rule.Transition = &lifecycleTransition{Days: r.AgeDays, StorageClass: sc}
+		default:
+			continue
+		}
+
+		l.Rules = append(l.Rules, rule)
+	}
+
+	return l
+}
+
+func fromLifecycle(l *lifecycleConfiguration) []backend.LifecycleRule {
+	var rules []backend.LifecycleRule
+
+	for _, r := range l.Rules {
+		if r.Status != "Enabled" {
+			continue
+		}
+
+		switch {
+		case r.Expiration != nil:
+			rules = append(rules, backend.LifecycleRule{Action: backend.LifecycleDelete, AgeDays: r.Expiration.Days})
+		case r.Transition != nil:
+			rule := backend.LifecycleRule{Action: backend.LifecycleSetStorageClass, AgeDays: r.Transition.Days}
+
+			for gcs, sc := range storageClasses {
+				if sc == r.Transition.StorageClass {
+					rule.StorageClass = gcs
+				}
+			}
+
+			if rule.StorageClass == "" {
+				rule.StorageClass = r.Transition.StorageClass
+			}
+
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules
+}
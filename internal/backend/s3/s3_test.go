@@ -0,0 +1,394 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+)
+
+// fakeBucket keeps the raw documents stored by the fake server.
+type fakeBucket struct {
+	location   string
+	tagging    []byte
+	versioning []byte
+	lifecycle  []byte
+	objects    []string
+}
+
+// fakeServer serves the subset of the S3 API used by the backend.
+type fakeServer struct {
+	mu      sync.Mutex
+	buckets map[string]*fakeBucket
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied")
+
+		return
+	}
+
+	name := strings.Trim(r.URL.Path, "/")
+	body, _ := ioutil.ReadAll(r.Body)
+	q := r.URL.Query()
+
+	b, ok := f.buckets[name]
+	if !ok && !(r.Method == http.MethodPut && len(q) == 0) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPut && len(q) == 0:
+		if ok {
+			writeS3Error(w, http.StatusConflict, "BucketAlreadyOwnedByYou")
+
+			return
+		}
+
+		var c createBucketConfiguration
+		if len(body) > 0 {
+			_ = xml.Unmarshal(body, &c)
+		}
+
+		f.buckets[name] = &fakeBucket{location: c.LocationConstraint}
+	case r.Method == http.MethodDelete && len(q) == 0:
+		if len(b.objects) > 0 {
+			writeS3Error(w, http.StatusConflict, "BucketNotEmpty")
+
+			return
+		}
+
+		delete(f.buckets, name)
+		w.WriteHeader(http.StatusNoContent)
+	case q.Get("list-type") == "2":
+		result := `<ListBucketResult>`
+		for _, o := range b.objects {
+			result += fmt.Sprintf(`<Contents><Key>%s</Key><Size>10</Size><LastModified>2021-01-02T03:04:05Z</LastModified></Contents>`, o)
+		}
+
+		fmt.Fprint(w, result+`<IsTruncated>false</IsTruncated></ListBucketResult>`)
+	case has(q, "location"):
+		fmt.Fprintf(w, `<LocationConstraint xmlns="%s">%s</LocationConstraint>`, xmlns, b.location)
+	default:
+		f.document(w, r, b, q, body)
+	}
+}
+
+func (f *fakeServer) document(w http.ResponseWriter, r *http.Request, b *fakeBucket, q map[string][]string, body []byte) {
+	var doc *[]byte
+
+	missing := ""
+
+	switch {
+	case has(q, "tagging"):
+		doc, missing = &b.tagging, "NoSuchTagSet"
+	case has(q, "versioning"):
+		doc = &b.versioning
+	case has(q, "lifecycle"):
+		doc, missing = &b.lifecycle, "NoSuchLifecycleConfiguration"
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if *doc == nil && missing != "" {
+			writeS3Error(w, http.StatusNotFound, missing)
+
+			return
+		}
+
+		_, _ = w.Write(*doc)
+	case http.MethodPut:
+		if r.Header.Get("Content-Md5") == "" {
+			writeS3Error(w, http.StatusBadRequest, "InvalidRequest")
+
+			return
+		}
+
+		*doc = body
+	case http.MethodDelete:
+		*doc = nil
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func has(q map[string][]string, key string) bool {
+	_, ok := q[key]
+
+	return ok
+}
+
+func writeS3Error(w http.ResponseWriter, code int, s3code string) {
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `<Error><Code>%s</Code><Message>%s</Message></Error>`, s3code, s3code)
+}
+
+func newTestBackend(t *testing.T) (*Backend, *fakeServer) {
+	fake := &fakeServer{buckets: map[string]*fakeBucket{}}
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	be, err := New(Config{Endpoint: srv.URL, AccessKeyID: "access", SecretAccessKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return be, fake
+}
+
+func TestBucketLifecycle(t *testing.T) {
+	ctx := context.Background()
+	be, fake := newTestBackend(t)
+
+	if _, err := be.Get(ctx, "assets"); err != backend.ErrBucketNotExist {
+		t.Fatalf("Get() of a missing bucket = %v, want ErrBucketNotExist", err)
+	}
+
+	rules := []backend.LifecycleRule{
+		{Action: backend.LifecycleDelete, AgeDays: 365},
+		{Action: backend.LifecycleSetStorageClass, StorageClass: "NEARLINE", AgeDays: 30},
+	}
+
+	err := be.Create(ctx, "ignored", &backend.BucketAttrs{
+		Name:              "assets",
+		Location:          "EU-WEST-1",
+		Labels:            map[string]string{"team": "storage", "tmp": "yes"},
+		VersioningEnabled: true,
+		Lifecycle:         rules,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := fake.buckets["assets"].location; l != "eu-west-1" {
+		t.Errorf("location constraint = %q, want eu-west-1", l)
+	}
+
+	if !strings.Contains(string(fake.buckets["assets"].lifecycle), "<StorageClass>STANDARD_IA</StorageClass>") {
+		t.Errorf("storage class not mapped in %s", fake.buckets["assets"].lifecycle)
+	}
+
+	a, err := be.Get(ctx, "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &backend.BucketAttrs{
+		Name:              "assets",
+		Location:          "eu-west-1",
+		StorageClass:      "STANDARD",
+		Labels:            map[string]string{"team": "storage", "tmp": "yes"},
+		VersioningEnabled: true,
+		Lifecycle:         rules,
+	}
+
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("Get() = %+v, want %+v", a, want)
+	}
+
+	var uattrs backend.BucketAttrsToUpdate
+	uattrs.SetLabel("team", "platform")
+	uattrs.DeleteLabel("tmp")
+
+	disabled, none := false, []backend.LifecycleRule{}
+	uattrs.VersioningEnabled = &disabled
+	uattrs.Lifecycle = &none
+
+	a, err = be.Update(ctx, "assets", uattrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(a.Labels, map[string]string{"team": "platform"}) || a.VersioningEnabled || a.Lifecycle != nil {
+		t.Errorf("unexpected attributes after the update %+v", a)
+	}
+
+	fake.buckets["assets"].objects = []string{"a", "b"}
+
+	var names []string
+
+	err = be.Objects(ctx, "assets", "", func(o *backend.ObjectAttrs) error {
+		names = append(names, o.Name)
+
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("Objects() = %v, %v", names, err)
+	}
+
+	if err := be.Delete(ctx, "assets"); err == nil || !isCode(err, "BucketNotEmpty") {
+		t.Errorf("Delete() of a bucket with objects = %v, want BucketNotEmpty", err)
+	}
+
+	fake.buckets["assets"].objects = nil
+
+	if err := be.Delete(ctx, "assets"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := be.Delete(ctx, "assets"); err != backend.ErrBucketNotExist {
+		t.Errorf("Delete() of a missing bucket = %v, want ErrBucketNotExist", err)
+	}
+}
+
+func TestCreateDefaultRegion(t *testing.T) {
+	ctx := context.Background()
+	be, fake := newTestBackend(t)
+
+	if err := be.Create(ctx, "", &backend.BucketAttrs{Name: "assets", Location: "us-east-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := be.Get(ctx, "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Location != DefaultRegion || len(a.Labels) != 0 || fake.buckets["assets"].tagging != nil {
+		t.Errorf("unexpected attributes %+v", a)
+	}
+
+	if err := be.Create(ctx, "", &backend.BucketAttrs{Name: "assets"}); !isCode(err, "BucketAlreadyOwnedByYou") {
+		t.Errorf("Create() of an existing bucket = %v, want BucketAlreadyOwnedByYou", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		code   int
+		s3code string
+		reason backend.Reason
+	}{
+		{http.StatusConflict, "BucketAlreadyExists", backend.ReasonNameUnavailable},
+		{http.StatusForbidden, "AccessDenied", backend.ReasonPermissionDenied},
+		{http.StatusPreconditionFailed, "PreconditionFailed", backend.ReasonPreconditionFailed},
+		{http.StatusServiceUnavailable, "SlowDown", backend.ReasonRateLimited},
+		{http.StatusInternalServerError, "InternalError", backend.ReasonUnavailable},
+		{http.StatusConflict, "BucketNotEmpty", ""},
+	}
+
+	for _, tt := range tests {
+		err := translate(tt.code, []byte(fmt.Sprintf(`<Error><Code>%s</Code></Error>`, tt.s3code)))
+
+		var reason backend.Reason
+		if e, ok := err.(*backend.Error); ok {
+			reason = e.Reason
+		}
+
+		if reason != tt.reason || !isCode(err, tt.s3code) {
+			t.Errorf("translate(%d, %s) = %v, want reason %q", tt.code, tt.s3code, err, tt.reason)
+		}
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	be, _ := newTestBackend(t)
+
+	got := be.Unsupported(&backend.BucketAttrs{
+		StorageClass:             "COLDLINE",
+		UniformBucketLevelAccess: true,
+		RetentionPeriod:          time.Hour,
+		Lifecycle: []backend.LifecycleRule{
+			{Action: backend.LifecycleDelete, AgeDays: 30},
+			{Action: backend.LifecycleDelete, NumNewerVersions: 2},
+			{Action: backend.LifecycleSetStorageClass, StorageClass: "REGIONAL", AgeDays: 30},
+		},
+	})
+
+	if len(got) != 5 {
+		t.Errorf("Unsupported() = %v, want 5 attributes", got)
+	}
+
+	if got := be.Unsupported(&backend.BucketAttrs{StorageClass: "standard"}); len(got) != 0 {
+		t.Errorf("Unsupported() = %v, want none", got)
+	}
+
+	if _, err := be.IAMPolicy(context.Background(), "assets"); err != backend.ErrNotSupported {
+		t.Errorf("IAMPolicy() = %v, want ErrNotSupported", err)
+	}
+}
+
+// TestMinIO runs the backend against the MinIO server at S3_TEST_ENDPOINT,
+// e.g. started with:
+//
+//	docker run -p 9000:9000 -e MINIO_ACCESS_KEY=minio -e MINIO_SECRET_KEY=minio123 minio/minio server /data
+func TestMinIO(t *testing.T) {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT not set")
+	}
+
+	be, err := New(Config{
+		Endpoint:        endpoint,
+		AccessKeyID:     os.Getenv("S3_TEST_ACCESS_KEY"),
+		SecretAccessKey: os.Getenv("S3_TEST_SECRET_KEY"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	name := fmt.Sprintf("operator-test-%d", time.Now().UnixNano())
+
+	err = be.Create(ctx, "", &backend.BucketAttrs{
+		Name:              name,
+		Labels:            map[string]string{"team": "storage"},
+		VersioningEnabled: true,
+		Lifecycle:         []backend.LifecycleRule{{Action: backend.LifecycleDelete, AgeDays: 30}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := be.Delete(ctx, name); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	a, err := be.Get(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Labels["team"] != "storage" || !a.VersioningEnabled || len(a.Lifecycle) != 1 {
+		t.Errorf("unexpected attributes %+v", a)
+	}
+}
@@ -0,0 +1,196 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Timeouts bounds the time each operation of a backend can take, an
+// operation without a timeout set uses Default. Zero disables the timeout.
+type Timeouts struct {
+	Default time.Duration
+
+	// Operations maps the name of the methods of BucketBackend, e.g.
+	// Create, to their timeout.
+	Operations map[string]time.Duration
+}
+
+// ParseTimeouts parses a list of operation timeouts separated by commas,
+// e.g. Create=1m,Objects=5m.
+func ParseTimeouts(s string) (map[string]time.Duration, error) {
+	result := map[string]time.Duration{}
+
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid operation timeout %q, expected operation=duration", kv)
+		}
+
+		op := strings.TrimSpace(kv[:i])
+		if !isOperation(op) {
+			return nil, fmt.Errorf("unknown operation %q", op)
+		}
+
+		d, err := time.ParseDuration(strings.TrimSpace(kv[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout of %s: %v", op, err)
+		}
+
+		result[op] = d
+	}
+
+	return result, nil
+}
+
+var operations = []string{
+	"Get", "List", "Create", "Update", "Delete", "IAMPolicy", "SetIAMPolicy",
+	"Notifications", "AddNotification", "DeleteNotification", "Objects",
+}
+
+func isOperation(op string) bool {
+	for _, o := range operations {
+		if o == op {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t Timeouts) timeout(op string) time.Duration {
+	if d, ok := t.Operations[op]; ok {
+		return d
+	}
+
+	return t.Default
+}
+
+func (t Timeouts) context(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	d := t.timeout(op)
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, d)
+}
+
+// WithTimeouts returns a backend running the operations of be with the
+// timeouts.
+func WithTimeouts(be BucketBackend, t Timeouts) BucketBackend {
+	if t.Default <= 0 && len(t.Operations) == 0 {
+		return be
+	}
+
+	return &timeoutBackend{be: be, t: t}
+}
+
+type timeoutBackend struct {
+	be BucketBackend
+	t  Timeouts
+}
+
+func (b *timeoutBackend) Get(ctx context.Context, name string) (*BucketAttrs, error) {
+	ctx, cancel := b.t.context(ctx, "Get")
+	defer cancel()
+
+	return b.be.Get(ctx, name)
+}
+
+// List bounds the whole listing, not each page.
+func (b *timeoutBackend) List(ctx context.Context, project string, fn func(*BucketAttrs) error) error {
+	ctx, cancel := b.t.context(ctx, "List")
+	defer cancel()
+
+	return b.be.List(ctx, project, fn)
+}
+
+func (b *timeoutBackend) Create(ctx context.Context, project string, attrs *BucketAttrs) error {
+	ctx, cancel := b.t.context(ctx, "Create")
+	defer cancel()
+
+	return b.be.Create(ctx, project, attrs)
+}
+
+func (b *timeoutBackend) Update(ctx context.Context, name string, uattrs BucketAttrsToUpdate) (*BucketAttrs, error) {
+	ctx, cancel := b.t.context(ctx, "Update")
+	defer cancel()
+
+	return b.be.Update(ctx, name, uattrs)
+}
+
+func (b *timeoutBackend) Unsupported(attrs *BucketAttrs) []string {
+	return b.be.Unsupported(attrs)
+}
+
+func (b *timeoutBackend) Delete(ctx context.Context, name string) error {
+	ctx, cancel := b.t.context(ctx, "Delete")
+	defer cancel()
+
+	return b.be.Delete(ctx, name)
+}
+
+func (b *timeoutBackend) IAMPolicy(ctx context.Context, name string) (*IAMPolicy, error) {
+	ctx, cancel := b.t.context(ctx, "IAMPolicy")
+	defer cancel()
+
+	return b.be.IAMPolicy(ctx, name)
+}
+
+func (b *timeoutBackend) SetIAMPolicy(ctx context.Context, name string, policy *IAMPolicy) error {
+	ctx, cancel := b.t.context(ctx, "SetIAMPolicy")
+	defer cancel()
+
+	return b.be.SetIAMPolicy(ctx, name, policy)
+}
+
+func (b *timeoutBackend) Notifications(ctx context.Context, name string) (map[string]*Notification, error) {
+	ctx, cancel := b.t.context(ctx, "Notifications")
+	defer cancel()
+
+	return b.be.Notifications(ctx, name)
+}
+
+func (b *timeoutBackend) AddNotification(ctx context.Context, name string, n *Notification) (*Notification, error) {
+	ctx, cancel := b.t.context(ctx, "AddNotification")
+	defer cancel()
+
+	return b.be.AddNotification(ctx, name, n)
+}
+
+func (b *timeoutBackend) DeleteNotification(ctx context.Context, name, id string) error {
+	ctx, cancel := b.t.context(ctx, "DeleteNotification")
+	defer cancel()
+
+	return b.be.DeleteNotification(ctx, name, id)
+}
+
+// Objects bounds the whole listing, not each page.
+func (b *timeoutBackend) Objects(ctx context.Context, name, prefix string, fn func(*ObjectAttrs) error) error {
+	ctx, cancel := b.t.context(ctx, "Objects")
+	defer cancel()
+
+	return b.be.Objects(ctx, name, prefix, fn)
+}
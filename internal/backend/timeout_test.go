@@ -0,0 +1,58 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWithTimeouts(t *testing.T) {
+	s := &stub{err: errBlock}
+
+	be := WithTimeouts(s, Timeouts{Default: time.Hour, Operations: map[string]time.Duration{"Get": 10 * time.Millisecond}})
+
+	if _, err := be.Get(context.Background(), "b"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error of a blocked operation = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if be := WithTimeouts(s, Timeouts{}); be != s {
+		t.Errorf("backend wrapped without timeouts")
+	}
+}
+
+func TestParseTimeouts(t *testing.T) {
+	got, err := ParseTimeouts(" Create=1m, Objects=5m,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]time.Duration{"Create": time.Minute, "Objects": 5 * time.Minute}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("timeouts = %v, want %v", got, want)
+	}
+
+	for _, s := range []string{"Create", "Copy=1m", "Create=soon"} {
+		if _, err := ParseTimeouts(s); err == nil {
+			t.Errorf("no error parsing %q", s)
+		}
+	}
+}
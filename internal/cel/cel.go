@@ -0,0 +1,155 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cel implements the subset of the Common Expression Language used by
+// the bucket policy rules.
+//
+// Supported are literals (strings, numbers, booleans, null and lists), field
+// selection and indexing, the logical, relational, arithmetic and ternary
+// operators, the in operator, the has() macro, the exists and all macros,
+// the size, string and int functions, the size method, and the startsWith,
+// endsWith, contains and matches string methods. Expressions are evaluated
+// against values as produced by decoding JSON, with integers as int64, an
+// integer overflow is an evaluation error.
+//
+// Map literals, type(), unsigned integers, and raw, bytes and triple-quoted
+// strings are not supported, expressions using them don't compile.
+package cel
+
+import (
+	lru "container/list"
+	"fmt"
+	"sync"
+)
+
+// Program is a compiled expression ready to be evaluated.
+type Program struct {
+	expression string
+	root       node
+}
+
+// Compile parses the expression, only the given variables can be referenced.
+func Compile(expression string, variables ...string) (*Program, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, scope: map[string]bool{}}
+	for _, v := range variables {
+		p.scope[v] = true
+	}
+
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+
+	return &Program{expression: expression, root: root}, nil
+}
+
+// EvalBool evaluates the program, the result must be a bool.
+func (p *Program) EvalBool(vars map[string]interface{}) (bool, error) {
+	return evalBool(p.root, activation(vars))
+}
+
+// String returns the source of the program.
+func (p *Program) String() string {
+	return p.expression
+}
+
+type cacheEntry struct {
+	expression string
+	program    *Program
+	err        error
+}
+
+// Cache keeps the compiled programs of the most recently used expressions,
+// compile errors are cached too so invalid expressions are only parsed once.
+// The least recently used program is evicted once the cache is full.
+type Cache struct {
+	variables []string
+	size      int
+
+	mu      sync.Mutex
+	order   *lru.List
+	entries map[string]*lru.Element
+}
+
+// NewCache returns a cache of at most size programs that can reference the
+// variables.
+func NewCache(size int, variables ...string) *Cache {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Cache{
+		variables: variables,
+		size:      size,
+		order:     lru.New(),
+		entries:   map[string]*lru.Element{},
+	}
+}
+
+// Compile returns the cached program of the expression, compiling it on the
+// first use.
+func (c *Cache) Compile(expression string) (*Program, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[expression]; ok {
+		c.order.MoveToFront(el)
+		e := el.Value.(*cacheEntry)
+		c.mu.Unlock()
+
+		return e.program, e.err
+	}
+	c.mu.Unlock()
+
+	p, err := Compile(expression, c.variables...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[expression]; ok {
+		// compiled concurrently, keep the first program
+		c.order.MoveToFront(el)
+		e := el.Value.(*cacheEntry)
+
+		return e.program, e.err
+	}
+
+	c.entries[expression] = c.order.PushFront(&cacheEntry{expression: expression, program: p, err: err})
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).expression)
+	}
+
+	return p, err
+}
+
+// Len returns the number of cached programs.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
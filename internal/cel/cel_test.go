@@ -0,0 +1,177 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cel
+
+import "testing"
+
+func TestEvalBool(t *testing.T) {
+	vars := map[string]interface{}{
+		"object": map[string]interface{}{
+			"spec": map[string]interface{}{
+				"name":           "prod-assets",
+				"removeOnDelete": false,
+				"labels":         map[string]interface{}{"team": "storage"},
+				"replicas":       int64(3),
+			},
+		},
+		"namespace": map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name":   "prod",
+				"labels": map[string]interface{}{"environment": "production"},
+			},
+		},
+	}
+
+	tests := []struct {
+		expression string
+		want       bool
+		fails      bool
+	}{
+		{`object.spec.removeOnDelete == false`, true, false},
+		{`namespace.metadata.labels.environment != 'production' || !object.spec.removeOnDelete`, true, false},
+		{`object.spec.name.startsWith("prod-") && size(object.spec.name) <= 63`, true, false},
+		{`object.spec.name.matches('^[a-z-]+$')`, true, false},
+		{`'team' in object.spec.labels`, true, false},
+		{`object.spec.labels.exists(k, k == 'owner')`, false, false},
+		{`['a', 'b'].all(x, size(x) == 1)`, true, false},
+		{`object.spec.replicas + 1 > 3.5`, true, false},
+		{`has(object.spec.retentionPeriod) ? false : true`, true, false},
+		{`object.spec.retentionPeriod == '1h'`, false, true},
+		{`object.spec.retentionPeriod == '1h' || true`, true, false},
+		{`object.spec.labels['team'] + '-x' == 'storage-x'`, true, false},
+		{`object.spec.name`, false, true},
+		{`size('ñandú') == 5 && 'ñandú'.startsWith('ñ')`, true, false},
+		{`object.spec.labels.team + 'é' == "storageé"`, true, false},
+		{`object.spec.name.matches(object.spec.labels.team)`, false, false},
+		{`object.spec.labels.team.matches('^' + object.spec.labels.team + '$')`, true, false},
+		{`object.spec.name.matches(object.spec.replicas)`, false, true},
+		{`object.spec.name.size() == 11 && object.spec.labels.size() == 1`, true, false},
+		{`['a', 'b'].size() == size(['a', 'b'])`, true, false},
+		{`object.spec.replicas.size() == 1`, false, true},
+		{`9223372036854775807 + object.spec.replicas > 0`, false, true},
+		{`-9223372036854775807 - object.spec.replicas < 0`, false, true},
+		{`4611686018427387904 * object.spec.replicas > 0`, false, true},
+		{`-(-9223372036854775807 - 1) > 0`, false, true},
+		{`(-9223372036854775807 - 1) / -1 > 0`, false, true},
+		{`int(10000000000000000000.0) > 0`, false, true},
+		{`9223372036854775807 - object.spec.replicas == 9223372036854775804`, true, false},
+		{`3037000499 * 3037000499 > 0`, true, false},
+	}
+
+	for _, tt := range tests {
+		p, err := Compile(tt.expression, "object", "namespace")
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.expression, err)
+		}
+
+		got, err := p.EvalBool(vars)
+		if (err != nil) != tt.fails {
+			t.Errorf("EvalBool(%q) error = %v, want failure %v", tt.expression, err, tt.fails)
+
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("EvalBool(%q) = %v, want %v", tt.expression, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, expression := range []string{
+		`object.spec.name ==`,
+		`unknown.field`,
+		`object.spec.name == "unterminated`,
+		`exists(object)`,
+		`(object.spec`,
+		`object.spec.name == 'a' 'b'`,
+		`object.spec.name.unknown()`,
+		`object.spec.name.startsWith()`,
+		`object.spec.name.matches('[')`,
+		`object.spec.name.matches(1)`,
+		"object.spec.name == '\xff'",
+		`object.spec.name.size(1)`,
+	} {
+		if _, err := Compile(expression, "object"); err == nil {
+			t.Errorf("Compile(%q) expected an error", expression)
+		}
+	}
+}
+
+func TestCompileUnsupported(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{`{'team': 'storage'} == object.spec.labels`, "map literals are not supported at 0"},
+		{`type(object.spec.name) == string`, "type() is not supported at 0"},
+		{`object.spec.replicas == 3u`, "unsigned integers are not supported at 24"},
+		{`object.spec.name == r'prod'`, "raw and bytes strings are not supported at 20"},
+		{`object.spec.name == b"prod"`, "raw and bytes strings are not supported at 20"},
+		{`object.spec.name == '''prod'''`, "triple-quoted strings are not supported at 20"},
+		{`object.spec.name == """prod"""`, "triple-quoted strings are not supported at 20"},
+	}
+
+	for _, tt := range tests {
+		_, err := Compile(tt.expression, "object")
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("Compile(%q) error = %v, want %q", tt.expression, err, tt.err)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := NewCache(10, "object")
+
+	p1, err := c.Compile(`object.a == 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p2, _ := c.Compile(`object.a == 1`)
+	if p1 != p2 {
+		t.Error("expected the cached program")
+	}
+
+	if _, err := c.Compile(`object.a ==`); err == nil {
+		t.Error("expected a compile error")
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewCache(2, "object")
+
+	p1, _ := c.Compile(`object.a == 1`)
+	c.Compile(`object.a == 2`)
+
+	// object.a == 1 is the most recently used, object.a == 2 is evicted
+	c.Compile(`object.a == 1`)
+	c.Compile(`object.a == 3`)
+
+	if got := c.Len(); got != 2 {
+		t.Errorf("Len() = %d, expected 2", got)
+	}
+
+	if p, _ := c.Compile(`object.a == 1`); p != p1 {
+		t.Error("expected the cached program of the most recently used expression")
+	}
+
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, expected 2", c.Len())
+	}
+}
@@ -0,0 +1,673 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cel
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// activation holds the values of the variables during an evaluation.
+type activation map[string]interface{}
+
+type node interface {
+	eval(vars activation) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n *literal) eval(_ activation) (interface{}, error) {
+	return n.value, nil
+}
+
+type ident struct {
+	name string
+}
+
+func (n *ident) eval(vars activation) (interface{}, error) {
+	v, ok := vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("no such attribute %q", n.name)
+	}
+
+	return v, nil
+}
+
+type list struct {
+	items []node
+}
+
+func (n *list) eval(vars activation) (interface{}, error) {
+	result := make([]interface{}, 0, len(n.items))
+
+	for _, item := range n.items {
+		v, err := item.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, v)
+	}
+
+	return result, nil
+}
+
+type selectField struct {
+	operand node
+	field   string
+}
+
+func (n *selectField) eval(vars activation) (interface{}, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("no such key %q: operand is %s", n.field, typeName(v))
+	}
+
+	f, ok := m[n.field]
+	if !ok {
+		return nil, fmt.Errorf("no such key %q", n.field)
+	}
+
+	return f, nil
+}
+
+type has struct {
+	operand node
+	field   string
+}
+
+func (n *has) eval(vars activation) (interface{}, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+
+	_, ok = m[n.field]
+
+	return ok, nil
+}
+
+type index struct {
+	operand node
+	key     node
+}
+
+func (n *index) eval(vars activation) (interface{}, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := n.key.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c := v.(type) {
+	case map[string]interface{}:
+		s, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("map key must be a string, got %s", typeName(k))
+		}
+
+		f, ok := c[s]
+		if !ok {
+			return nil, fmt.Errorf("no such key %q", s)
+		}
+
+		return f, nil
+	case []interface{}:
+		i, ok := k.(int64)
+		if !ok {
+			return nil, fmt.Errorf("list index must be an int, got %s", typeName(k))
+		}
+
+		if i < 0 || i >= int64(len(c)) {
+			return nil, fmt.Errorf("index %d out of range", i)
+		}
+
+		return c[i], nil
+	}
+
+	return nil, fmt.Errorf("%s can't be indexed", typeName(v))
+}
+
+type unary struct {
+	op      string
+	operand node
+}
+
+func (n *unary) eval(vars activation) (interface{}, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "!":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("no such overload: !%s", typeName(v))
+		}
+
+		return !b, nil
+	case "-":
+		switch x := v.(type) {
+		case int64:
+			if x == math.MinInt64 {
+				return nil, fmt.Errorf("integer overflow: -(%d)", x)
+			}
+
+			return -x, nil
+		case float64:
+			return -x, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no such overload: %s%s", n.op, typeName(v))
+}
+
+// logical implements && and || with the commutative error handling of CEL,
+// an error on one side is absorbed when the other side decides the result.
+type logical struct {
+	op          string
+	left, right node
+}
+
+func (n *logical) eval(vars activation) (interface{}, error) {
+	decisive := n.op == "||"
+
+	l, lerr := evalBool(n.left, vars)
+	if lerr == nil && l == decisive {
+		return decisive, nil
+	}
+
+	r, rerr := evalBool(n.right, vars)
+	if rerr == nil && r == decisive {
+		return decisive, nil
+	}
+
+	if lerr != nil {
+		return nil, lerr
+	}
+
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	return !decisive, nil
+}
+
+type conditional struct {
+	cond, then, otherwise node
+}
+
+func (n *conditional) eval(vars activation) (interface{}, error) {
+	c, err := evalBool(n.cond, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if c {
+		return n.then.eval(vars)
+	}
+
+	return n.otherwise.eval(vars)
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (n *binary) eval(vars activation) (interface{}, error) {
+	l, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	case "in":
+		return contains(r, l)
+	case "<", "<=", ">", ">=":
+		c, err := compare(l, r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+
+		return c >= 0, nil
+	}
+
+	return arithmetic(n.op, l, r)
+}
+
+type call struct {
+	function string
+	target   node
+	args     []node
+
+	// re is the pattern of matches when it's a literal, compiled with the
+	// expression. Patterns computed at evaluation are compiled on first use
+	// and kept until the pattern changes.
+	re *regexp.Regexp
+
+	mu      sync.Mutex
+	pattern string
+	last    *regexp.Regexp
+}
+
+func (n *call) eval(vars activation) (interface{}, error) {
+	var args []interface{}
+
+	if n.target != nil {
+		t, err := n.target.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, t)
+	}
+
+	for _, a := range n.args {
+		v, err := a.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, v)
+	}
+
+	switch n.function {
+	case "size":
+		if len(args) == 1 {
+			return size(args[0])
+		}
+	case "string":
+		if len(args) == 1 {
+			return toString(args[0])
+		}
+	case "int":
+		if len(args) == 1 {
+			return toInt(args[0])
+		}
+	case "startsWith", "endsWith", "contains":
+		if len(args) == 2 {
+			return stringFunction(n.function, args[0], args[1])
+		}
+	case "matches":
+		if len(args) == 2 {
+			return n.matches(args[0], args[1])
+		}
+	}
+
+	return nil, fmt.Errorf("no such overload: %s with %d arguments", n.function, len(args))
+}
+
+func (n *call) matches(target, arg interface{}) (interface{}, error) {
+	s, ok := target.(string)
+	if !ok {
+		return nil, fmt.Errorf("no such overload: %s.matches()", typeName(target))
+	}
+
+	if n.re != nil {
+		return n.re.MatchString(s), nil
+	}
+
+	pattern, ok := arg.(string)
+	if !ok {
+		return nil, fmt.Errorf("no such overload: string.matches(%s)", typeName(arg))
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.last == nil || n.pattern != pattern {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		n.pattern, n.last = pattern, re
+	}
+
+	return n.last.MatchString(s), nil
+}
+
+// comprehension implements the exists and all macros over lists and the
+// keys of maps.
+type comprehension struct {
+	all       bool
+	target    node
+	variable  string
+	predicate node
+}
+
+func (n *comprehension) eval(vars activation) (interface{}, error) {
+	t, err := n.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []interface{}
+
+	switch c := t.(type) {
+	case []interface{}:
+		items = c
+	case map[string]interface{}:
+		for k := range c {
+			items = append(items, k)
+		}
+	default:
+		return nil, fmt.Errorf("%s can't be iterated", typeName(t))
+	}
+
+	scoped := activation{}
+	for k, v := range vars {
+		scoped[k] = v
+	}
+
+	for _, item := range items {
+		scoped[n.variable] = item
+
+		ok, err := evalBool(n.predicate, scoped)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok != n.all {
+			return ok, nil
+		}
+	}
+
+	return n.all, nil
+}
+
+func evalBool(n node, vars activation) (bool, error) {
+	v, err := n.eval(vars)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected bool, got %s", typeName(v))
+	}
+
+	return b, nil
+}
+
+func equal(l, r interface{}) bool {
+	if lf, ok := toFloat(l); ok {
+		if rf, ok := toFloat(r); ok {
+			return lf == rf
+		}
+	}
+
+	return reflect.DeepEqual(l, r)
+}
+
+func compare(l, r interface{}) (int, error) {
+	if lf, ok := toFloat(l); ok {
+		if rf, ok := toFloat(r); ok {
+			switch {
+			case lf < rf:
+				return -1, nil
+			case lf > rf:
+				return 1, nil
+			}
+
+			return 0, nil
+		}
+	}
+
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			return strings.Compare(ls, rs), nil
+		}
+	}
+
+	return 0, fmt.Errorf("no such overload: %s < %s", typeName(l), typeName(r))
+}
+
+func contains(container, v interface{}) (interface{}, error) {
+	switch c := container.(type) {
+	case []interface{}:
+		for _, item := range c {
+			if equal(item, v) {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	case map[string]interface{}:
+		s, ok := v.(string)
+		if !ok {
+			return false, nil
+		}
+
+		_, ok = c[s]
+
+		return ok, nil
+	}
+
+	return nil, fmt.Errorf("no such overload: %s in %s", typeName(v), typeName(container))
+}
+
+func arithmetic(op string, l, r interface{}) (interface{}, error) {
+	if li, ok := l.(int64); ok {
+		if ri, ok := r.(int64); ok {
+			switch op {
+			case "+":
+				if ri > 0 && li > math.MaxInt64-ri || ri < 0 && li < math.MinInt64-ri {
+					return nil, fmt.Errorf("integer overflow: %d + %d", li, ri)
+				}
+
+				return li + ri, nil
+			case "-":
+				if ri < 0 && li > math.MaxInt64+ri || ri > 0 && li < math.MinInt64+ri {
+					return nil, fmt.Errorf("integer overflow: %d - %d", li, ri)
+				}
+
+				return li - ri, nil
+			case "*":
+				if li != 0 && ri != 0 && (li*ri/ri != li || li == -1 && ri == math.MinInt64 || ri == -1 && li == math.MinInt64) {
+					return nil, fmt.Errorf("integer overflow: %d * %d", li, ri)
+				}
+
+				return li * ri, nil
+			case "/", "%":
+				if ri == 0 {
+					return nil, fmt.Errorf("division by zero")
+				}
+
+				if li == math.MinInt64 && ri == -1 {
+					return nil, fmt.Errorf("integer overflow: %d %s %d", li, op, ri)
+				}
+
+				if op == "/" {
+					return li / ri, nil
+				}
+
+				return li % ri, nil
+			}
+		}
+	}
+
+	if lf, ok := toFloat(l); ok {
+		if rf, ok := toFloat(r); ok {
+			switch op {
+			case "+":
+				return lf + rf, nil
+			case "-":
+				return lf - rf, nil
+			case "*":
+				return lf * rf, nil
+			case "/":
+				return lf / rf, nil
+			}
+		}
+	}
+
+	if op == "+" {
+		switch lv := l.(type) {
+		case string:
+			if rs, ok := r.(string); ok {
+				return lv + rs, nil
+			}
+		case []interface{}:
+			if rl, ok := r.([]interface{}); ok {
+				return append(append([]interface{}{}, lv...), rl...), nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no such overload: %s %s %s", typeName(l), op, typeName(r))
+}
+
+func size(v interface{}) (interface{}, error) {
+	switch c := v.(type) {
+	case string:
+		return int64(len([]rune(c))), nil
+	case []interface{}:
+		return int64(len(c)), nil
+	case map[string]interface{}:
+		return int64(len(c)), nil
+	}
+
+	return nil, fmt.Errorf("no such overload: size(%s)", typeName(v))
+}
+
+func toString(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case string:
+		return x, nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	}
+
+	return nil, fmt.Errorf("no such overload: string(%s)", typeName(v))
+}
+
+func toInt(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case int64:
+		return x, nil
+	case float64:
+		if math.IsNaN(x) || x < math.MinInt64 || x >= math.MaxInt64 {
+			return nil, fmt.Errorf("integer overflow: int(%v)", x)
+		}
+
+		return int64(x), nil
+	case string:
+		return strconv.ParseInt(x, 10, 64)
+	}
+
+	return nil, fmt.Errorf("no such overload: int(%s)", typeName(v))
+}
+
+func stringFunction(function string, target, arg interface{}) (interface{}, error) {
+	s, ok := target.(string)
+	if !ok {
+		return nil, fmt.Errorf("no such overload: %s.%s()", typeName(target), function)
+	}
+
+	a, ok := arg.(string)
+	if !ok {
+		return nil, fmt.Errorf("no such overload: string.%s(%s)", function, typeName(arg))
+	}
+
+	switch function {
+	case "startsWith":
+		return strings.HasPrefix(s, a), nil
+	case "endsWith":
+		return strings.HasSuffix(s, a), nil
+	}
+
+	return strings.Contains(s, a), nil
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+
+	return 0, false
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,605 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cel
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// operators sorted so the longest ones are matched first.
+var operators = []string{
+	"&&", "||", "==", "!=", "<=", ">=",
+	"<", ">", "!", "+", "-", "*", "/", "%", "?", ":", ".", ",", "(", ")", "[", "]", "{", "}",
+}
+
+// tokenize splits the source in tokens, positions are byte offsets.
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+
+	// at returns the rune at i and its width, zero at the end
+	at := func(i int) (rune, int) {
+		if i >= len(src) {
+			return 0, 0
+		}
+
+		return utf8.DecodeRuneInString(src[i:])
+	}
+
+	for i := 0; i < len(src); {
+		c, w := at(i)
+		if c == utf8.RuneError && w == 1 {
+			return nil, fmt.Errorf("invalid UTF-8 at %d", i)
+		}
+
+		switch {
+		case unicode.IsSpace(c):
+			i += w
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for r, w := at(i); w > 0 && (r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)); r, w = at(i) {
+				i += w
+			}
+
+			if r, _ := at(i); (r == '"' || r == '\'') && strings.Trim(src[start:i], "rRbB") == "" {
+				return nil, fmt.Errorf("raw and bytes strings are not supported at %d", start)
+			}
+
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:i], pos: start})
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+
+			if i < len(src) && (src[i] == 'u' || src[i] == 'U') {
+				return nil, fmt.Errorf("unsigned integers are not supported at %d", start)
+			}
+
+			tokens = append(tokens, token{kind: tokNumber, text: src[start:i], pos: start})
+		case c == '"' || c == '\'':
+			start := i
+			if strings.HasPrefix(src[i:], strings.Repeat(string(c), 3)) {
+				return nil, fmt.Errorf("triple-quoted strings are not supported at %d", start)
+			}
+
+			i += w
+
+			var sb strings.Builder
+
+			for {
+				r, w := at(i)
+				if w == 0 {
+					return nil, fmt.Errorf("unterminated string at %d", start)
+				}
+
+				if r == utf8.RuneError && w == 1 {
+					return nil, fmt.Errorf("invalid UTF-8 at %d", i)
+				}
+
+				i += w
+
+				if r == c {
+					break
+				}
+
+				if r == '\\' {
+					if r, w = at(i); w == 0 {
+						return nil, fmt.Errorf("unterminated string at %d", start)
+					}
+
+					if r == utf8.RuneError && w == 1 {
+						return nil, fmt.Errorf("invalid UTF-8 at %d", i)
+					}
+
+					i += w
+				}
+
+				sb.WriteRune(r)
+			}
+
+			tokens = append(tokens, token{kind: tokString, text: sb.String(), pos: start})
+		default:
+			matched := false
+
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+					i += len(op)
+					matched = true
+
+					break
+				}
+			}
+
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	scope  map[string]bool
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		return fmt.Errorf("expected %q at %d, found %q", op, t.pos, t.text)
+	}
+
+	return nil
+}
+
+func (p *parser) parseExpr() (node, error) {
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.accept("?") {
+		return cond, nil
+	}
+
+	then, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+
+	otherwise, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	return &conditional{cond: cond, then: then, otherwise: otherwise}, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &logical{op: "||", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseRelation()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("&&") {
+		right, err := p.parseRelation()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &logical{op: "&&", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseRelation() (node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+
+		op := ""
+		if t.kind == tokOp {
+			switch t.text {
+			case "==", "!=", "<", "<=", ">", ">=":
+				op = t.text
+			}
+		} else if t.kind == tokIdent && t.text == "in" {
+			op = "in"
+		}
+
+		if op == "" {
+			return left, nil
+		}
+
+		p.next()
+
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binary{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseAdditive() (node, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "+" && t.text != "-") {
+			return left, nil
+		}
+
+		p.next()
+
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binary{op: t.text, left: left, right: right}
+	}
+}
+
+func (p *parser) parseMultiplicative() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "*" && t.text != "/" && t.text != "%") {
+			return left, nil
+		}
+
+		p.next()
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binary{op: t.text, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &unary{op: "!", operand: operand}, nil
+	}
+
+	if p.accept("-") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &unary{op: "-", operand: operand}, nil
+	}
+
+	return p.parseMember()
+}
+
+func (p *parser) parseMember() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokIdent {
+				return nil, fmt.Errorf("expected field name at %d", t.pos)
+			}
+
+			if !p.accept("(") {
+				n = &selectField{operand: n, field: t.text}
+				continue
+			}
+
+			if t.text == "exists" || t.text == "all" {
+				n, err = p.parseComprehension(t.text, n)
+			} else {
+				n, err = p.parseMethod(t, n)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		case p.accept("["):
+			key, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+
+			n = &index{operand: n, key: key}
+		default:
+			return n, nil
+		}
+	}
+}
+
+// parseMethod parses a method call, the opening parenthesis is already
+// consumed. The patterns of matches are compiled when they are literals.
+func (p *parser) parseMethod(name token, target node) (node, error) {
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	switch name.text {
+	case "size":
+		if len(args) != 0 {
+			return nil, fmt.Errorf("size() expects no arguments at %d", name.pos)
+		}
+
+		return &call{function: name.text, target: target}, nil
+	case "startsWith", "endsWith", "contains", "matches":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() expects one argument at %d", name.text, name.pos)
+		}
+	default:
+		return nil, fmt.Errorf("undeclared function %q at %d", name.text, name.pos)
+	}
+
+	c := &call{function: name.text, target: target, args: args}
+
+	if l, ok := args[0].(*literal); ok && name.text == "matches" {
+		pattern, ok := l.value.(string)
+		if !ok {
+			return nil, fmt.Errorf("matches() expects a string pattern at %d", name.pos)
+		}
+
+		if c.re, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern at %d: %v", name.pos, err)
+		}
+	}
+
+	return c, nil
+}
+
+// parseComprehension parses the exists and all macros, the opening
+// parenthesis is already consumed.
+func (p *parser) parseComprehension(macro string, target node) (node, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return nil, fmt.Errorf("expected variable name at %d", t.pos)
+	}
+
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+
+	shadowed := p.scope[t.text]
+	p.scope[t.text] = true
+
+	predicate, err := p.parseExpr()
+
+	p.scope[t.text] = shadowed
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	return &comprehension{all: macro == "all", target: target, variable: t.text, predicate: predicate}, nil
+}
+
+func (p *parser) parseArgs() ([]node, error) {
+	var args []node
+
+	if p.accept(")") {
+		return args, nil
+	}
+
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, arg)
+
+		if p.accept(")") {
+			return args, nil
+		}
+
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokString:
+		return &literal{value: t.text}, nil
+	case tokNumber:
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return &literal{value: i}, nil
+		}
+
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+
+		return &literal{value: f}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literal{value: true}, nil
+		case "false":
+			return &literal{value: false}, nil
+		case "null":
+			return &literal{value: nil}, nil
+		}
+
+		if p.accept("(") {
+			return p.parseFunction(t)
+		}
+
+		if !p.scope[t.text] {
+			return nil, fmt.Errorf("undeclared reference to %q at %d", t.text, t.pos)
+		}
+
+		return &ident{name: t.text}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			n, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+
+			return n, p.expect(")")
+		case "[":
+			var items []node
+
+			for !p.accept("]") {
+				if len(items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+
+				item, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+
+				items = append(items, item)
+			}
+
+			return &list{items: items}, nil
+		case "{":
+			return nil, fmt.Errorf("map literals are not supported at %d", t.pos)
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+// parseFunction parses a global function call, the opening parenthesis is
+// already consumed.
+func (p *parser) parseFunction(name token) (node, error) {
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	switch name.text {
+	case "has":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("has() expects one argument at %d", name.pos)
+		}
+
+		sel, ok := args[0].(*selectField)
+		if !ok {
+			return nil, fmt.Errorf("has() argument must be a field selection at %d", name.pos)
+		}
+
+		return &has{operand: sel.operand, field: sel.field}, nil
+	case "size", "string", "int":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s() expects one argument at %d", name.text, name.pos)
+		}
+
+		return &call{function: name.text, args: args}, nil
+	}
+
+	if name.text == "type" {
+		return nil, fmt.Errorf("type() is not supported at %d", name.pos)
+	}
+
+	return nil, fmt.Errorf("undeclared function %q at %d", name.text, name.pos)
+}
@@ -0,0 +1,108 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gcp builds the GCS clients used by the operator for the
+// credentials referenced by the resources.
+package gcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+type cachedClient struct {
+	hash   string
+	client *storage.Client
+}
+
+// ClientCache keeps a storage client per credentials. Clients are stored
+// under a key identifying where the credentials come from, and replaced
+// when the credentials stored under the key change.
+type ClientCache struct {
+	opts []option.ClientOption
+
+	mu      sync.Mutex
+	clients map[string]cachedClient
+}
+
+// NewClientCache returns an empty cache, the options are used to create
+// every client.
+func NewClientCache(opts ...option.ClientOption) *ClientCache {
+	return &ClientCache{opts: opts, clients: map[string]cachedClient{}}
+}
+
+// Get returns the client for the credentials stored under key, it's created
+// on the first use and every time the credentials change. The credentials
+// are a JSON key, or the operator credentials when empty, and impersonate,
+// when not empty, is a service account impersonated with them.
+func (c *ClientCache) Get(ctx context.Context, key string, credentials []byte, impersonate string) (*storage.Client, error) {
+	h := sha256.New()
+	h.Write(credentials)
+	h.Write([]byte{0})
+	h.Write([]byte(impersonate))
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.clients[key]; ok && e.hash == hash {
+		return e.client, nil
+	}
+
+	var auth []option.ClientOption
+	if len(credentials) > 0 {
+		auth = append(auth, option.WithCredentialsJSON(credentials))
+	}
+
+	if impersonate != "" {
+		ts, err := ImpersonatedTokenSource(ctx, impersonate, auth...)
+		if err != nil {
+			return nil, err
+		}
+
+		auth = []option.ClientOption{option.WithTokenSource(ts)}
+	}
+
+	client, err := storage.NewClient(ctx, append(auth, c.opts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	// replaced clients are not closed, reconciles in flight may still use
+	// them.
+	c.clients[key] = cachedClient{hash: hash, client: client}
+
+	return client, nil
+}
+
+// Invalidate removes the clients stored under the keys starting with prefix.
+func (c *ClientCache) Invalidate(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.clients {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.clients, k)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func credentials(token string) []byte {
+	return []byte(fmt.Sprintf(`{"type": "authorized_user", "client_id": "id", "client_secret": "secret", "refresh_token": %q}`, token))
+}
+
+func TestClientCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewClientCache()
+
+	c1, err := c.Get(ctx, "secret/ns/a/key", credentials("one"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c2, _ := c.Get(ctx, "secret/ns/a/key", credentials("one"), ""); c2 != c1 {
+		t.Error("expected the cached client")
+	}
+
+	c3, err := c.Get(ctx, "secret/ns/a/key", credentials("two"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c3 == c1 {
+		t.Error("expected a new client when the credentials change")
+	}
+
+	c5, err := c.Get(ctx, "secret/ns/a/key", credentials("two"), "tenant@project.iam.gserviceaccount.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c5 == c3 {
+		t.Error("expected a new client when the impersonated service account changes")
+	}
+
+	c.Invalidate("secret/ns/a/")
+
+	if c4, _ := c.Get(ctx, "secret/ns/a/key", credentials("two"), ""); c4 == c3 || c4 == c5 {
+		t.Error("expected a new client after the invalidation")
+	}
+}
@@ -0,0 +1,84 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/oauth2"
+	iamcredentials "google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
+)
+
+// impersonatedTokenLifetime is the lifetime requested for the tokens of the
+// impersonated service accounts.
+const impersonatedTokenLifetime = time.Hour
+
+// impersonationTimeout bounds the requests minting the tokens of the
+// impersonated service accounts.
+const impersonationTimeout = 30 * time.Second
+
+type impersonatedTokenSource struct {
+	service *iamcredentials.Service
+	name    string
+}
+
+// ImpersonatedTokenSource returns a token source minting short-lived tokens
+// of the target service account with the credentials of opts, the caller
+// needs the token creator role on the target. Tokens are reused until they
+// expire.
+func ImpersonatedTokenSource(ctx context.Context, target string, opts ...option.ClientOption) (oauth2.TokenSource, error) {
+	service, err := iamcredentials.NewService(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := &impersonatedTokenSource{
+		service: service,
+		name:    fmt.Sprintf("projects/-/serviceAccounts/%s", target),
+	}
+
+	return oauth2.ReuseTokenSource(nil, ts), nil
+}
+
+// Token implements oauth2.TokenSource, the token source outlives the context
+// it was created with so the requests are bounded by impersonationTimeout.
+func (s *impersonatedTokenSource) Token() (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), impersonationTimeout)
+	defer cancel()
+
+	req := &iamcredentials.GenerateAccessTokenRequest{
+		Scope:    []string{storage.ScopeFullControl},
+		Lifetime: fmt.Sprintf("%ds", int(impersonatedTokenLifetime.Seconds())),
+	}
+
+	resp, err := s.service.Projects.ServiceAccounts.GenerateAccessToken(s.name, req).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to impersonate %s: %v", s.name, err)
+	}
+
+	expiry, err := time.Parse(time.RFC3339, resp.ExpireTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expire time of the token of %s: %v", s.name, err)
+	}
+
+	return &oauth2.Token{AccessToken: resp.AccessToken, TokenType: "Bearer", Expiry: expiry}, nil
+}
@@ -0,0 +1,66 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+)
+
+func TestImpersonatedTokenSource(t *testing.T) {
+	calls := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		if !strings.HasSuffix(r.URL.Path, "/projects/-/serviceAccounts/tenant@project.iam.gserviceaccount.com:generateAccessToken") {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+
+		fmt.Fprintf(w, `{"accessToken": "token-%d", "expireTime": %q}`, calls, time.Now().Add(time.Hour).Format(time.RFC3339))
+	}))
+	defer srv.Close()
+
+	ts, err := ImpersonatedTokenSource(context.Background(), "tenant@project.iam.gserviceaccount.com",
+		option.WithEndpoint(srv.URL+"/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		tok, err := ts.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tok.AccessToken != "token-1" {
+			t.Errorf("Token() = %s, want token-1", tok.AccessToken)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("expected one token request, got %d", calls)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import (
+	"crypto/tls"
+	"net/http"
+	"os"
+	"strings"
+
+	"google.golang.org/api/option"
+)
+
+// EmulatorHostEnv is the environment variable with the host of a GCS
+// emulator, as honoured by the official clients.
+const EmulatorHostEnv = "STORAGE_EMULATOR_HOST"
+
+// jsonAPIPath is the path of the GCS JSON API in an endpoint.
+const jsonAPIPath = "/storage/v1/"
+
+// ClientOptions returns the options of the storage clients for the endpoint,
+// the emulator host of the environment is used when endpoint is empty.
+// Emulators are used without authentication. When insecure is true the TLS
+// certificate of the endpoint is not verified, and no credentials are sent.
+// The options replace the credentials, clients with credentials of their own
+// use EndpointOptions.
+func ClientOptions(endpoint string, insecure bool) []option.ClientOption {
+	endpoint, emulator := resolveEndpoint(endpoint)
+
+	var opts []option.ClientOption
+
+	if emulator {
+		opts = append(opts, option.WithoutAuthentication())
+	}
+
+	if endpoint != "" {
+		opts = append(opts, option.WithEndpoint(JSONAPIEndpoint(endpoint)))
+	}
+
+	if insecure {
+		opts = append(opts, option.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}))
+	}
+
+	return opts
+}
+
+// EndpointOptions returns the options selecting the endpoint of the storage
+// clients, the emulator host of the environment is used when endpoint is
+// empty. Unlike ClientOptions they don't touch the authentication nor the
+// transport, so the credentials of the client are honoured.
+func EndpointOptions(endpoint string) []option.ClientOption {
+	endpoint, _ = resolveEndpoint(endpoint)
+	if endpoint == "" {
+		return nil
+	}
+
+	return []option.ClientOption{option.WithEndpoint(JSONAPIEndpoint(endpoint))}
+}
+
+// resolveEndpoint returns the endpoint, or the emulator host of the
+// environment when empty, and whether it's the emulator host.
+func resolveEndpoint(endpoint string) (string, bool) {
+	if endpoint != "" {
+		return endpoint, false
+	}
+
+	host := os.Getenv(EmulatorHostEnv)
+	if host == "" {
+		return "", false
+	}
+
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+
+	return host, true
+}
+
+// JSONAPIEndpoint returns the endpoint of the GCS JSON API in the server,
+// the API path is added when missing.
+func JSONAPIEndpoint(server string) string {
+	server = strings.TrimSuffix(server, "/")
+	if strings.HasSuffix(server, strings.TrimSuffix(jsonAPIPath, "/")) {
+		return server + "/"
+	}
+
+	return server + jsonAPIPath
+}
+
+// PubSubEmulatorHostEnv is the environment variable with the host of a
+// Pub/Sub emulator, as honoured by the official clients.
+const PubSubEmulatorHostEnv = "PUBSUB_EMULATOR_HOST"
+
+// PubSubClientOptions returns the options of the Pub/Sub clients for the
+// endpoint, the emulator host of the environment is used when endpoint is
+// empty. Emulators are used without authentication.
+func PubSubClientOptions(endpoint string) []option.ClientOption {
+	var opts []option.ClientOption
+
+	if endpoint == "" {
+		if host := os.Getenv(PubSubEmulatorHostEnv); host != "" {
+			endpoint = "http://" + host
+			opts = append(opts, option.WithoutAuthentication())
+		}
+	}
+
+	if endpoint != "" {
+		opts = append(opts, option.WithEndpoint(strings.TrimSuffix(endpoint, "/")+"/"))
+	}
+
+	return opts
+}
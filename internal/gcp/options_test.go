@@ -0,0 +1,97 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+func TestJSONAPIEndpoint(t *testing.T) {
+	for server, want := range map[string]string{
+		"http://localhost:4443":              "http://localhost:4443/storage/v1/",
+		"http://localhost:4443/":             "http://localhost:4443/storage/v1/",
+		"https://gcs.example.com/storage/v1": "https://gcs.example.com/storage/v1/",
+	} {
+		if got := JSONAPIEndpoint(server); got != want {
+			t.Errorf("JSONAPIEndpoint(%s) = %s, want %s", server, got, want)
+		}
+	}
+}
+
+func TestClientOptionsEmulatorHost(t *testing.T) {
+	var path string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		http.Error(w, `{"error": {"code": 404}}`, http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	os.Setenv(EmulatorHostEnv, strings.TrimPrefix(srv.URL, "http://"))
+	defer os.Unsetenv(EmulatorHostEnv)
+
+	client, err := storage.NewClient(context.Background(), ClientOptions("", false)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Bucket("assets").Attrs(context.Background()); err != storage.ErrBucketNotExist {
+		t.Errorf("Attrs() = %v, want ErrBucketNotExist", err)
+	}
+
+	if path != "/storage/v1/b/assets" {
+		t.Errorf("request path = %s, want /storage/v1/b/assets", path)
+	}
+}
+
+func TestEndpointOptions(t *testing.T) {
+	if opts := EndpointOptions(""); len(opts) != 0 {
+		t.Errorf("options without endpoint nor emulator = %v, want none", opts)
+	}
+
+	os.Setenv(EmulatorHostEnv, "localhost:4443")
+	defer os.Unsetenv(EmulatorHostEnv)
+
+	if opts := EndpointOptions(""); len(opts) != 1 {
+		t.Errorf("options of the emulator = %v, want the endpoint only", opts)
+	}
+
+	if opts := EndpointOptions("https://gcs.example.com"); len(opts) != 1 {
+		t.Errorf("options of the endpoint = %v, want the endpoint only", opts)
+	}
+}
+
+func TestPubSubClientOptions(t *testing.T) {
+	if opts := PubSubClientOptions(""); len(opts) != 0 {
+		t.Errorf("options without endpoint nor emulator = %v, want none", opts)
+	}
+
+	os.Setenv(PubSubEmulatorHostEnv, "localhost:8085")
+	defer os.Unsetenv(PubSubEmulatorHostEnv)
+
+	if opts := PubSubClientOptions(""); len(opts) != 2 {
+		t.Errorf("options of the emulator = %v, want endpoint and no authentication", opts)
+	}
+}
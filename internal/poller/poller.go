@@ -0,0 +1,253 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package poller keeps the attributes of the buckets of the projects in a
+// cache refreshed by listing the buckets of each project on an interval.
+// Reads are served from the cache and the buckets changed outside of the
+// operator are reported, so drift is detected with a listing per project
+// instead of a read per bucket.
+package poller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+)
+
+// pollJitter spreads the passes, up to 10% of the interval is added.
+const pollJitter = 0.1
+
+type entry struct {
+	project string
+	attrs   *backend.BucketAttrs
+}
+
+// Poller lists the buckets of the projects returned by Projects every
+// Interval and calls Changed with the name of each bucket whose
+// metageneration changed, or which disappeared, since the previous pass.
+// Projects and Changed must be set before the poller is started.
+type Poller struct {
+	Backend  backend.BucketBackend
+	Interval time.Duration
+	Log      logr.Logger
+
+	// Projects returns the projects to poll.
+	Projects func(ctx context.Context) ([]string, error)
+
+	// Changed is called with the name of each bucket changed outside of
+	// the operator.
+	Changed func(name string)
+
+	mu      sync.RWMutex
+	buckets map[string]*entry
+}
+
+// New returns a poller listing the buckets of be every interval.
+func New(be backend.BucketBackend, interval time.Duration, log logr.Logger) *Poller {
+	return &Poller{
+		Backend:  be,
+		Interval: interval,
+		Log:      log,
+		buckets:  map[string]*entry{},
+	}
+}
+
+// Start implements manager.Runnable, it polls until stop is closed.
+func (p *Poller) Start(stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	wait.JitterUntil(func() {
+		if err := p.Poll(ctx); err != nil {
+			p.Log.Error(err, "unable to poll the buckets")
+		}
+	}, p.Interval, pollJitter, true, stop)
+
+	return nil
+}
+
+// Poll runs a pass, listing the buckets of every project. The buckets of
+// the projects that fail to be listed are kept as they were.
+func (p *Poller) Poll(ctx context.Context) error {
+	projects, err := p.Projects(ctx)
+	if err != nil {
+		return err
+	}
+
+	listed := map[string]*entry{}
+	polled := map[string]bool{}
+
+	for _, project := range projects {
+		err := p.Backend.List(ctx, project, func(a *backend.BucketAttrs) error {
+			listed[a.Name] = &entry{project: project, attrs: a}
+
+			return nil
+		})
+		if err != nil {
+			p.Log.Error(err, "unable to list the buckets", "project", project)
+
+			continue
+		}
+
+		polled[project] = true
+	}
+
+	var changed []string
+
+	p.mu.Lock()
+
+	for name, e := range listed {
+		if !polled[e.project] {
+			continue
+		}
+
+		old, ok := p.buckets[name]
+
+		// updated by the operator while the project was listed
+		if ok && old.attrs.Created.Equal(e.attrs.Created) && old.attrs.Metageneration > e.attrs.Metageneration {
+			continue
+		}
+
+		if ok && old.attrs.Metageneration != e.attrs.Metageneration {
+			changed = append(changed, name)
+		}
+
+		p.buckets[name] = e
+	}
+
+	for name, e := range p.buckets {
+		if _, ok := listed[name]; !ok && polled[e.project] {
+			delete(p.buckets, name)
+			changed = append(changed, name)
+		}
+	}
+
+	p.mu.Unlock()
+
+	for _, name := range changed {
+		p.Changed(name)
+	}
+
+	return nil
+}
+
+// Wrap returns a backend serving the reads of the bucket attributes from
+// the cache, the buckets not cached are read from be. The updates made
+// through it are recorded in the cache, so they are not reported as
+// changes. A nil poller returns be.
+func (p *Poller) Wrap(be backend.BucketBackend) backend.BucketBackend {
+	if p == nil {
+		return be
+	}
+
+	return &cachedBackend{BucketBackend: be, p: p}
+}
+
+func (p *Poller) get(name string) *backend.BucketAttrs {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	e, ok := p.buckets[name]
+	if !ok {
+		return nil
+	}
+
+	c := *e.attrs
+
+	return &c
+}
+
+// set records the attributes of a cached bucket updated by the operator.
+func (p *Poller) set(a *backend.BucketAttrs) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e, ok := p.buckets[a.Name]; ok {
+		p.buckets[a.Name] = &entry{project: e.project, attrs: a}
+	}
+}
+
+// Invalidate drops the cached attributes of the bucket, e.g. when it's
+// known to have changed, it's read from the backend until the next pass. A
+// nil poller does nothing.
+func (p *Poller) Invalidate(name string) {
+	if p == nil {
+		return
+	}
+
+	p.forget(name)
+}
+
+// forget drops a bucket created or deleted by the operator, it's read from
+// the backend until the next pass.
+func (p *Poller) forget(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.buckets, name)
+}
+
+type cachedBackend struct {
+	backend.BucketBackend
+
+	p *Poller
+}
+
+func (b *cachedBackend) Get(ctx context.Context, name string) (*backend.BucketAttrs, error) {
+	if a := b.p.get(name); a != nil {
+		return a, nil
+	}
+
+	return b.BucketBackend.Get(ctx, name)
+}
+
+func (b *cachedBackend) Create(ctx context.Context, project string, attrs *backend.BucketAttrs) error {
+	b.p.forget(attrs.Name)
+
+	return b.BucketBackend.Create(ctx, project, attrs)
+}
+
+func (b *cachedBackend) Update(ctx context.Context, name string, uattrs backend.BucketAttrsToUpdate) (*backend.BucketAttrs, error) {
+	a, err := b.BucketBackend.Update(ctx, name, uattrs)
+	if err != nil {
+		// a precondition failure means the cached attributes are outdated
+		b.p.forget(name)
+
+		return nil, err
+	}
+
+	c := *a
+	b.p.set(&c)
+
+	return a, nil
+}
+
+func (b *cachedBackend) Delete(ctx context.Context, name string) error {
+	b.p.forget(name)
+
+	return b.BucketBackend.Delete(ctx, name)
+}
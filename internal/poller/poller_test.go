@@ -0,0 +1,150 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poller
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend/fake"
+)
+
+func newTestPoller(be backend.BucketBackend) (*Poller, *[]string) {
+	var changed []string
+
+	p := New(be, 0, logf.NullLogger{})
+	p.Projects = func(context.Context) ([]string, error) { return []string{"p1", "p2"}, nil }
+	p.Changed = func(name string) { changed = append(changed, name) }
+
+	return p, &changed
+}
+
+func TestPollReportsExternalChanges(t *testing.T) {
+	ctx := context.Background()
+	be := fake.New()
+
+	for _, b := range []struct{ project, name string }{{"p1", "a"}, {"p1", "b"}, {"p2", "c"}} {
+		if err := be.Create(ctx, b.project, &backend.BucketAttrs{Name: b.name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, changed := newTestPoller(be)
+
+	if err := p.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*changed) != 0 {
+		t.Fatalf("changes reported by the first pass: %v", *changed)
+	}
+
+	uattrs := backend.BucketAttrsToUpdate{}
+	uattrs.SetLabel("team", "storage")
+
+	// an update made by the operator
+	if _, err := p.Wrap(be).Update(ctx, "a", uattrs); err != nil {
+		t.Fatal(err)
+	}
+
+	// updates made outside of the operator
+	if _, err := be.Update(ctx, "b", uattrs); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := be.Delete(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(*changed)
+
+	if want := []string{"b", "c"}; !reflect.DeepEqual(*changed, want) {
+		t.Errorf("changed buckets = %v, want %v", *changed, want)
+	}
+}
+
+func TestPollKeepsFailedProjects(t *testing.T) {
+	ctx := context.Background()
+	be := fake.New()
+
+	if err := be.Create(ctx, "p1", &backend.BucketAttrs{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, changed := newTestPoller(be)
+
+	if err := p.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	be.SetError("List", errors.New("unavailable"))
+
+	if err := p.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*changed) != 0 {
+		t.Errorf("changes reported for projects not listed: %v", *changed)
+	}
+
+	if a := p.get("a"); a == nil {
+		t.Errorf("bucket of a project not listed dropped from the cache")
+	}
+}
+
+func TestWrapServesReadsFromCache(t *testing.T) {
+	ctx := context.Background()
+	be := fake.New()
+
+	if err := be.Create(ctx, "p1", &backend.BucketAttrs{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, _ := newTestPoller(be)
+
+	if err := p.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	be.SetError("Get", errors.New("unexpected read"))
+
+	cached := p.Wrap(be)
+
+	if _, err := cached.Get(ctx, "a"); err != nil {
+		t.Errorf("Get() of a cached bucket = %v, want it served from the cache", err)
+	}
+
+	if _, err := cached.Get(ctx, "missing"); err == nil {
+		t.Errorf("Get() of a bucket not cached wasn't read from the backend")
+	}
+
+	var nilPoller *Poller
+	if nilPoller.Wrap(be) != be {
+		t.Errorf("nil poller wrapped the backend")
+	}
+}
@@ -0,0 +1,121 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package throttle limits the rate of the operations on the storage service
+// per project, GCS allows roughly one bucket creation or deletion every two
+// seconds in each project.
+package throttle
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// reservationExpiry is how long a reserved token can be used once it's
+// due. A caller coming back later is not retrying the throttled operation
+// and has to take a new token.
+const reservationExpiry = time.Minute
+
+// Limiter keeps a token bucket per project. Callers don't block waiting for
+// a token: the token is reserved and the caller is told when to come back
+// to use it, so reconciles are requeued instead of holding a worker.
+type Limiter struct {
+	limit rate.Limit
+	burst int
+	now   func() time.Time
+
+	mu       sync.Mutex
+	projects map[string]*rate.Limiter
+
+	// reservations keeps the time the token reserved by each key can be
+	// used.
+	reservations map[string]time.Time
+}
+
+// New returns a limiter allowing limit operations per second in each
+// project with bursts of burst operations.
+func New(limit float64, burst int) *Limiter {
+	return &Limiter{
+		limit:        rate.Limit(limit),
+		burst:        burst,
+		now:          time.Now,
+		projects:     map[string]*rate.Limiter{},
+		reservations: map[string]time.Time{},
+	}
+}
+
+// Reserve takes a token of the project for the operation identified by key
+// and returns zero when it can run now. Otherwise the token is reserved and
+// the time to wait before calling Reserve again with the same key, when the
+// reserved token is used, is returned. A reserved token not used within
+// reservationExpiry of being due is dropped.
+//
+// A nil limiter allows every operation.
+func (l *Limiter) Reserve(project, key string) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	for k, at := range l.reservations {
+		if now.Sub(at) > reservationExpiry {
+			delete(l.reservations, k)
+		}
+	}
+
+	if at, ok := l.reservations[key]; ok {
+		if now.Before(at) {
+			return at.Sub(now)
+		}
+
+		delete(l.reservations, key)
+
+		return 0
+	}
+
+	pl, ok := l.projects[project]
+	if !ok {
+		pl = rate.NewLimiter(l.limit, l.burst)
+		l.projects[project] = pl
+	}
+
+	delay := pl.ReserveN(now, 1).DelayFrom(now)
+	if delay > 0 {
+		l.reservations[key] = now.Add(delay)
+	}
+
+	return delay
+}
+
+// Forget drops the token reserved by key, e.g. when the operation is no
+// longer needed. The token is not returned to the project.
+func (l *Limiter) Forget(key string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.reservations, key)
+}
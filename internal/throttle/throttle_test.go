@@ -0,0 +1,101 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReserve(t *testing.T) {
+	now := time.Unix(0, 0)
+
+	l := New(0.5, 1)
+	l.now = func() time.Time { return now }
+
+	if d := l.Reserve("p1", "a"); d != 0 {
+		t.Fatalf("first operation delay = %s, want 0", d)
+	}
+
+	if d := l.Reserve("p1", "b"); d != 2*time.Second {
+		t.Fatalf("second operation delay = %s, want 2s", d)
+	}
+
+	if d := l.Reserve("p1", "c"); d != 4*time.Second {
+		t.Fatalf("third operation delay = %s, want 4s", d)
+	}
+
+	if d := l.Reserve("p2", "d"); d != 0 {
+		t.Errorf("operation in another project delay = %s, want 0", d)
+	}
+
+	now = now.Add(time.Second)
+
+	if d := l.Reserve("p1", "b"); d != time.Second {
+		t.Errorf("early retry delay = %s, want 1s", d)
+	}
+
+	now = now.Add(time.Second)
+
+	if d := l.Reserve("p1", "b"); d != 0 {
+		t.Errorf("retry with the reserved token delay = %s, want 0", d)
+	}
+
+	l.Forget("c")
+
+	if d := l.Reserve("p1", "c"); d != 4*time.Second {
+		t.Errorf("operation after forgetting its reservation delay = %s, want 4s", d)
+	}
+}
+
+func TestReserveExpires(t *testing.T) {
+	now := time.Unix(0, 0)
+
+	l := New(0.5, 1)
+	l.now = func() time.Time { return now }
+
+	l.Reserve("p1", "a")
+
+	if d := l.Reserve("p1", "b"); d != 2*time.Second {
+		t.Fatalf("second operation delay = %s, want 2s", d)
+	}
+
+	// b never came back to use its token, a much later operation with the
+	// same key takes a new one
+	now = now.Add(time.Hour)
+
+	l.Reserve("p1", "c")
+
+	if d := l.Reserve("p1", "b"); d != 2*time.Second {
+		t.Errorf("operation after its reservation expired delay = %s, want 2s", d)
+	}
+
+	if n := len(l.reservations); n != 1 {
+		t.Errorf("reservations kept = %d, want 1", n)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+
+	if d := l.Reserve("p1", "a"); d != 0 {
+		t.Errorf("nil limiter delay = %s, want 0", d)
+	}
+
+	l.Forget("a")
+}
@@ -0,0 +1,138 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+)
+
+// Wrap returns a backend recording a span for each operation of be, named
+// after the method. The operations are only recorded when their context
+// carries a span, so the operations outside of a trace, e.g. the passes of
+// the poller, don't start traces of their own. A nil tracer returns be.
+func (t *Tracer) Wrap(be backend.BucketBackend) backend.BucketBackend {
+	if t == nil {
+		return be
+	}
+
+	return &tracedBackend{be: be, t: t}
+}
+
+type tracedBackend struct {
+	be backend.BucketBackend
+	t  *Tracer
+}
+
+func (b *tracedBackend) start(ctx context.Context, op, bucket string) (context.Context, *Span) {
+	return b.t.startClientSpan(ctx, op, String("bucket", bucket))
+}
+
+func (b *tracedBackend) Get(ctx context.Context, name string) (*backend.BucketAttrs, error) {
+	ctx, span := b.start(ctx, "Get", name)
+	a, err := b.be.Get(ctx, name)
+	span.End(err)
+
+	return a, err
+}
+
+func (b *tracedBackend) List(ctx context.Context, project string, fn func(*backend.BucketAttrs) error) error {
+	ctx, span := b.t.startClientSpan(ctx, "List", String("project", project))
+	err := b.be.List(ctx, project, fn)
+	span.End(err)
+
+	return err
+}
+
+func (b *tracedBackend) Create(ctx context.Context, project string, attrs *backend.BucketAttrs) error {
+	ctx, span := b.start(ctx, "Create", attrs.Name)
+	span.SetAttributes(String("project", project))
+	err := b.be.Create(ctx, project, attrs)
+	span.End(err)
+
+	return err
+}
+
+func (b *tracedBackend) Update(ctx context.Context, name string, uattrs backend.BucketAttrsToUpdate) (*backend.BucketAttrs, error) {
+	ctx, span := b.start(ctx, "Update", name)
+	a, err := b.be.Update(ctx, name, uattrs)
+	span.End(err)
+
+	return a, err
+}
+
+func (b *tracedBackend) Unsupported(attrs *backend.BucketAttrs) []string {
+	return b.be.Unsupported(attrs)
+}
+
+func (b *tracedBackend) Delete(ctx context.Context, name string) error {
+	ctx, span := b.start(ctx, "Delete", name)
+	err := b.be.Delete(ctx, name)
+	span.End(err)
+
+	return err
+}
+
+func (b *tracedBackend) IAMPolicy(ctx context.Context, name string) (*backend.IAMPolicy, error) {
+	ctx, span := b.start(ctx, "IAMPolicy", name)
+	p, err := b.be.IAMPolicy(ctx, name)
+	span.End(err)
+
+	return p, err
+}
+
+func (b *tracedBackend) SetIAMPolicy(ctx context.Context, name string, policy *backend.IAMPolicy) error {
+	ctx, span := b.start(ctx, "SetIAMPolicy", name)
+	err := b.be.SetIAMPolicy(ctx, name, policy)
+	span.End(err)
+
+	return err
+}
+
+func (b *tracedBackend) Notifications(ctx context.Context, name string) (map[string]*backend.Notification, error) {
+	ctx, span := b.start(ctx, "Notifications", name)
+	n, err := b.be.Notifications(ctx, name)
+	span.End(err)
+
+	return n, err
+}
+
+func (b *tracedBackend) AddNotification(ctx context.Context, name string, n *backend.Notification) (*backend.Notification, error) {
+	ctx, span := b.start(ctx, "AddNotification", name)
+	n, err := b.be.AddNotification(ctx, name, n)
+	span.End(err)
+
+	return n, err
+}
+
+func (b *tracedBackend) DeleteNotification(ctx context.Context, name, id string) error {
+	ctx, span := b.start(ctx, "DeleteNotification", name)
+	err := b.be.DeleteNotification(ctx, name, id)
+	span.End(err)
+
+	return err
+}
+
+func (b *tracedBackend) Objects(ctx context.Context, name, prefix string, fn func(*backend.ObjectAttrs) error) error {
+	ctx, span := b.start(ctx, "Objects", name)
+	err := b.be.Objects(ctx, name, prefix, fn)
+	span.End(err)
+
+	return err
+}
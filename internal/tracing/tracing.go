@@ -0,0 +1,364 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing records the spans of the reconciles and exports them to
+// an OpenTelemetry collector with the OTLP/HTTP protocol, JSON encoded.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// EndpointEnv is the environment variable of the OpenTelemetry SDKs
+// defining the endpoint of the collector.
+const EndpointEnv = "OTEL_EXPORTER_OTLP_ENDPOINT"
+
+const (
+	// flushInterval is how often the finished spans are exported.
+	flushInterval = 5 * time.Second
+
+	// maxQueued bounds the finished spans waiting to be exported, the spans
+	// finished while the queue is full are dropped.
+	maxQueued = 2048
+
+	// exportTimeout bounds the duration of an export.
+	exportTimeout = 10 * time.Second
+)
+
+// Span kinds and status codes of the OTLP protocol.
+const (
+	kindInternal = 1
+	kindClient   = 3
+
+	statusError = 2
+)
+
+// Attribute is a string attribute of a span.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// String returns an attribute.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Tracer records spans and exports them to the collector at Endpoint, the
+// base URL of its OTLP/HTTP receiver, e.g. http://otel-collector:4318. A nil
+// tracer records nothing.
+type Tracer struct {
+	Endpoint string
+	Service  string
+	Log      logr.Logger
+
+	client *http.Client
+
+	mu      sync.Mutex
+	queue   []*Span
+	dropped int
+}
+
+// New returns a tracer exporting the spans of service to endpoint.
+func New(endpoint, service string, log logr.Logger) *Tracer {
+	return &Tracer{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		Service:  service,
+		Log:      log,
+		client:   &http.Client{Timeout: exportTimeout},
+	}
+}
+
+type spanKey struct{}
+
+// StartSpan starts a span, child of the span of ctx if any, and returns a
+// context carrying it. The span must be ended.
+func (t *Tracer) StartSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := t.newSpan(ctx, name, kindInternal, attrs)
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// startClientSpan starts a span of a call to a remote service, it's only
+// recorded when ctx carries a span, e.g. the span of a reconcile.
+func (t *Tracer) startClientSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	if t == nil || spanFromContext(ctx) == nil {
+		return ctx, nil
+	}
+
+	s := t.newSpan(ctx, name, kindClient, attrs)
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (t *Tracer) newSpan(ctx context.Context, name string, kind int, attrs []Attribute) *Span {
+	s := &Span{
+		t:     t,
+		name:  name,
+		kind:  kind,
+		start: time.Now(),
+		attrs: attrs,
+	}
+
+	if parent := spanFromContext(ctx); parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		randomID(s.traceID[:])
+	}
+
+	randomID(s.spanID[:])
+
+	return s
+}
+
+func spanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+
+	return s
+}
+
+func randomID(id []byte) {
+	// it only fails when the entropy source of the system is unavailable
+	_, _ = rand.Read(id)
+}
+
+// Span is an operation of a trace. A nil span records nothing.
+type Span struct {
+	t *Tracer
+
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+
+	name       string
+	kind       int
+	start, end time.Time
+	attrs      []Attribute
+	err        error
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// End ends the span, its status is an error when err is not nil. The span
+// is exported by the next flush.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+
+	s.end = time.Now()
+	s.err = err
+
+	s.t.enqueue(s)
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.queue) >= maxQueued {
+		t.dropped++
+
+		return
+	}
+
+	t.queue = append(t.queue, s)
+}
+
+// Start implements manager.Runnable, it exports the spans until stop is
+// closed, and then the spans left.
+func (t *Tracer) Start(stop <-chan struct{}) error {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+			defer cancel()
+
+			return t.Flush(ctx)
+		case <-ticker.C:
+			if err := t.Flush(context.Background()); err != nil {
+				t.Log.Error(err, "unable to export spans")
+			}
+		}
+	}
+}
+
+// Flush exports the finished spans. The spans are dropped when the export
+// fails, they are not retried.
+func (t *Tracer) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	spans, dropped := t.queue, t.dropped
+	t.queue, t.dropped = nil, 0
+	t.mu.Unlock()
+
+	if dropped > 0 {
+		t.Log.Info(fmt.Sprintf("%d spans dropped, the export queue was full", dropped))
+	}
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(t.request(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.Endpoint+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error when exporting %d spans: %v", len(spans), err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		return fmt.Errorf("error when exporting %d spans: %s: %s", len(spans), resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// The types of the OTLP/HTTP JSON encoding of an ExportTraceServiceRequest.
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []jsonSpan `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type jsonSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            *status    `json:"status,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// scopeName is the instrumentation scope of the spans.
+const scopeName = "github.com/yriveiro/gcs-bucket-operator"
+
+func (t *Tracer) request(spans []*Span) *exportRequest {
+	result := make([]jsonSpan, 0, len(spans))
+
+	for _, s := range spans {
+		js := jsonSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        keyValues(s.attrs),
+		}
+
+		if s.parentID != ([8]byte{}) {
+			js.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+
+		if s.err != nil {
+			js.Status = &status{Code: statusError, Message: s.err.Error()}
+		}
+
+		result = append(result, js)
+	}
+
+	return &exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: keyValues([]Attribute{String("service.name", t.Service)})},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: scopeName}, Spans: result}},
+	}}}
+}
+
+func keyValues(attrs []Attribute) []keyValue {
+	result := make([]keyValue, 0, len(attrs))
+	for _, a := range attrs {
+		result = append(result, keyValue{Key: a.Key, Value: anyValue{StringValue: a.Value}})
+	}
+
+	return result
+}
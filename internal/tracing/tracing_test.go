@@ -0,0 +1,148 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend/fake"
+	"github.com/yriveiro/gcs-bucket-operator/internal/tracing/tracingtest"
+)
+
+func TestExport(t *testing.T) {
+	c := tracingtest.NewCollector()
+	defer c.Close()
+
+	tr := New(c.Endpoint(), "test", logf.NullLogger{})
+
+	ctx, parent := tr.StartSpan(context.Background(), "Reconcile", String("namespace", "default"))
+	_, child := tr.StartSpan(ctx, "HandleFinalizer")
+	child.End(errors.New("failed"))
+	parent.End(nil)
+
+	if err := tr.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := c.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans exported, want 2", len(spans))
+	}
+
+	f, r := spans[0], spans[1]
+
+	if r.Name != "Reconcile" || r.ParentSpanID != "" || r.Attributes["namespace"] != "default" || r.Error != "" {
+		t.Errorf("unexpected root span %+v", r)
+	}
+
+	if f.Name != "HandleFinalizer" || f.TraceID != r.TraceID || f.ParentSpanID != r.SpanID || f.Error != "failed" {
+		t.Errorf("unexpected child span %+v of %+v", f, r)
+	}
+
+	if r.Resource["service.name"] != "test" {
+		t.Errorf("resource = %v, want service.name test", r.Resource)
+	}
+
+	if err := tr.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(c.Spans()); n != 2 {
+		t.Errorf("%d spans exported after a flush without spans, want 2", n)
+	}
+}
+
+func TestExportError(t *testing.T) {
+	c := tracingtest.NewCollector()
+	c.Close()
+
+	tr := New(c.Endpoint(), "test", logf.NullLogger{})
+
+	_, s := tr.StartSpan(context.Background(), "Reconcile")
+	s.End(nil)
+
+	if err := tr.Flush(context.Background()); err == nil {
+		t.Error("expected an error exporting to a stopped collector")
+	}
+}
+
+func TestWrap(t *testing.T) {
+	c := tracingtest.NewCollector()
+	defer c.Close()
+
+	tr := New(c.Endpoint(), "test", logf.NullLogger{})
+	be := tr.Wrap(fake.New())
+
+	// without a span in the context the operations are not recorded
+	if err := be.Create(context.Background(), "p1", &backend.BucketAttrs{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, s := tr.StartSpan(context.Background(), "Reconcile")
+
+	if _, err := be.Get(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := be.Get(ctx, "b"); err != backend.ErrBucketNotExist {
+		t.Fatalf("err = %v, want %v", err, backend.ErrBucketNotExist)
+	}
+
+	s.End(nil)
+
+	if err := tr.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := c.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("%d spans exported, want 3: %+v", len(spans), spans)
+	}
+
+	for i, bucket := range []string{"a", "b"} {
+		if spans[i].Name != "Get" || spans[i].Attributes["bucket"] != bucket || spans[i].ParentSpanID != spans[2].SpanID {
+			t.Errorf("unexpected span %+v", spans[i])
+		}
+	}
+
+	if spans[0].Error != "" || spans[1].Error != backend.ErrBucketNotExist.Error() {
+		t.Errorf("unexpected errors %q and %q", spans[0].Error, spans[1].Error)
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tr *Tracer
+
+	ctx, s := tr.StartSpan(context.Background(), "Reconcile")
+	s.SetAttributes(String("bucket", "a"))
+	s.End(nil)
+
+	if ctx != context.Background() {
+		t.Error("expected the context to be returned as is")
+	}
+
+	be := fake.New()
+	if tr.Wrap(be) != be {
+		t.Error("expected the backend to be returned as is")
+	}
+}
@@ -0,0 +1,165 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracingtest implements an in-process collector receiving the
+// spans exported with the OTLP/HTTP protocol, JSON encoded.
+package tracingtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Span is a span received by the collector.
+type Span struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Attributes   map[string]string
+
+	// Error is the message of the status of the span when it's an error.
+	Error string
+
+	// Resource are the attributes of the resource of the span, e.g.
+	// service.name.
+	Resource map[string]string
+}
+
+// Collector records the spans exported to it.
+type Collector struct {
+	srv *httptest.Server
+
+	mu    sync.Mutex
+	spans []Span
+}
+
+// NewCollector starts a collector.
+func NewCollector() *Collector {
+	c := &Collector{}
+	c.srv = httptest.NewServer(http.HandlerFunc(c.serveHTTP))
+
+	return c
+}
+
+// Endpoint returns the base URL of the collector.
+func (c *Collector) Endpoint() string {
+	return c.srv.URL
+}
+
+// Close stops the collector.
+func (c *Collector) Close() {
+	c.srv.Close()
+}
+
+// Spans returns the spans received.
+func (c *Collector) Spans() []Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Span(nil), c.spans...)
+}
+
+type keyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type exportRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []keyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []struct {
+				TraceID      string     `json:"traceId"`
+				SpanID       string     `json:"spanId"`
+				ParentSpanID string     `json:"parentSpanId"`
+				Name         string     `json:"name"`
+				Attributes   []keyValue `json:"attributes"`
+				Status       struct {
+					Code    int    `json:"code"`
+					Message string `json:"message"`
+				} `json:"status"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func attributes(kvs []keyValue) map[string]string {
+	result := map[string]string{}
+	for _, kv := range kvs {
+		result[kv.Key] = kv.Value.StringValue
+	}
+
+	return result
+}
+
+func (c *Collector) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+
+		return
+	}
+
+	var req exportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, rs := range req.ResourceSpans {
+		resource := attributes(rs.Resource.Attributes)
+
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				span := Span{
+					TraceID:      s.TraceID,
+					SpanID:       s.SpanID,
+					ParentSpanID: s.ParentSpanID,
+					Name:         s.Name,
+					Attributes:   attributes(s.Attributes),
+					Resource:     resource,
+				}
+
+				// STATUS_CODE_ERROR
+				if s.Status.Code == 2 {
+					span.Error = s.Status.Message
+				}
+
+				c.spans = append(c.spans, span)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte("{}"))
+}
@@ -0,0 +1,274 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package usage measures the number of objects and the bytes stored in the
+// buckets by listing their objects.
+package usage
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+)
+
+// Usage is the number of objects and the bytes stored in a bucket.
+type Usage struct {
+	Objects int64
+	Bytes   int64
+
+	// Estimated is true when the usage was extrapolated from a sample of
+	// the objects.
+	Estimated bool
+}
+
+// maxDepth bounds the length of the prefixes sampled.
+const maxDepth = 4
+
+// DefaultMaxCalls is the default number of listings of a measurement.
+const DefaultMaxCalls = 500
+
+// errBudget is returned by the listings once the budget of the measurement
+// is spent.
+var errBudget = errors.New("usage measurement budget spent")
+
+// Meter measures the usage of the buckets. Buckets with up to MaxObjects
+// objects are listed entirely, the usage of larger buckets is estimated:
+// the first characters of the object names are found and the objects of
+// SamplePrefixes of them, chosen at random, are listed and extrapolated to
+// the rest. Prefixes too large to list are sampled in turn.
+//
+// A measurement lists at most MaxListedObjects objects in MaxCalls listings,
+// the usage is estimated from the objects listed when the budget is spent.
+type Meter struct {
+	MaxObjects     int64
+	SamplePrefixes int
+
+	MaxListedObjects int64
+	MaxCalls         int
+
+	// alphabet are the first characters of the object names probed when
+	// sampling.
+	alphabet []byte
+	intn     func(n int) int
+}
+
+// NewMeter returns a meter listing up to maxObjects objects of each bucket
+// or prefix, and sampling samplePrefixes prefixes of larger buckets. A
+// measurement lists up to maxObjects objects of the bucket and of each
+// sampled prefix, in at most maxCalls listings.
+func NewMeter(maxObjects int64, samplePrefixes, maxCalls int) *Meter {
+	m := &Meter{
+		MaxObjects:       maxObjects,
+		SamplePrefixes:   samplePrefixes,
+		MaxListedObjects: maxObjects * int64(samplePrefixes+1),
+		MaxCalls:         maxCalls,
+		intn:             rand.Intn,
+	}
+
+	// the printable ASCII characters, object names outside of it are not
+	// sampled
+	for c := byte(' '); c <= '~'; c++ {
+		m.alphabet = append(m.alphabet, c)
+	}
+
+	return m
+}
+
+// measurement keeps what is left of the budget while measuring a bucket.
+type measurement struct {
+	m      *Meter
+	be     backend.BucketBackend
+	bucket string
+
+	calls   int
+	objects int64
+}
+
+// Measure returns the usage of the bucket.
+func (m *Meter) Measure(ctx context.Context, be backend.BucketBackend, bucket string) (Usage, error) {
+	s := &measurement{m: m, be: be, bucket: bucket, calls: m.MaxCalls, objects: m.MaxListedObjects}
+
+	u, complete, err := s.count(ctx, "")
+	if err == errBudget {
+		return Usage{Estimated: true}, nil
+	}
+
+	if err != nil || complete || m.SamplePrefixes <= 0 {
+		u.Estimated = !complete
+
+		return u, err
+	}
+
+	e, err := s.estimate(ctx, "", 1)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	// an estimate cut short by the budget can't be below the objects listed
+	if e.Objects < u.Objects {
+		e.Objects, e.Bytes = u.Objects, u.Bytes
+	}
+
+	return e, nil
+}
+
+// list lists the objects with the prefix, it returns errBudget once the
+// listings of the budget are spent.
+func (s *measurement) list(ctx context.Context, prefix string, fn func(*backend.ObjectAttrs) error) error {
+	if s.m.MaxCalls > 0 {
+		if s.calls <= 0 {
+			return errBudget
+		}
+
+		s.calls--
+	}
+
+	return s.be.Objects(ctx, s.bucket, prefix, fn)
+}
+
+// spent reports if the budget of the measurement is spent.
+func (s *measurement) spent() bool {
+	return (s.m.MaxCalls > 0 && s.calls <= 0) || (s.m.MaxListedObjects > 0 && s.objects <= 0)
+}
+
+// count lists up to MaxObjects objects with the prefix, or what is left of
+// the budget, it returns false when there are more.
+func (s *measurement) count(ctx context.Context, prefix string) (Usage, bool, error) {
+	var u Usage
+
+	max := s.m.MaxObjects
+	if s.m.MaxListedObjects > 0 && (max <= 0 || s.objects < max) {
+		max = s.objects
+	}
+
+	complete := true
+
+	err := s.list(ctx, prefix, func(o *backend.ObjectAttrs) error {
+		if max > 0 && u.Objects >= max {
+			complete = false
+
+			return backend.ErrStop
+		}
+
+		u.Objects++
+		u.Bytes += o.Size
+
+		return nil
+	})
+
+	s.objects -= u.Objects
+
+	return u, complete, err
+}
+
+// estimate extrapolates the usage of the objects with the prefix from a
+// sample of the longer prefixes. Once the budget is spent the prefixes found
+// and listed so far are used.
+func (s *measurement) estimate(ctx context.Context, prefix string, depth int) (Usage, error) {
+	var children []string
+
+	for _, c := range s.m.alphabet {
+		child := prefix + string(c)
+
+		found := false
+
+		err := s.list(ctx, child, func(*backend.ObjectAttrs) error {
+			found = true
+
+			return backend.ErrStop
+		})
+		if err == errBudget {
+			break
+		}
+
+		if err != nil {
+			return Usage{}, err
+		}
+
+		if found {
+			children = append(children, child)
+		}
+	}
+
+	sample := children
+	if len(children) > s.m.SamplePrefixes {
+		sample = make([]string, 0, s.m.SamplePrefixes)
+
+		for _, i := range s.m.permutation(len(children))[:s.m.SamplePrefixes] {
+			sample = append(sample, children[i])
+		}
+	}
+
+	var total Usage
+
+	listed := 0
+
+	for _, child := range sample {
+		if s.spent() {
+			break
+		}
+
+		u, complete, err := s.count(ctx, child)
+		if err == errBudget {
+			break
+		}
+
+		if err != nil {
+			return Usage{}, err
+		}
+
+		if !complete && depth < maxDepth && !s.spent() {
+			e, err := s.estimate(ctx, child, depth+1)
+			if err != nil {
+				return Usage{}, err
+			}
+
+			if e.Objects > u.Objects {
+				u = e
+			}
+		}
+
+		total.Objects += u.Objects
+		total.Bytes += u.Bytes
+		listed++
+	}
+
+	if listed > 0 {
+		total.Objects = total.Objects * int64(len(children)) / int64(listed)
+		total.Bytes = total.Bytes * int64(len(children)) / int64(listed)
+	}
+
+	total.Estimated = true
+
+	return total, nil
+}
+
+func (m *Meter) permutation(n int) []int {
+	p := make([]int, n)
+	for i := range p {
+		p[i] = i
+	}
+
+	for i := n - 1; i > 0; i-- {
+		j := m.intn(i + 1)
+		p[i], p[j] = p[j], p[i]
+	}
+
+	return p
+}
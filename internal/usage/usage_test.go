@@ -0,0 +1,167 @@
+/*
+
+Copyright 2021 Yago Riveiro <yago.riveiro@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package usage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend/fake"
+)
+
+func newTestBucket(t *testing.T, objects map[string]int64) *fake.Backend {
+	be := fake.New()
+
+	if err := be.Create(context.Background(), "p1", &backend.BucketAttrs{Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, size := range objects {
+		if err := be.PutObject("b", name, size); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return be
+}
+
+func TestMeasure(t *testing.T) {
+	be := newTestBucket(t, map[string]int64{"a": 1, "b/c": 2, "d": 4})
+
+	u, err := NewMeter(10, 2, DefaultMaxCalls).Measure(context.Background(), be, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (Usage{Objects: 3, Bytes: 7}); u != want {
+		t.Errorf("usage = %+v, want %+v", u, want)
+	}
+}
+
+func TestMeasureSamplesLargeBuckets(t *testing.T) {
+	objects := map[string]int64{}
+
+	// 4 prefixes of 10 objects of 10 bytes
+	for _, prefix := range []string{"a", "b", "c", "d"} {
+		for i := 0; i < 10; i++ {
+			objects[fmt.Sprintf("%s/%d", prefix, i)] = 10
+		}
+	}
+
+	be := newTestBucket(t, objects)
+
+	m := NewMeter(20, 2, DefaultMaxCalls)
+
+	u, err := m.Measure(context.Background(), be, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (Usage{Objects: 40, Bytes: 400, Estimated: true}); u != want {
+		t.Errorf("usage = %+v, want %+v", u, want)
+	}
+
+	// the prefixes larger than the cap are sampled in turn
+	m.MaxObjects = 5
+
+	if u, err = m.Measure(context.Background(), be, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (Usage{Objects: 40, Bytes: 400, Estimated: true}); u != want {
+		t.Errorf("usage = %+v, want %+v", u, want)
+	}
+}
+
+func TestMeasureWithoutSampling(t *testing.T) {
+	be := newTestBucket(t, map[string]int64{"a": 1, "b": 2, "c": 4})
+
+	u, err := NewMeter(2, 0, DefaultMaxCalls).Measure(context.Background(), be, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (Usage{Objects: 2, Bytes: 3, Estimated: true}); u != want {
+		t.Errorf("usage = %+v, want %+v", u, want)
+	}
+}
+
+func TestMeasureError(t *testing.T) {
+	be := newTestBucket(t, nil)
+
+	errList := errors.New("list failed")
+	be.SetError("Objects", errList)
+
+	if _, err := NewMeter(10, 2, DefaultMaxCalls).Measure(context.Background(), be, "b"); err != errList {
+		t.Errorf("err = %v, want %v", err, errList)
+	}
+}
+
+// countingBackend counts the listings of the objects.
+type countingBackend struct {
+	backend.BucketBackend
+
+	calls  int
+	listed int64
+}
+
+func (b *countingBackend) Objects(ctx context.Context, name, prefix string, fn func(*backend.ObjectAttrs) error) error {
+	b.calls++
+
+	return b.BucketBackend.Objects(ctx, name, prefix, func(o *backend.ObjectAttrs) error {
+		b.listed++
+
+		return fn(o)
+	})
+}
+
+func TestMeasureBudget(t *testing.T) {
+	objects := map[string]int64{}
+
+	// deep prefixes of every first character, sampled down to maxDepth
+	for _, first := range "abcdefghijklmnopqrstuvwxyz0123456789" {
+		for i := 0; i < 200; i++ {
+			objects[fmt.Sprintf("%c%c%c/%d", first, 'a'+i%5, 'a'+i%7, i)] = 1
+		}
+	}
+
+	be := &countingBackend{BucketBackend: newTestBucket(t, objects)}
+
+	m := NewMeter(50, 4, 150)
+
+	u, err := m.Measure(context.Background(), be, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if be.calls > 150 {
+		t.Errorf("Measure() made %d listings, want at most 150", be.calls)
+	}
+
+	// each probe lists a single object
+	if max := m.MaxListedObjects + int64(be.calls); be.listed > max {
+		t.Errorf("Measure() listed %d objects, want at most %d", be.listed, max)
+	}
+
+	if !u.Estimated || u.Objects < 50 {
+		t.Errorf("usage = %+v, want an estimate of at least the objects listed", u)
+	}
+}
@@ -19,7 +19,9 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"os"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -30,6 +32,16 @@ import (
 
 	storagev1 "github.com/yriveiro/gcs-bucket-operator/api/v1alpha1"
 	"github.com/yriveiro/gcs-bucket-operator/controllers"
+	"github.com/yriveiro/gcs-bucket-operator/internal/audit"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend/gcs"
+	"github.com/yriveiro/gcs-bucket-operator/internal/backend/s3"
+	"github.com/yriveiro/gcs-bucket-operator/internal/cel"
+	"github.com/yriveiro/gcs-bucket-operator/internal/gcp"
+	"github.com/yriveiro/gcs-bucket-operator/internal/poller"
+	"github.com/yriveiro/gcs-bucket-operator/internal/throttle"
+	"github.com/yriveiro/gcs-bucket-operator/internal/tracing"
+	"github.com/yriveiro/gcs-bucket-operator/internal/usage"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -38,6 +50,10 @@ var (
 	setupLog = ctrl.Log.WithName("setup")
 )
 
+// ruleCacheSize is the number of compiled rules of the bucket policies kept
+// in memory.
+const ruleCacheSize = 1024
+
 func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 
@@ -48,10 +64,108 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var clusterID string
+	var requireProjectBinding bool
+	var gcsEndpoint string
+	var gcsInsecure bool
+	var backendName string
+	var s3Endpoint string
+	var s3Region string
+	var s3Insecure bool
+	var bucketOpsRate float64
+	var bucketOpsBurst int
+	var backendTimeout time.Duration
+	var backendOperationTimeouts string
+	var breakerThreshold int
+	var breakerCooldown time.Duration
+	var resyncInterval time.Duration
+	var pollInterval time.Duration
+	var auditSubscription string
+	var pubsubEndpoint string
+	var auditWebhookAddr string
+	var usageInterval time.Duration
+	var usageMaxObjects int64
+	var usageSamplePrefixes int
+	var usageMaxCalls int
+	var otlpEndpoint string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&clusterID, "cluster-id", "",
+		"Identifier of the cluster, it's stamped in the managed GCS buckets. "+
+			"Buckets stamped by another cluster are never updated or deleted.")
+	flag.BoolVar(&requireProjectBinding, "require-project-binding", false,
+		"Refuse the buckets of namespaces not bound to a project with the "+
+			"storage.k8s.riveiro.io/project annotation.")
+	flag.StringVar(&gcsEndpoint, "gcs-endpoint", "",
+		"Endpoint of the GCS JSON API, e.g. an emulator. Defaults to the "+
+			gcp.EmulatorHostEnv+" environment variable when set, or the GCS endpoint.")
+	flag.BoolVar(&gcsInsecure, "gcs-insecure", false,
+		"Skip the verification of the TLS certificate of the GCS endpoint and "+
+			"don't send the operator credentials, for emulators only. Clients with "+
+			"their own credentials still verify the certificate.")
+	flag.StringVar(&backendName, "backend", "gcs",
+		"Storage service where the buckets are provisioned, gcs or s3. "+
+			"The s3 backend reads the credentials from the AWS_ACCESS_KEY_ID, "+
+			"AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.")
+	flag.StringVar(&s3Endpoint, "s3-endpoint", "",
+		"URL of the S3 service used by the s3 backend, e.g. http://minio:9000.")
+	flag.StringVar(&s3Region, "s3-region", s3.DefaultRegion,
+		"Region of the S3 service used by the s3 backend, it's accepted as bucket location.")
+	flag.BoolVar(&s3Insecure, "s3-insecure", false,
+		"Skip the verification of the TLS certificate of the S3 endpoint.")
+	flag.Float64Var(&bucketOpsRate, "bucket-ops-rate", 0.5,
+		"Bucket creations and deletions per second allowed in each project, "+
+			"reconciles above the rate are requeued. Zero disables the limit.")
+	flag.IntVar(&bucketOpsBurst, "bucket-ops-burst", 1,
+		"Bucket creations and deletions allowed in a burst in each project.")
+	flag.DurationVar(&backendTimeout, "backend-timeout", 30*time.Second,
+		"Timeout of each operation on the storage backend. Zero disables it.")
+	flag.StringVar(&backendOperationTimeouts, "backend-operation-timeouts", "",
+		"Timeouts of specific operations on the storage backend overriding "+
+			"--backend-timeout, e.g. Create=1m,Objects=5m.")
+	flag.IntVar(&breakerThreshold, "breaker-threshold", 5,
+		"Consecutive failures of the storage backend that stop the operations "+
+			"on it for --breaker-cooldown. Zero disables the circuit breaker.")
+	flag.DurationVar(&breakerCooldown, "breaker-cooldown", 30*time.Second,
+		"Time the operations on the storage backend are stopped after "+
+			"--breaker-threshold consecutive failures.")
+	flag.DurationVar(&resyncInterval, "resync-interval", 10*time.Minute,
+		"How often the GCS buckets are checked for changes made outside of the "+
+			"operator, unless the Bucket defines spec.resyncInterval. Zero disables it.")
+	flag.DurationVar(&pollInterval, "poll-interval", 0,
+		"How often the buckets of each project are listed to serve the reads of "+
+			"the reconciles and detect the buckets changed outside of the operator. "+
+			"Zero disables the poller, every reconcile reads its bucket.")
+	flag.StringVar(&auditSubscription, "audit-subscription", "",
+		"Pub/Sub subscription, as projects/<project>/subscriptions/<name>, of the topic of "+
+			"a log sink exporting the Cloud Audit Logs of the buckets. The buckets updated or "+
+			"deleted are reconciled right away instead of on the next resync.")
+	flag.StringVar(&pubsubEndpoint, "pubsub-endpoint", "",
+		"Endpoint of the Pub/Sub API, e.g. an emulator. Defaults to the "+
+			gcp.PubSubEmulatorHostEnv+" environment variable when set, or the Pub/Sub endpoint.")
+	flag.StringVar(&auditWebhookAddr, "audit-webhook-addr", "",
+		"The address of an HTTP webhook receiving the Cloud Audit Logs of the buckets, "+
+			"as log entries or Pub/Sub push messages. Disabled when empty.")
+	flag.DurationVar(&usageInterval, "usage-interval", 0,
+		"How often the objects and bytes stored in the buckets are measured by listing "+
+			"their objects, recorded in status.usage and exported as metrics. Zero disables it.")
+	flag.Int64Var(&usageMaxObjects, "usage-max-objects", 100000,
+		"Objects listed to measure a bucket, the usage of larger buckets is estimated "+
+			"from a sample of their prefixes. Zero lists every object.")
+	flag.IntVar(&usageSamplePrefixes, "usage-sample-prefixes", 4,
+		"Prefixes listed to estimate the usage of the buckets with more objects than "+
+			"--usage-max-objects. Zero reports the objects listed up to the limit.")
+	flag.IntVar(&usageMaxCalls, "usage-max-calls", usage.DefaultMaxCalls,
+		"Maximum listings made to measure a bucket. A measurement also lists at most "+
+			"--usage-max-objects objects for the bucket and for each sampled prefix, the usage "+
+			"is estimated from the objects listed once either budget is spent. Zero disables "+
+			"the limit of listings.")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", os.Getenv(tracing.EndpointEnv),
+		"Base URL of the OTLP/HTTP receiver of an OpenTelemetry collector the spans of the "+
+			"reconciles are exported to, e.g. http://otel-collector:4318. Defaults to the "+
+			tracing.EndpointEnv+" environment variable. Tracing is disabled when empty.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
@@ -68,22 +182,147 @@ func main() {
 		os.Exit(1)
 	}
 
-	storageClient, err := storage.NewClient(context.TODO())
+	var bucketBackend backend.BucketBackend
+	var clients *gcp.ClientCache
+	locations := storagev1.LocationValidator(storagev1.ValidateLocation)
+
+	switch backendName {
+	case "gcs":
+		gcsOptions := gcp.ClientOptions(gcsEndpoint, gcsInsecure)
+
+		storageClient, err := storage.NewClient(context.TODO(), gcsOptions...)
+		if err != nil {
+			setupLog.Error(err, "unable to create storage client")
+			os.Exit(1)
+		}
+
+		bucketBackend = gcs.New(storageClient)
+		// the clients of the provider configs and the impersonated service
+		// accounts authenticate with their own credentials
+		clients = gcp.NewClientCache(gcp.EndpointOptions(gcsEndpoint)...)
+	case "s3":
+		bucketBackend, err = s3.New(s3.Config{
+			Endpoint:        s3Endpoint,
+			Region:          s3Region,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			Insecure:        s3Insecure,
+		})
+		if err != nil {
+			setupLog.Error(err, "unable to create s3 backend")
+			os.Exit(1)
+		}
+
+		// buckets are created in the region of the service
+		locations = storagev1.RegionLocation(s3Region)
+	default:
+		setupLog.Error(fmt.Errorf("unknown backend %s", backendName), "unable to create storage backend")
+		os.Exit(1)
+	}
+
+	operationTimeouts, err := backend.ParseTimeouts(backendOperationTimeouts)
 	if err != nil {
-		setupLog.Error(err, "unable to create storage client")
+		setupLog.Error(err, "unable to parse the backend operation timeouts")
 		os.Exit(1)
 	}
 
+	var breaker *backend.Breaker
+	if breakerThreshold > 0 {
+		breaker = backend.NewBreaker(breakerThreshold, breakerCooldown)
+	}
+
+	timeouts := backend.Timeouts{Default: backendTimeout, Operations: operationTimeouts}
+
+	var bucketPoller *poller.Poller
+	if pollInterval > 0 {
+		if backendName != "gcs" {
+			setupLog.Error(fmt.Errorf("the %s backend can't list buckets", backendName), "unable to create poller")
+			os.Exit(1)
+		}
+
+		bucketPoller = poller.New(bucketBackend, pollInterval, ctrl.Log.WithName("poller"))
+	}
+
+	var auditSources []audit.Source
+
+	if auditSubscription != "" {
+		subscriber, err := audit.NewSubscriber(context.TODO(), auditSubscription,
+			ctrl.Log.WithName("audit").WithName("pubsub"), gcp.PubSubClientOptions(pubsubEndpoint)...)
+		if err != nil {
+			setupLog.Error(err, "unable to create pubsub subscriber")
+			os.Exit(1)
+		}
+
+		auditSources = append(auditSources, subscriber)
+	}
+
+	if auditWebhookAddr != "" {
+		auditSources = append(auditSources, &audit.Receiver{
+			Addr: auditWebhookAddr,
+			Log:  ctrl.Log.WithName("audit").WithName("webhook"),
+		})
+	}
+
+	var usageMeter *usage.Meter
+	if usageInterval > 0 {
+		usageMeter = usage.NewMeter(usageMaxObjects, usageSamplePrefixes, usageMaxCalls)
+	}
+
+	var tracer *tracing.Tracer
+	if otlpEndpoint != "" {
+		tracer = tracing.New(otlpEndpoint, "gcs-bucket-operator", ctrl.Log.WithName("tracing"))
+	}
+
+	var limiter *throttle.Limiter
+	if bucketOpsRate > 0 {
+		limiter = throttle.New(bucketOpsRate, bucketOpsBurst)
+	}
+
+	// the compiled rules of the bucket policies are shared by the webhook and
+	// the reconcilers
+	rules := cel.NewCache(ruleCacheSize, storagev1.RuleVariables...)
+
 	if err = (&controllers.BucketReconciler{
-		Client:        mgr.GetClient(),
-		StorageClient: storageClient,
-		Log:           ctrl.Log.WithName("controllers").WithName("Bucket"),
-		Scheme:        mgr.GetScheme(),
-		Recorder:      mgr.GetEventRecorderFor("bucket-controller"),
+		Client:    mgr.GetClient(),
+		Backend:   bucketBackend,
+		Clients:   clients,
+		Limiter:   limiter,
+		Timeouts:  timeouts,
+		Breaker:   breaker,
+		Poller:    bucketPoller,
+		Log:       ctrl.Log.WithName("controllers").WithName("Bucket"),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("bucket-controller"),
+		ClusterID: clusterID,
+
+		RequireProjectBinding: requireProjectBinding,
+		ResyncInterval:        resyncInterval,
+		AuditSources:          auditSources,
+		Usage:                 usageMeter,
+		UsageInterval:         usageInterval,
+		Tracer:                tracer,
+		Rules:                 rules,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Bucket")
 		os.Exit(1)
 	}
+	if err = (&controllers.BucketPolicyReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("BucketPolicy"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("bucketpolicy-controller"),
+		Rules:    rules,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "BucketPolicy")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&storagev1.Bucket{}).SetupWebhookWithManager(mgr, rules, locations, requireProjectBinding); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Bucket")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")